@@ -49,18 +49,34 @@ COPYRIGHT:
 	app.Usage = "Experimental Distributed Hash Table"
 
 	app.Flags = []cli.Flag{
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "c, connect",
-			Usage: "Connect to bootstrap node ip:port",
+			Usage: "Connect to bootstrap node ip:port or dns://seed[:port], can be repeated",
 		},
 		cli.StringFlag{
 			Name:  "l, listen",
 			Usage: "Listening address and port",
 			Value: ":3000",
 		},
+		cli.StringFlag{
+			Name:  "stun",
+			Usage: "Discover the external address with the STUN `server` ip:port",
+		},
+		cli.StringFlag{
+			Name:  "network-id",
+			Usage: "Only talk to the nodes of the network `id`",
+		},
+		cli.StringFlag{
+			Name:  "http",
+			Usage: "Serve /status, /stats, /routing and /store as JSON on `addr`",
+		},
+		cli.BoolFlag{
+			Name:  "m, mdns",
+			Usage: "Discover peers on the local network",
+		},
 		cli.BoolFlag{
 			Name:  "i, interactif",
-			Usage: "Interactif",
+			Usage: "Interactive console to put, get and list peers and stats, type 'h' for the commands",
 		},
 		cli.BoolFlag{
 			Name:  "s, store",
@@ -101,12 +117,16 @@ func parseArgs(done func(dht.DhtOptions, *cli.Context)) {
 	app.Action = func(c *cli.Context) error {
 		options := dht.DhtOptions{
 			ListenAddr:    c.String("l"),
-			BootstrapAddr: c.String("c"),
+			BootstrapAddr: c.StringSlice("c"),
 			Verbose:       c.Int("v"),
 			Stats:         c.Bool("s"),
 			Interactif:    c.Bool("i"),
+			Mdns:          c.Bool("m"),
+			StunServer:    c.String("stun"),
+			HttpAddr:      c.String("http"),
+			NetworkId:     c.String("network-id"),
 			Cluster:       c.Int("n"),
-			// OnStore:       func(dht.Packet) interface{} {},
+			// Validator:     dht.AcceptAllValidator{},
 		}
 
 		if options.Cluster > 0 {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	CLIENT_TIMEOUT = time.Minute
+)
+
+// client talks to the admin API of a node started with HttpAdmin
+type client struct {
+	addr string
+	http *http.Client
+}
+
+func newClient(c *cli.Context) *client {
+	return &client{
+		addr: c.GlobalString("admin"),
+		http: &http.Client{Timeout: CLIENT_TIMEOUT},
+	}
+}
+
+func (this *client) get(path string, query map[string]string) ([]byte, error) {
+	return this.do(http.MethodGet, path, query, nil)
+}
+
+func (this *client) getJson(path string, query map[string]string, res interface{}) error {
+	body, err := this.get(path, query)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, res)
+}
+
+// post sends value and decodes the answer into res, unless it is nil
+func (this *client) post(path string, query map[string]string, value []byte, res interface{}) error {
+	body, err := this.do(http.MethodPost, path, query, value)
+
+	if err != nil || res == nil {
+		return err
+	}
+
+	return json.Unmarshal(body, res)
+}
+
+func (this *client) do(method string, path string, query map[string]string, value []byte) ([]byte, error) {
+	values := url.Values{}
+
+	for k, v := range query {
+		values.Set(k, v)
+	}
+
+	u := url.URL{Scheme: "http", Host: this.addr, Path: path, RawQuery: values.Encode()}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(value))
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := this.http.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
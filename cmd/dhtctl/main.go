@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+	"github.com/urfave/cli"
+)
+
+const (
+	ADMIN_ADDR   = "127.0.0.1:3080"
+	STOP_TIMEOUT = time.Second * 10
+)
+
+// Runs a node serving the admin API, or operates the one running at --admin
+func main() {
+	app := cli.NewApp()
+
+	app.Name = "dhtctl"
+	app.Version = "0.2.0"
+	app.Usage = "Run and operate a DHT node"
+	app.UsageText = "dhtctl [--admin addr] command [arguments]"
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "a, admin",
+			Usage: "HTTP admin `addr` of the running node",
+			Value: ADMIN_ADDR,
+		},
+	}
+
+	keyFlag := cli.StringFlag{
+		Name:  "k, key",
+		Usage: "Use the hash of `key` instead of a hash",
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "run",
+			Usage: "Run a node until SIGINT or SIGTERM",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "l, listen",
+					Usage: "Listening address and port",
+					Value: ":3000",
+				},
+				cli.StringSliceFlag{
+					Name:  "c, connect",
+					Usage: "Connect to bootstrap node ip:port or dns://seed[:port], can be repeated",
+				},
+				cli.StringFlag{
+					Name:  "http",
+					Usage: "Serve the admin API on `addr`, a loopback one",
+					Value: ADMIN_ADDR,
+				},
+				cli.StringFlag{
+					Name:  "network-id",
+					Usage: "Only talk to the nodes of the network `id`",
+				},
+				cli.StringFlag{
+					Name:  "identity",
+					Usage: "Keep the identity of the node in `file`, sealed with $DHT_IDENTITY_PASS when set",
+				},
+				cli.IntFlag{
+					Name:  "v, verbose",
+					Value: 3,
+					Usage: "Verbose `level`, 0 for CRITICAL and 5 for DEBUG",
+				},
+			},
+			Action: run,
+		},
+		{
+			Name:   "status",
+			Usage:  "Print the status of the node",
+			Action: status,
+		},
+		{
+			Name:      "store",
+			Usage:     "Store value, or Stdin, and print its hash",
+			ArgsUsage: "[value]",
+			Flags:     []cli.Flag{keyFlag},
+			Action:    store,
+		},
+		{
+			Name:      "fetch",
+			Usage:     "Fetch hash and print its value to Stdout",
+			ArgsUsage: "[hash]",
+			Flags:     []cli.Flag{keyFlag},
+			Action:    fetch,
+		},
+		{
+			Name:      "ping",
+			Usage:     "Ping the node at addr and print the round trip time",
+			ArgsUsage: "addr",
+			Action:    ping,
+		},
+		{
+			Name:      "find-node",
+			Usage:     "Print the nodes closest to hash",
+			ArgsUsage: "[hash]",
+			Flags:     []cli.Flag{keyFlag},
+			Action:    findNode,
+		},
+		{
+			Name:      "broadcast",
+			Usage:     "Broadcast message, or Stdin, to the whole network",
+			ArgsUsage: "[message]",
+			Action:    broadcast,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, "dhtctl:", err)
+
+		os.Exit(1)
+	}
+}
+
+func run(c *cli.Context) error {
+	opts := []dht.Option{
+		dht.WithOptions(dht.DhtOptions{
+			Verbose:   c.Int("v"),
+			HttpAddr:  c.String("http"),
+			HttpAdmin: true,
+		}),
+		dht.WithListenAddr(c.String("l")),
+		dht.WithBootstrap(c.StringSlice("c")...),
+		dht.WithNetworkId(c.String("network-id")),
+	}
+
+	if len(c.String("identity")) > 0 {
+		opts = append(opts, dht.WithIdentity(c.String("identity"), os.Getenv("DHT_IDENTITY_PASS")))
+	}
+
+	node, err := dht.NewWithOptions(opts...)
+
+	if err != nil {
+		return err
+	}
+
+	if err := node.Start(); err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigs
+
+	ctx, cancel := context.WithTimeout(context.Background(), STOP_TIMEOUT)
+	defer cancel()
+
+	return node.Stop(ctx)
+}
+
+func status(c *cli.Context) error {
+	res := dht.Status{}
+
+	if err := newClient(c).getJson("/status", nil, &res); err != nil {
+		return err
+	}
+
+	fmt.Println("Hash:     ", res.Hash)
+	fmt.Println("Addrs:    ", strings.Join(res.Addrs, " "))
+	fmt.Println("Uptime:   ", res.Uptime.Round(time.Second))
+	fmt.Println("Peers:    ", res.Peers)
+	fmt.Println("Stored:   ", res.Stored)
+	fmt.Println("Providing:", res.Providing)
+
+	return nil
+}
+
+func store(c *cli.Context) error {
+	value, err := argOrStdin(c)
+
+	if err != nil {
+		return err
+	}
+
+	res := dht.StoreResult{}
+
+	if err := newClient(c).post("/store", keyQuery(c), value, &res); err != nil {
+		return err
+	}
+
+	fmt.Println(res.Hash, res.Nodes)
+
+	return nil
+}
+
+func fetch(c *cli.Context) error {
+	query, err := hashQuery(c)
+
+	if err != nil {
+		return err
+	}
+
+	value, err := newClient(c).get("/fetch", query)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(value)
+
+	return err
+}
+
+func ping(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("Usage: dhtctl ping addr")
+	}
+
+	res := dht.PingResult{}
+
+	if err := newClient(c).post("/ping", map[string]string{"addr": c.Args().First()}, nil, &res); err != nil {
+		return err
+	}
+
+	fmt.Println(res.Addr, res.RTT)
+
+	return nil
+}
+
+func findNode(c *cli.Context) error {
+	query, err := hashQuery(c)
+
+	if err != nil {
+		return err
+	}
+
+	res := []dht.NodeResult{}
+
+	if err := newClient(c).getJson("/findnode", query, &res); err != nil {
+		return err
+	}
+
+	for _, node := range res {
+		fmt.Println(node.Hash, node.Addr)
+	}
+
+	return nil
+}
+
+func broadcast(c *cli.Context) error {
+	value, err := argOrStdin(c)
+
+	if err != nil {
+		return err
+	}
+
+	return newClient(c).post("/broadcast", nil, value, nil)
+}
+
+// argOrStdin is the arguments joined by spaces, or Stdin without any
+func argOrStdin(c *cli.Context) ([]byte, error) {
+	if c.NArg() > 0 {
+		return []byte(strings.Join(c.Args(), " ")), nil
+	}
+
+	return io.ReadAll(os.Stdin)
+}
+
+func keyQuery(c *cli.Context) map[string]string {
+	if len(c.String("k")) == 0 {
+		return nil
+	}
+
+	return map[string]string{"key": c.String("k")}
+}
+
+// hashQuery is the --key flag, or the hash argument checked to be hex
+func hashQuery(c *cli.Context) (map[string]string, error) {
+	if query := keyQuery(c); query != nil {
+		return query, nil
+	}
+
+	if c.NArg() != 1 {
+		return nil, errors.New("Expected a hash or --key")
+	}
+
+	if _, err := hex.DecodeString(c.Args().First()); err != nil {
+		return nil, fmt.Errorf("Invalid hash: %v", err)
+	}
+
+	return map[string]string{"hash": c.Args().First()}, nil
+}
@@ -0,0 +1,260 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type StoreResult struct {
+	Hash  string `json:"hash"`
+	Nodes int    `json:"nodes"`
+}
+
+type PingResult struct {
+	Addr string        `json:"addr"`
+	RTT  time.Duration `json:"rtt"`
+}
+
+type NodeResult struct {
+	Hash  string   `json:"hash"`
+	Addr  string   `json:"addr"`
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// handleAdmin serves the operations of dhtctl on the HTTP server, when
+// HttpAdmin is set. They change the network without any authentication, so
+// HttpAddr must be a loopback address, and the server is wrapped in localOnly
+func (this *Dht) handleAdmin(mux *http.ServeMux) {
+	// GET /fetch?hash= or ?key= answers the raw value, or its JSON when it
+	// is not a []byte
+	mux.HandleFunc("/fetch", func(w http.ResponseWriter, r *http.Request) {
+		hash, err := this.queryHash(r)
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		value, err := this.Fetch(hash)
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		if blob, ok := value.([]byte); ok {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(blob)
+
+			return
+		}
+
+		writeJson(w, value)
+	})
+
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		if !checkMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		addr := r.URL.Query().Get("addr")
+
+		if err := checkAddr(addr); err != nil {
+			writeError(w, badRequest{err})
+
+			return
+		}
+
+		rtt, err := this.Ping(addr)
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		writeJson(w, PingResult{Addr: addr, RTT: rtt})
+	})
+
+	mux.HandleFunc("/findnode", func(w http.ResponseWriter, r *http.Request) {
+		hash, err := this.queryHash(r)
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		contacts, err := this.FindNode(r.Context(), hash)
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		res := []NodeResult{}
+
+		for _, contact := range contacts {
+			res = append(res, NodeResult{
+				Hash:  hex.EncodeToString(contact.Hash),
+				Addr:  contact.Addr,
+				Addrs: contact.Addrs,
+			})
+		}
+
+		writeJson(w, res)
+	})
+
+	mux.HandleFunc("/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		if !checkMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		value, err := this.readBody(w, r)
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		this.Broadcast(value)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// adminStore stores the body of a POST /store, at the hash of the key
+// parameter when given
+func (this *Dht) adminStore(w http.ResponseWriter, r *http.Request) {
+	value, err := this.readBody(w, r)
+
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	var hash []byte
+	var nb int
+
+	if key := r.URL.Query().Get("key"); len(key) > 0 {
+		hash, nb, err = this.StoreAt(this.NewHash([]byte(key)), value)
+	} else {
+		hash, nb, err = this.Store(value)
+	}
+
+	if err == nil && nb == 0 {
+		err = ErrNoNodes
+	}
+
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	writeJson(w, StoreResult{Hash: hex.EncodeToString(hash), Nodes: nb})
+}
+
+// badRequest is an error of the client, answered with a 400
+type badRequest struct {
+	err error
+}
+
+func (this badRequest) Error() string {
+	return this.err.Error()
+}
+
+func (this *Dht) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(this.options.MaxValueSize)))
+
+	if err != nil {
+		return nil, badRequest{err}
+	}
+
+	if len(value) == 0 {
+		return nil, badRequest{errors.New("Empty value")}
+	}
+
+	return value, nil
+}
+
+// queryHash takes the hash parameter in hex, or hashes the key one
+func (this *Dht) queryHash(r *http.Request) ([]byte, error) {
+	if key := r.URL.Query().Get("key"); len(key) > 0 {
+		return this.NewHash([]byte(key)), nil
+	}
+
+	hash, err := hex.DecodeString(r.URL.Query().Get("hash"))
+
+	if err == nil {
+		err = this.checkHash(hash)
+	}
+
+	if err != nil {
+		return nil, badRequest{err}
+	}
+
+	return hash, nil
+}
+
+// localOnly refuses the requests carrying an Origin header, sent by the web
+// pages of a browser, and the ones for a Host that is not a loopback one, a
+// name rebound to 127.0.0.1 by its DNS, so that no page can reach the admin
+// operations
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+
+		if len(r.Header.Get("Origin")) > 0 || !isLoopback(net.JoinHostPort(host, "0")) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	return false
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var bad badRequest
+
+	switch {
+	case errors.As(err, &bad):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrNoNodes):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrTimeout):
+		status = http.StatusGatewayTimeout
+	}
+
+	http.Error(w, err.Error(), status)
+}
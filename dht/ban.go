@@ -0,0 +1,191 @@
+package dht
+
+import (
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_STRIKES         = 5
+	BAN_DURATION        = time.Hour
+	STRIKE_WINDOW       = time.Minute * 10
+	STRIKES_MAX_ENTRIES = 4096
+)
+
+// strikes counts the misbehaviors of a target since its first one, until
+// STRIKE_WINDOW passed
+type strikes struct {
+	count int
+	since time.Time
+}
+
+type BanList struct {
+	sync.Mutex
+	banned  map[string]time.Time
+	strikes map[string]strikes
+}
+
+func NewBanList() *BanList {
+	return &BanList{
+		banned:  make(map[string]time.Time),
+		strikes: make(map[string]strikes),
+	}
+}
+
+// hostKey is the key of an address in the ban list and the rate limiter:
+// its IP, or its /64 prefix for IPv6, so that changing the source port or the
+// interface ID of a prefix does not make a new peer. The loopback addresses
+// keep their port, the nodes of a host being told apart by it, and any other
+// target, a hash or a host name, is its own key
+func hostKey(target string) string {
+	host, _, err := net.SplitHostPort(target)
+
+	if err != nil {
+		host = target
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || ip.IsLoopback() {
+		return target
+	}
+
+	if ip.To4() != nil {
+		return ip.String()
+	}
+
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// Ban bans the IP of an address target, or its /64 prefix for IPv6
+func (this *BanList) Ban(target string, duration time.Duration) {
+	target = hostKey(target)
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.banned[target] = time.Now().Add(duration)
+	delete(this.strikes, target)
+}
+
+func (this *BanList) Unban(target string) {
+	target = hostKey(target)
+
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.banned, target)
+	delete(this.strikes, target)
+}
+
+func (this *BanList) IsBanned(target string) bool {
+	target = hostKey(target)
+
+	this.Lock()
+	defer this.Unlock()
+
+	until, ok := this.banned[target]
+
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(until) {
+		delete(this.banned, target)
+		return false
+	}
+
+	return true
+}
+
+// Strike counts a misbehavior of the IP of target and returns true when it
+// reached the maximum allowed strikes within STRIKE_WINDOW. Past
+// STRIKES_MAX_ENTRIES targets still in their window, as spoofed sources could
+// make, the new ones are not counted, for the history of the others to stay
+func (this *BanList) Strike(target string, max int) bool {
+	target = hostKey(target)
+
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+	entry, ok := this.strikes[target]
+
+	if !ok || now.Sub(entry.since) > STRIKE_WINDOW {
+		entry = strikes{since: now}
+	}
+
+	if !ok && len(this.strikes) >= STRIKES_MAX_ENTRIES {
+		this.pruneStrikes(now)
+	}
+
+	if !ok && len(this.strikes) >= STRIKES_MAX_ENTRIES {
+		return false
+	}
+
+	entry.count++
+	this.strikes[target] = entry
+
+	return entry.count >= max
+}
+
+// Prune forgets the expired bans and strikes
+func (this *BanList) Prune() {
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+
+	for target, until := range this.banned {
+		if now.After(until) {
+			delete(this.banned, target)
+		}
+	}
+
+	this.pruneStrikes(now)
+}
+
+// pruneStrikes must be called locked
+func (this *BanList) pruneStrikes(now time.Time) {
+	for target, entry := range this.strikes {
+		if now.Sub(entry.since) > STRIKE_WINDOW {
+			delete(this.strikes, target)
+		}
+	}
+}
+
+func (this *Dht) Ban(target string, duration time.Duration) {
+	this.bans.Ban(target, duration)
+
+	this.logger.Info("Banned", target, "for", duration)
+
+	if hash, err := hex.DecodeString(target); err == nil {
+		if contact, err := this.routing.GetNode(hash); err == nil {
+			this.routing.RemoveNode(contact)
+		}
+	}
+
+	if contact, err := this.routing.GetByAddr(target); err == nil {
+		this.routing.RemoveNode(contact)
+	}
+}
+
+func (this *Dht) Unban(target string) {
+	this.bans.Unban(target)
+}
+
+func (this *Dht) IsBanned(target string) bool {
+	return this.bans.IsBanned(target)
+}
+
+func (this *Dht) isContactBanned(contact PacketContact) bool {
+	return this.bans.IsBanned(contact.Addr) || this.bans.IsBanned(hex.EncodeToString(contact.Hash))
+}
+
+func (this *Dht) strike(target string) {
+	if this.bans.Strike(target, this.options.MaxStrikes) {
+		this.Ban(target, this.options.BanDuration)
+	}
+}
@@ -0,0 +1,203 @@
+package dht
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BATCH_WINDOW = time.Millisecond * 2
+	MAX_BATCH    = 16
+
+	// a batch may be fragmented by IP, the datagrams it saves being worth
+	// more than the occasional loss of a whole batch
+	BATCH_SIZE = 1024 * 4
+)
+
+var errInvalidBatch = errors.New("Invalid batch")
+
+// pendingBatch holds the signed packets waiting to be written to a peer in
+// a single datagram
+type pendingBatch struct {
+	node  *Node
+	blobs [][]byte
+	size  int
+	timer *time.Timer
+}
+
+type batcher struct {
+	sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+func newBatcher() *batcher {
+	return &batcher{
+		pending: make(map[string]*pendingBatch),
+	}
+}
+
+// Batch runs the calls concurrently against the node, their packets
+// coalesced into as few datagrams as the peer allows. The errors are
+// returned in the order of the calls
+func (this *Node) Batch(calls ...func(node *Node) error) []error {
+	node := *this
+	node.batching = true
+
+	errs := make([]error, len(calls))
+
+	var wg sync.WaitGroup
+
+	for i, call := range calls {
+		wg.Add(1)
+
+		go func(i int, call func(node *Node) error) {
+			defer wg.Done()
+
+			errs[i] = call(&node)
+		}(i, call)
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// batchWindow is how long a packet to the node waits for others, or 0 when
+// it is written right away
+func (this *Node) batchWindow() time.Duration {
+	if !this.contact.Has(CAP_BATCH) {
+		return 0
+	}
+
+	if this.dht.options.BatchWindow > 0 {
+		return this.dht.options.BatchWindow
+	}
+
+	if this.batching {
+		return BATCH_WINDOW
+	}
+
+	return 0
+}
+
+// writeBatched queues a signed packet small enough to share a datagram. The
+// batch is written once full or when the window expires
+func (this *Node) writeBatched(blob []byte, window time.Duration) {
+	key := hex.EncodeToString(this.contact.Hash)
+
+	// the blob is a pooled buffer, given back once send returns
+	blob = append([]byte{}, blob...)
+
+	full := []*pendingBatch{}
+
+	this.dht.batches.Lock()
+
+	batch, ok := this.dht.batches.pending[key]
+
+	if ok && batch.size+len(blob) > BATCH_SIZE {
+		full = append(full, this.dht.batches.take(key, batch))
+		ok = false
+	}
+
+	if !ok {
+		batch = &pendingBatch{node: this}
+		batch.timer = time.AfterFunc(window, func() {
+			this.dht.batches.Lock()
+			expired := this.dht.batches.take(key, batch)
+			this.dht.batches.Unlock()
+
+			expired.write()
+		})
+
+		this.dht.batches.pending[key] = batch
+	}
+
+	batch.blobs = append(batch.blobs, blob)
+	batch.size += len(blob)
+
+	if len(batch.blobs) >= MAX_BATCH {
+		full = append(full, this.dht.batches.take(key, batch))
+	}
+
+	this.dht.batches.Unlock()
+
+	// written unlocked, as a relayed datagram is sent again
+	for _, batch := range full {
+		batch.write()
+	}
+}
+
+// take removes the batch if still pending, and returns it. A batch already
+// taken is returned empty
+func (this *batcher) take(key string, batch *pendingBatch) *pendingBatch {
+	if this.pending[key] != batch {
+		return &pendingBatch{}
+	}
+
+	batch.timer.Stop()
+	delete(this.pending, key)
+
+	return batch
+}
+
+// write sends the batch in one datagram. The packets lost with it are left
+// to their timeouts, like any packet lost on the wire
+func (this *pendingBatch) write() {
+	if len(this.blobs) == 0 {
+		return
+	}
+
+	fragment := Fragment{Total: 1, Batch: this.blobs}
+
+	if len(this.blobs) == 1 {
+		fragment = Fragment{Total: 1, Data: this.blobs[0]}
+	}
+
+	datagram := getBuffer()
+	defer putBuffer(datagram)
+
+	datagram.Write(this.node.dht.magic)
+
+	err := gob.NewEncoder(datagram).Encode(fragment)
+
+	if err == nil {
+		err = this.node.writeDatagram(datagram.Bytes())
+	}
+
+	if err != nil {
+		this.node.dht.logger.Warning(this.node, "x BATCH", err)
+
+		return
+	}
+
+	this.node.dht.logger.Debug(this.node, "< BATCH", LogField{"packets", len(this.blobs)})
+}
+
+// flushAll writes every pending batch, on stop
+func (this *batcher) flushAll() {
+	this.Lock()
+
+	batches := []*pendingBatch{}
+
+	for key, batch := range this.pending {
+		batches = append(batches, this.take(key, batch))
+	}
+
+	this.Unlock()
+
+	for _, batch := range batches {
+		batch.write()
+	}
+}
+
+// unbatch returns the packets of a batch fragment
+func (this *Dht) unbatch(fragment Fragment) ([][]byte, error) {
+	if fragment.Total != 1 || len(fragment.Data) > 0 || len(fragment.Batch) > MAX_BATCH {
+		return nil, errInvalidBatch
+	}
+
+	return fragment.Batch, nil
+}
@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// benchNodes starts two nodes sharing a MemoryTransport, so that the
+// benchmarks measure the RPC path without the noise of the network, and
+// returns the client's Node for the server
+func benchNodes(b *testing.B) *Node {
+	transport := NewMemoryTransport()
+
+	server := benchStart(b, transport, "127.0.0.1:4000", nil)
+	client := benchStart(b, transport, "127.0.0.1:4001", []string{"127.0.0.1:4000"})
+
+	b.Cleanup(func() {
+		client.Stop(context.Background())
+		server.Stop(context.Background())
+	})
+
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:4000")
+
+	return NewNode(client, addr, server.hash)
+}
+
+func benchStart(b *testing.B, transport Transport, addr string, bootstrap []string) *Dht {
+	node := New(DhtOptions{
+		ListenAddr:        addr,
+		BootstrapAddr:     bootstrap,
+		Transport:         transport,
+		RateLimit:         -1,
+		NoRepublishOnExit: true,
+	})
+
+	if err := node.Start(); err != nil {
+		b.Fatal("Error starting node", addr, ":", err)
+	}
+
+	return node
+}
+
+// BenchmarkPing is a whole round trip: the request, its timer and pending
+// call, and the answer
+func BenchmarkPing(b *testing.B) {
+	node := benchNodes(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := node.Ping(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSend only writes packets that expect no answer: the encoding,
+// signing and fragmenting of each send
+func BenchmarkSend(b *testing.B) {
+	node := benchNodes(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		node.send(node.newPacket(COMMAND_PONG, []byte{}, nil))
+	}
+}
@@ -0,0 +1,155 @@
+package dht
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	BREAKER_THRESHOLD    = 3
+	BREAKER_COOLDOWN     = time.Second * 10
+	MAX_BREAKER_COOLDOWN = time.Minute * 10
+)
+
+// peerBreaker counts the failed requests in a row to a peer. Once open, the
+// requests fail at once until the cooldown is over, then a single probe is
+// let through: its answer closes the circuit, its failure opens it again
+// for twice as long
+type peerBreaker struct {
+	failures    int
+	cooldown    time.Duration
+	openUntil   time.Time
+	probing     bool
+	lastFailure time.Time
+}
+
+type breakerList struct {
+	sync.Mutex
+	peers     map[string]*peerBreaker
+	lastPrune time.Time
+}
+
+func newBreakerList() *breakerList {
+	return &breakerList{
+		peers: make(map[string]*peerBreaker),
+	}
+}
+
+// allow is false while the circuit is open, and lets a single probe through
+// once the cooldown is over
+func (this *breakerList) allow(key string) bool {
+	this.Lock()
+	defer this.Unlock()
+
+	breaker, ok := this.peers[key]
+
+	if !ok || breaker.openUntil.IsZero() {
+		return true
+	}
+
+	if time.Now().Before(breaker.openUntil) || breaker.probing {
+		return false
+	}
+
+	breaker.probing = true
+
+	return true
+}
+
+func (this *breakerList) success(key string) {
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.peers, key)
+}
+
+// release lets another probe through, when the last one ended neither in
+// an answer nor in a timeout
+func (this *breakerList) release(key string) {
+	this.Lock()
+	defer this.Unlock()
+
+	if breaker, ok := this.peers[key]; ok {
+		breaker.probing = false
+	}
+}
+
+// failure counts a failed request, and returns the cooldown when it opens
+// the circuit
+func (this *breakerList) failure(key string, threshold int, cooldown time.Duration) (time.Duration, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+
+	this.prune(now)
+
+	breaker, ok := this.peers[key]
+
+	if !ok {
+		breaker = &peerBreaker{}
+		this.peers[key] = breaker
+	}
+
+	breaker.failures++
+	breaker.lastFailure = now
+
+	switch {
+	case breaker.probing:
+		breaker.probing = false
+		breaker.cooldown *= 2
+
+		if breaker.cooldown > MAX_BREAKER_COOLDOWN {
+			breaker.cooldown = MAX_BREAKER_COOLDOWN
+		}
+	case breaker.openUntil.IsZero() && breaker.failures >= threshold:
+		breaker.cooldown = cooldown
+	default:
+		return 0, false
+	}
+
+	breaker.openUntil = now.Add(breaker.cooldown)
+
+	return breaker.cooldown, true
+}
+
+// prune forgets the peers without failure for the longest cooldown, at most
+// once per BREAKER_COOLDOWN
+func (this *breakerList) prune(now time.Time) {
+	if now.Sub(this.lastPrune) < BREAKER_COOLDOWN {
+		return
+	}
+
+	this.lastPrune = now
+
+	for key, breaker := range this.peers {
+		if now.Sub(breaker.lastFailure) > MAX_BREAKER_COOLDOWN*2 {
+			delete(this.peers, key)
+		}
+	}
+}
+
+func (this *breakerList) open() int {
+	this.Lock()
+	defer this.Unlock()
+
+	res := 0
+	now := time.Now()
+
+	for _, breaker := range this.peers {
+		if now.Before(breaker.openUntil) {
+			res++
+		}
+	}
+
+	return res
+}
+
+func (this *Node) breakerKey() string {
+	if len(this.contact.Hash) == 0 {
+		return this.contact.Addr
+	}
+
+	return hex.EncodeToString(this.contact.Hash)
+}
@@ -0,0 +1,164 @@
+package dht
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	BROADCAST_TTL     = 6
+	BROADCAST_FANOUT  = 6
+	BROADCAST_SEEN    = time.Minute * 10
+	BROADCAST_RETRIES = 3
+)
+
+// BroadcastResult tells how many of the known peers acknowledged a reliable
+// broadcast, and which ones did not
+type BroadcastResult struct {
+	Reached int
+	Total   int
+	Missing []PacketContact
+}
+
+// broadcastId is shared by all the packets of a reliable broadcast, each of
+// them having its own hash to be acknowledged
+func broadcastId(packet Packet) []byte {
+	if len(packet.Header.BroadcastId) > 0 {
+		return packet.Header.BroadcastId
+	}
+
+	return packet.Header.MessageHash
+}
+
+// markBroadcast returns false when the broadcast was already seen. Seen
+// hashes are kept for BROADCAST_SEEN, longer than any broadcast can last
+func (this *Dht) markBroadcast(hash []byte) bool {
+	key := hex.EncodeToString(hash)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.broadcasts[key]; ok {
+		return false
+	}
+
+	this.broadcasts[key] = time.Now()
+
+	return true
+}
+
+func (this *Dht) pruneBroadcasts() {
+	this.Lock()
+	defer this.Unlock()
+
+	for key, seen := range this.broadcasts {
+		if time.Since(seen) > BROADCAST_SEEN {
+			delete(this.broadcasts, key)
+		}
+	}
+}
+
+// broadcastPeers picks BroadcastFanout random contacts, other than the one
+// the broadcast came from
+func (this *Dht) broadcastPeers(from []byte) []PacketContact {
+	contacts := []PacketContact{}
+
+	for _, contact := range this.routing.GetAllNodes() {
+		if compare(contact.Hash, from) != 0 {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	rand.Shuffle(len(contacts), func(i, j int) {
+		contacts[i], contacts[j] = contacts[j], contacts[i]
+	})
+
+	if len(contacts) > this.options.BroadcastFanout {
+		contacts = contacts[:this.options.BroadcastFanout]
+	}
+
+	return contacts
+}
+
+func (this *Dht) forwardBroadcast(packet Packet, from []byte) {
+	for _, contact := range this.broadcastPeers(from) {
+		addr, err := this.resolve(contact)
+
+		if err != nil {
+			continue
+		}
+
+		NewNodeContact(this, addr, contact).Broadcast(packet)
+	}
+}
+
+// BroadcastReliable sends data to every known peer, which acknowledge it
+// before gossiping it further. Peers that did not are retried up to
+// BROADCAST_RETRIES times
+func (this *Dht) BroadcastReliable(data interface{}) BroadcastResult {
+	id := this.newRandomHash()
+
+	this.markBroadcast(id)
+
+	pending := this.routing.GetAllNodes()
+
+	res := BroadcastResult{
+		Total: len(pending),
+	}
+
+	for try := 0; try <= BROADCAST_RETRIES && len(pending) > 0; try++ {
+		errs := make([]error, len(pending))
+
+		var wg sync.WaitGroup
+
+		for i, contact := range pending {
+			wg.Add(1)
+
+			go func(i int, contact PacketContact) {
+				defer wg.Done()
+
+				addr, err := this.resolve(contact)
+
+				if err == nil {
+					err = NewNodeContact(this, addr, contact).BroadcastAck(id, data)
+				}
+
+				errs[i] = err
+			}(i, contact)
+		}
+
+		wg.Wait()
+
+		missing := []PacketContact{}
+
+		for i, contact := range pending {
+			if errs[i] != nil {
+				missing = append(missing, contact)
+			}
+		}
+
+		pending = missing
+	}
+
+	res.Missing = pending
+	res.Reached = res.Total - len(pending)
+
+	this.logger.Debug("Reliable broadcast reached", res.Reached, "of", res.Total)
+
+	return res
+}
+
+func (this *Node) BroadcastAck(id []byte, data interface{}) error {
+	this.dht.logger.Debug(this, "< BROADCAST", LogField{"ack", true})
+
+	packet := this.newPacket(COMMAND_BROADCAST, []byte{}, data)
+	packet.Header.BroadcastId = id
+	packet.Header.TTL = this.dht.options.BroadcastTTL
+	packet.Header.Ack = true
+
+	_, err := this.request(packet)
+
+	return err
+}
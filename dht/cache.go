@@ -0,0 +1,79 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/champii/go-dht/dht/kad"
+)
+
+const (
+	CACHE_TTL     = time.Hour
+	MIN_CACHE_TTL = time.Minute
+)
+
+// pathCache records the nodes answering a fetch, for the value found to be
+// cached at the closest one that did not have it
+type pathCache struct {
+	sync.Mutex
+	hits   []*Node
+	misses []*Node
+}
+
+func (this *pathCache) answered(node *Node, res QueryResult) {
+	this.Lock()
+	defer this.Unlock()
+
+	if res.Found {
+		this.hits = append(this.hits, node)
+	} else {
+		this.misses = append(this.misses, node)
+	}
+}
+
+// cacheAlongPath stores the value at the closest node that did not have it,
+// for CacheTTL halved for each node closer to the hash that had it, so the
+// copies far from the key expire first. The copy has no delete token, it
+// only expires
+func (this *Dht) cacheAlongPath(hash []byte, value interface{}, path *pathCache) {
+	path.Lock()
+	defer path.Unlock()
+
+	var target *Node
+
+	for _, node := range path.misses {
+		if node.contact.Has(CAP_STORE) && (target == nil || kad.Closer(node.contact.Hash, target.contact.Hash, hash)) {
+			target = node
+		}
+	}
+
+	if target == nil {
+		return
+	}
+
+	ttl := this.options.CacheTTL
+
+	for _, node := range path.hits {
+		if kad.Closer(node.contact.Hash, target.contact.Hash, hash) {
+			ttl /= 2
+		}
+	}
+
+	if ttl < MIN_CACHE_TTL {
+		ttl = MIN_CACHE_TTL
+	}
+
+	inst := StoreInst{
+		Hash:       hash,
+		Data:       value,
+		Expiration: time.Now().Add(ttl).UnixNano(),
+	}
+
+	this.logger.Debug(target, "< CACHE", LogField{"ttl", ttl})
+
+	go func() {
+		if _, err := target.Store(inst); err != nil {
+			this.logger.Debug(target, "x CACHE", err)
+		}
+	}()
+}
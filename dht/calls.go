@@ -0,0 +1,190 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+)
+
+const (
+	CALL_SHARDS = 32
+)
+
+var (
+	errUnknownResponse = errors.New("Unknown response")
+	errWrongSender     = errors.New("Response from another peer than the one asked")
+)
+
+// callQueue holds the requests waiting for an answer. It is sharded by
+// message hash, so that concurrent RPCs seldom wait on the same lock, and
+// indexed by peer in each shard
+type callQueue struct {
+	shards [CALL_SHARDS]callShard
+}
+
+type callShard struct {
+	sync.Mutex
+	calls map[string]CallbackChan
+	peers map[string]map[string]bool
+}
+
+func newCallQueue() *callQueue {
+	res := &callQueue{}
+
+	for i := range res.shards {
+		res.shards[i].calls = make(map[string]CallbackChan)
+		res.shards[i].peers = make(map[string]map[string]bool)
+	}
+
+	return res
+}
+
+// message hashes are random, so their first byte spreads them evenly
+func (this *callQueue) shard(hash []byte) *callShard {
+	if len(hash) == 0 {
+		return &this.shards[0]
+	}
+
+	return &this.shards[int(hash[0])%CALL_SHARDS]
+}
+
+func (this *callQueue) add(hash []byte, cb CallbackChan) {
+	shard := this.shard(hash)
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	shard.calls[string(hash)] = cb
+
+	if _, ok := shard.peers[cb.peer]; !ok {
+		shard.peers[cb.peer] = make(map[string]bool)
+	}
+
+	shard.peers[cb.peer][string(hash)] = true
+}
+
+// take removes the pending call, for only one of its answer and its timeout
+// to be delivered
+func (this *callQueue) take(hash []byte) (CallbackChan, bool) {
+	shard := this.shard(hash)
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	cb, ok := shard.calls[string(hash)]
+
+	if ok {
+		shard.remove(string(hash), cb.peer)
+	}
+
+	return cb, ok
+}
+
+// takeAnswer removes the pending call answered by the packet, only when it
+// comes from the peer the request was sent to. A forged answer leaves the
+// call waiting for the real one
+func (this *callQueue) takeAnswer(hash []byte, sender PacketContact, source string) (CallbackChan, error) {
+	shard := this.shard(hash)
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	cb, ok := shard.calls[string(hash)]
+
+	if !ok {
+		return cb, errUnknownResponse
+	}
+
+	if !cb.answeredBy(sender, source) {
+		return cb, errWrongSender
+	}
+
+	shard.remove(string(hash), cb.peer)
+
+	return cb, nil
+}
+
+// answeredBy checks the signed hash of the sender when the request was sent
+// to a known node, and the source address unless relayed
+func (this CallbackChan) answeredBy(sender PacketContact, source string) bool {
+	if len(this.hash) > 0 && !bytes.Equal(this.hash, sender.Hash) {
+		return false
+	}
+
+	if len(source) == 0 {
+		return len(this.hash) > 0
+	}
+
+	for _, addr := range this.addrs {
+		if sameAddr(addr, source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameAddr is true when the source is the address, or the concrete address
+// answering for an unspecified one
+func sameAddr(addr string, source string) bool {
+	if addr == source {
+		return true
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	sourceHost, sourcePort, err2 := net.SplitHostPort(source)
+
+	if err != nil || err2 != nil || port != sourcePort {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+
+	if len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+		return true
+	}
+
+	return ip != nil && ip.Equal(net.ParseIP(sourceHost))
+}
+
+// takePeer removes the pending calls to the peer
+func (this *callQueue) takePeer(peer string) []CallbackChan {
+	res := []CallbackChan{}
+
+	for i := range this.shards {
+		shard := &this.shards[i]
+
+		shard.Lock()
+
+		for hash := range shard.peers[peer] {
+			res = append(res, shard.calls[hash])
+			shard.remove(hash, peer)
+		}
+
+		shard.Unlock()
+	}
+
+	return res
+}
+
+func (this *callShard) remove(hash string, peer string) {
+	delete(this.calls, hash)
+	delete(this.peers[peer], hash)
+
+	if len(this.peers[peer]) == 0 {
+		delete(this.peers, peer)
+	}
+}
+
+func (this *callQueue) Len() int {
+	res := 0
+
+	for i := range this.shards {
+		this.shards[i].Lock()
+		res += len(this.shards[i].calls)
+		this.shards[i].Unlock()
+	}
+
+	return res
+}
@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"strings"
+)
+
+// Capabilities is the bitmap of the features a node supports, advertised in
+// its contact
+type Capabilities uint64
+
+const (
+	CAP_STORE Capabilities = 1 << iota
+	CAP_RELAY
+	CAP_PUBSUB
+	CAP_TCP
+	CAP_BATCH
+	CAP_STREAM
+)
+
+const (
+	DEFAULT_CAPABILITIES = CAP_STORE | CAP_RELAY | CAP_PUBSUB | CAP_BATCH | CAP_STREAM
+)
+
+var capabilityNames = []string{"store", "relay", "pubsub", "tcp", "batch", "stream"}
+
+func (this Capabilities) Has(caps Capabilities) bool {
+	return this&caps == caps
+}
+
+func (this Capabilities) String() string {
+	res := []string{}
+
+	for i, name := range capabilityNames {
+		if this.Has(1 << uint(i)) {
+			res = append(res, name)
+		}
+	}
+
+	return strings.Join(res, ",")
+}
+
+func (this PacketContact) Has(caps Capabilities) bool {
+	return this.Capabilities.Has(caps)
+}
+
+func (this *Dht) capable(caps Capabilities) bool {
+	return this.options.Capabilities.Has(caps)
+}
+
+// FindPeers looks up the closest nodes to hash supporting all of caps. The
+// lookup still goes through the other nodes to reach them
+func (this *Dht) FindPeers(hash []byte, caps Capabilities) []PacketContact {
+	res := []PacketContact{}
+
+	for _, node := range this.fetchNodesWith(hash, caps) {
+		res = append(res, node.contact)
+	}
+
+	return res
+}
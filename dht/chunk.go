@@ -0,0 +1,146 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"time"
+)
+
+const (
+	CHUNK_PARALLELISM = 8
+)
+
+// Manifest is stored in place of a value bigger than ChunkThreshold, listing
+// the hashes of the chunks of its gob encoding, each stored at its own hash
+type Manifest struct {
+	Size   int
+	Chunks [][]byte
+}
+
+// chunked returns the encoding of a value to store in chunks
+func (this *Dht) chunked(value interface{}) ([]byte, bool) {
+	if this.options.ChunkThreshold <= 0 {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return nil, false
+	}
+
+	return buf.Bytes(), buf.Len() > this.options.ChunkThreshold
+}
+
+// storeChunked stores the chunks, then the manifest once they all are
+func (this *Dht) storeChunked(hash []byte, blob []byte, ttl time.Duration) ([]byte, int, error) {
+	size := this.options.ChunkThreshold
+	manifest := Manifest{Size: len(blob)}
+	chunks := [][]byte{}
+
+	for offset := 0; offset < len(blob); offset += size {
+		end := offset + size
+
+		if end > len(blob) {
+			end = len(blob)
+		}
+
+		chunks = append(chunks, blob[offset:end])
+		manifest.Chunks = append(manifest.Chunks, this.NewHash(blob[offset:end]))
+	}
+
+	errs := make(chan error, len(chunks))
+	slots := make(chan struct{}, CHUNK_PARALLELISM)
+
+	for i, chunk := range chunks {
+		slots <- struct{}{}
+
+		go func(hash []byte, chunk []byte) {
+			defer func() { <-slots }()
+
+			_, _, err := this.publishValue(hash, chunk, ttl)
+			errs <- err
+		}(manifest.Chunks[i], chunk)
+	}
+
+	var res error
+
+	for range chunks {
+		if err := <-errs; err != nil && res == nil {
+			res = err
+		}
+	}
+
+	if res != nil {
+		return []byte{}, 0, res
+	}
+
+	this.logger.Debug("Stored", len(chunks), "chunks of", len(blob), "bytes")
+
+	return this.publishValue(hash, manifest, ttl)
+}
+
+// assemble fetches the chunks of a manifest, each checked against its hash,
+// and decodes the value they hold
+func (this *Dht) assemble(hash []byte, manifest Manifest) (interface{}, error) {
+	chunks := make([][]byte, len(manifest.Chunks))
+	errs := make([]error, len(manifest.Chunks))
+	slots := make(chan struct{}, CHUNK_PARALLELISM)
+
+	var wg sync.WaitGroup
+
+	for i, chunkHash := range manifest.Chunks {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(i int, chunkHash []byte) {
+			defer func() { <-slots }()
+			defer wg.Done()
+
+			value, err := this.fetchChecked(chunkHash, "")
+
+			if err != nil {
+				errs[i] = err
+
+				return
+			}
+
+			chunk, ok := value.([]byte)
+
+			if !ok || compare(this.NewHash(chunk), chunkHash) != 0 {
+				errs[i] = ErrIntegrity
+
+				return
+			}
+
+			chunks[i] = chunk
+		}(i, chunkHash)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	blob := bytes.Join(chunks, []byte{})
+
+	if len(blob) != manifest.Size {
+		return nil, ErrIntegrity
+	}
+
+	var value interface{}
+
+	if err := gob.NewDecoder(bytes.NewReader(blob)).Decode(&value); err != nil {
+		return nil, err
+	}
+
+	if err := this.checkContent(hash, value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
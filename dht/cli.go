@@ -2,10 +2,15 @@ package dht
 
 import (
 	"bufio"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/champii/go-dht/dht/kad"
 )
 
 func (this *Dht) PrintRoutingTable() {
@@ -13,11 +18,15 @@ func (this *Dht) PrintRoutingTable() {
 }
 
 func (this *Dht) PrintLocalStore() {
-	for k, v := range this.store {
-		fmt.Println(k, v)
-	}
+	this.storage().ForEach(func(k string, inst StoreInst) bool {
+		fmt.Println(k, inst.Data)
+
+		return true
+	})
 }
 
+// Cli reads commands from Stdin until "q" or its end, to poke the network by
+// hand. Type "h" for the list
 func (this *Dht) Cli() {
 	fmt.Println("Type 'h' to get help")
 
@@ -28,18 +37,56 @@ func (this *Dht) Cli() {
 		fmt.Print("$> ")
 
 		if !scanner.Scan() {
-			fmt.Println("ERROR SCAN")
+			return
 		}
 
 		ln := scanner.Text()
 
-		splited := strings.Split(ln, " ")
+		splited := strings.Fields(ln)
+
+		if len(splited) == 0 {
+			continue
+		}
 
 		switch splited[0] {
 		case "h":
 			help()
 		case "i":
-			fmt.Println("INFO")
+			this.printStatus()
+		case "put":
+			if len(splited) < 3 {
+				fmt.Println("Usage: put key value")
+				continue
+			}
+
+			value := strings.Join(splited[2:], " ")
+
+			hash, nb, err := this.StoreAt(this.NewHash([]byte(splited[1])), value)
+			if err != nil {
+				fmt.Println(err.Error())
+
+				continue
+			}
+
+			fmt.Println(hex.EncodeToString(hash), nb)
+		case "get":
+			if len(splited) != 2 {
+				fmt.Println("Usage: get key")
+				continue
+			}
+
+			val, err := this.Fetch(this.NewHash([]byte(splited[1])))
+			if err != nil {
+				fmt.Println(err.Error())
+
+				continue
+			}
+
+			printValue(val)
+		case "peers":
+			this.printPeers()
+		case "stats":
+			this.printStats()
 		case "r":
 			this.PrintRoutingTable()
 		case "s":
@@ -57,7 +104,7 @@ func (this *Dht) Cli() {
 
 			fmt.Println(hex.EncodeToString(hash), nb)
 		case "f":
-			if len(splited) != 2 || len(splited[1]) != BUCKET_SIZE*2 {
+			if len(splited) != 2 || len(splited[1]) != this.hashLen()*2 {
 				fmt.Println("Usage: f key")
 				continue
 			}
@@ -76,14 +123,34 @@ func (this *Dht) Cli() {
 				continue
 			}
 
-			fmt.Println(val)
+			printValue(val)
+
+		case "d":
+			if len(splited) != 2 || len(splited[1]) != this.hashLen()*2 {
+				fmt.Println("Usage: d key")
+				continue
+			}
+
+			h, err := hex.DecodeString(splited[1])
+			if err != nil {
+				fmt.Println(err.Error())
+
+				continue
+			}
 
+			nb, err := this.Delete(h)
+			if err != nil {
+				fmt.Println(err.Error())
+
+				continue
+			}
+
+			fmt.Println(nb)
 		case "l":
 			this.PrintLocalStore()
 		case "q":
-			this.Stop()
+			this.Stop(context.Background())
 			os.Exit(1)
-		case "":
 		default:
 			fmt.Println("Unknown command", splited[0])
 		}
@@ -92,11 +159,88 @@ func (this *Dht) Cli() {
 
 func help() {
 	fmt.Println("Commands:")
+	fmt.Println("  put key val  - Store at the hash of key. Returns the hash and the number of OK answers")
+	fmt.Println("  get key      - Fetch from the hash of key")
+	fmt.Println("  peers        - Print the peers, closest first, with their RTT")
+	fmt.Println("  stats        - Print the packets sent and received, the timeouts and the store size")
+	fmt.Println("  i            - Print the status")
 	fmt.Println("  s val        - Store. Returns the hash and the number of OK answers")
 	fmt.Println("  f key        - Fetch")
-	// fmt.Println("  i            - Global info")
+	fmt.Println("  d key        - Delete. Returns the number of OK answers")
 	fmt.Println("  r            - Print routing table")
 	fmt.Println("  l            - Print local store")
 	fmt.Println("  h            - This help")
 	fmt.Println("  q            - Quit")
 }
+
+// printValue prints the bytes stored by dhtctl or the HTTP admin as text
+func printValue(val interface{}) {
+	if blob, ok := val.([]byte); ok {
+		val = string(blob)
+	}
+
+	fmt.Println(val)
+}
+
+func (this *Dht) printStatus() {
+	status := this.Status()
+
+	fmt.Println("Hash:     ", status.Hash)
+	fmt.Println("Addrs:    ", strings.Join(status.Addrs, " "))
+	fmt.Println("Uptime:   ", status.Uptime.Round(time.Second))
+	fmt.Println("Peers:    ", status.Peers)
+	fmt.Println("Stored:   ", status.Stored)
+	fmt.Println("Providing:", status.Providing)
+}
+
+func (this *Dht) printPeers() {
+	contacts := []RoutingContact{}
+
+	for _, bucket := range this.RoutingTable().Buckets {
+		contacts = append(contacts, bucket.Contacts...)
+	}
+
+	kad.SortBy(contacts, this.hash, func(contact RoutingContact) []byte {
+		hash, _ := hex.DecodeString(contact.Hash)
+
+		return hash
+	})
+
+	for _, contact := range contacts {
+		fmt.Println(contact.Hash, contact.Addr, contact.RTT.Round(time.Microsecond))
+	}
+
+	fmt.Println(len(contacts), "peers")
+}
+
+func (this *Dht) printStats() {
+	stats := this.Stats()
+
+	names := []string{}
+
+	for name := range stats.Sent {
+		names = append(names, name)
+	}
+
+	for name := range stats.Received {
+		if _, ok := stats.Sent[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	fmt.Printf("%-16s %10s %10s\n", "Command", "Sent", "Received")
+
+	for _, name := range names {
+		fmt.Printf("%-16s %10d %10d\n", name, stats.Sent[name], stats.Received[name])
+	}
+
+	fmt.Println("Active queries:", stats.ActiveQueries)
+	fmt.Println("Timeouts:      ", stats.Timeouts)
+	fmt.Println("Dropped:       ", stats.Dropped, "in,", stats.OutboundDrops, "out")
+	fmt.Println("Open circuits: ", stats.OpenCircuits)
+	fmt.Println("Average RTT:   ", stats.AverageRTT.Round(time.Microsecond))
+	fmt.Println("Store:         ", stats.StoreEntries, "entries,", stats.StoreBytes, "bytes")
+	fmt.Println("Uptime:        ", stats.Uptime.Round(time.Second))
+}
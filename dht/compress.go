@@ -0,0 +1,75 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+
+	"github.com/golang/snappy"
+)
+
+const (
+	COMPRESS_THRESHOLD = 1024
+)
+
+func compressPacket(packet Packet, threshold int) (Packet, error) {
+	if threshold < 0 || packet.Data == nil {
+		return packet, nil
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := gob.NewEncoder(buf).Encode(&packet.Data); err != nil {
+		return packet, err
+	}
+
+	if buf.Len() <= threshold {
+		return packet, nil
+	}
+
+	packet.Header.Compressed = true
+	packet.Data = snappy.Encode(nil, buf.Bytes())
+
+	return packet, nil
+}
+
+func decompressPacket(packet Packet, maxSize int) (Packet, error) {
+	if !packet.Header.Compressed {
+		return packet, nil
+	}
+
+	blob, ok := packet.Data.([]byte)
+
+	if !ok {
+		return packet, errors.New("Invalid compressed payload")
+	}
+
+	size, err := snappy.DecodedLen(blob)
+
+	if err != nil {
+		return packet, err
+	}
+
+	if size > maxSize {
+		return packet, errPacketTooBig
+	}
+
+	raw, err := snappy.Decode(nil, blob)
+
+	if err != nil {
+		return packet, err
+	}
+
+	var data interface{}
+	dec := gob.NewDecoder(bytes.NewReader(raw))
+
+	if err := dec.Decode(&data); err != nil {
+		return packet, err
+	}
+
+	packet.Header.Compressed = false
+	packet.Data = data
+
+	return packet, nil
+}
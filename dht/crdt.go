@@ -0,0 +1,254 @@
+package dht
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+)
+
+var errNotCRDT = errors.New("Not a CRDT")
+
+// CRDT is a value the storing nodes merge with the one they have instead of
+// rejecting it, so concurrent updates converge without conflicts
+type CRDT interface {
+	// Merge returns the join of both states, false when other is of another
+	// type
+	Merge(other CRDT) (CRDT, bool)
+}
+
+// mergeStored replaces an incoming CRDT by its merge with the stored one.
+// Only the data is merged: the stored record keeps its delete token,
+// expiration and publisher, so that a merge cannot take it over
+func mergeStored(inst StoreInst, existing StoreInst, exists bool) StoreInst {
+	value, ok := inst.Data.(CRDT)
+
+	if !ok || !exists {
+		return inst
+	}
+
+	if old, ok := existing.Data.(CRDT); ok {
+		if merged, ok := old.Merge(value); ok {
+			res := existing
+			res.Data = merged
+
+			return res
+		}
+	}
+
+	return inst
+}
+
+// GCounter is a grow-only counter, counting the increments of each replica
+type GCounter map[string]uint64
+
+// Increment returns a copy of the counter with n more from replica
+func (this GCounter) Increment(replica string, n uint64) GCounter {
+	res := make(GCounter, len(this))
+
+	for id, count := range this {
+		res[id] = count
+	}
+
+	res[replica] += n
+
+	return res
+}
+
+func (this GCounter) Value() uint64 {
+	var res uint64
+
+	for _, count := range this {
+		res += count
+	}
+
+	return res
+}
+
+func (this GCounter) Merge(other CRDT) (CRDT, bool) {
+	counter, ok := other.(GCounter)
+
+	if !ok {
+		return nil, false
+	}
+
+	res := make(GCounter, len(this))
+
+	for id, count := range this {
+		res[id] = count
+	}
+
+	for id, count := range counter {
+		if count > res[id] {
+			res[id] = count
+		}
+	}
+
+	return res, true
+}
+
+// ORSet is an observed-remove set of strings. Each add is tagged, and a
+// remove only removes the tags it observed, so a concurrent add wins
+type ORSet struct {
+	Adds    map[string]map[string]bool
+	Removes map[string]map[string]bool
+}
+
+func NewORSet() ORSet {
+	return ORSet{
+		Adds:    make(map[string]map[string]bool),
+		Removes: make(map[string]map[string]bool),
+	}
+}
+
+// Add returns a copy of the set with elem added under a new tag
+func (this ORSet) Add(elem string) ORSet {
+	tag := make([]byte, 8)
+	rand.Read(tag)
+
+	res := this.copy()
+	addTag(res.Adds, elem, hex.EncodeToString(tag))
+
+	return res
+}
+
+// Remove returns a copy of the set without the tags of elem it has seen
+func (this ORSet) Remove(elem string) ORSet {
+	res := this.copy()
+
+	for tag := range this.Adds[elem] {
+		addTag(res.Removes, elem, tag)
+	}
+
+	return res
+}
+
+func (this ORSet) Contains(elem string) bool {
+	for tag := range this.Adds[elem] {
+		if !this.Removes[elem][tag] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Elements returns the elements of the set, sorted
+func (this ORSet) Elements() []string {
+	res := []string{}
+
+	for elem := range this.Adds {
+		if this.Contains(elem) {
+			res = append(res, elem)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+func (this ORSet) Merge(other CRDT) (CRDT, bool) {
+	set, ok := other.(ORSet)
+
+	if !ok {
+		return nil, false
+	}
+
+	res := this.copy()
+
+	for elem, tags := range set.Adds {
+		for tag := range tags {
+			addTag(res.Adds, elem, tag)
+		}
+	}
+
+	for elem, tags := range set.Removes {
+		for tag := range tags {
+			addTag(res.Removes, elem, tag)
+		}
+	}
+
+	return res, true
+}
+
+func (this ORSet) copy() ORSet {
+	res := NewORSet()
+
+	for elem, tags := range this.Adds {
+		for tag := range tags {
+			addTag(res.Adds, elem, tag)
+		}
+	}
+
+	for elem, tags := range this.Removes {
+		for tag := range tags {
+			addTag(res.Removes, elem, tag)
+		}
+	}
+
+	return res
+}
+
+func addTag(tags map[string]map[string]bool, elem string, tag string) {
+	if tags[elem] == nil {
+		tags[elem] = make(map[string]bool)
+	}
+
+	tags[elem][tag] = true
+}
+
+// LWWRegister holds the value written last, the replica breaking the ties
+type LWWRegister struct {
+	Value   interface{}
+	Time    int64
+	Replica string
+}
+
+// NewLWWRegister returns a register holding value, written now by replica
+func NewLWWRegister(replica string, value interface{}) LWWRegister {
+	return LWWRegister{
+		Value:   value,
+		Time:    time.Now().UnixNano(),
+		Replica: replica,
+	}
+}
+
+func (this LWWRegister) Merge(other CRDT) (CRDT, bool) {
+	register, ok := other.(LWWRegister)
+
+	if !ok {
+		return nil, false
+	}
+
+	if register.Time > this.Time || (register.Time == this.Time && register.Replica > this.Replica) {
+		return register, true
+	}
+
+	return this, true
+}
+
+// StoreCRDT stores value at the hash of key, as StoreKey does, merged by the
+// storing nodes with the state they have
+func (this *Dht) StoreCRDT(key string, value CRDT) ([]byte, int, error) {
+	return this.StoreKey(key, value)
+}
+
+// FetchCRDT returns the state at the hash of key, the one of the first node
+// answering. A state stored with StoreCRDT since may be missing from it
+func (this *Dht) FetchCRDT(key string) (CRDT, error) {
+	value, err := this.FetchKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, ok := value.(CRDT)
+
+	if !ok {
+		return nil, errNotCRDT
+	}
+
+	return res, nil
+}
@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+const (
+	MAX_DATAGRAM_SIZE = 1024 * 8
+)
+
+var (
+	errUnknownCommand = errors.New("Unknown command")
+	errInvalidHash    = errors.New("Invalid hash length")
+	errPacketTooBig   = errors.New("Packet too big")
+)
+
+// maxPacketSize bounds a reassembled packet, and its payload once
+// decompressed
+func (this *Dht) maxPacketSize() int {
+	return this.maxFragments() * FRAGMENT_SIZE
+}
+
+// decodePacket decodes a packet and checks its header before anything else
+// looks at it. gob should not panic on malformed input, but a panic would
+// still end up as an error
+func decodePacket(payload []byte, size int) (packet Packet, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			packet = Packet{}
+			err = fmt.Errorf("Malformed packet: %v", r)
+		}
+	}()
+
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&packet); err != nil {
+		return Packet{}, err
+	}
+
+	if err := checkHeader(packet.Header, size); err != nil {
+		return Packet{}, err
+	}
+
+	return packet, nil
+}
+
+func checkHeader(header PacketHeader, size int) error {
+	if header.Command < COMMAND_NOOP || header.Command > COMMAND_FETCH_RECORD {
+		return errUnknownCommand
+	}
+
+	if len(header.Sender.Hash) != size || len(header.MessageHash) != size {
+		return errInvalidHash
+	}
+
+	for _, hash := range [][]byte{header.ResponseTo, header.BroadcastId} {
+		if len(hash) != 0 && len(hash) != size {
+			return errInvalidHash
+		}
+	}
+
+	return nil
+}
@@ -1,14 +1,18 @@
 package dht
 
 import (
-	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
-	"math/rand"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logging "github.com/op/go-logging"
@@ -19,207 +23,825 @@ type Dht struct {
 	routing      *Routing
 	options      DhtOptions
 	hash         []byte
+	publicKey    ed25519.PublicKey
+	privateKey   ed25519.PrivateKey
+	boxKey       *ecdh.PrivateKey
+	sessions     map[string]peerSession
+	handshakes   map[string][]func(error)
+	secret       []byte
 	running      bool
-	store        map[string]interface{}
-	commandQueue map[string]CallbackChan
-	logger       *logging.Logger
-	server       net.PacketConn
-	gotBroadcast [][]byte
+	stopping     bool
+	store        Storage
+	published    map[string]StoreInst
+	providers    map[string][]ProviderRecord
+	providing    map[string][]byte
+	calls        *callQueue
+	fragments    map[string]*fragmentBuffer
+	logger       Logger
+	servers      []net.PacketConn
+	mdns         *net.UDPConn
+	externalAddr string
+	stunPending  map[string]chan string
+	httpServer   *http.Server
+	startedAt    time.Time
+	relays       map[string]PacketContact
+	observed     map[string]observedVote
+	events       *eventBus
+	counters     *statsCounters
+	broadcasts   map[string]time.Time
+	topics       map[string][]chan TopicMessage
+	topicSeen    map[string]time.Time
+	usage        map[string]publisherUsage
+	peers        map[string]PeerInfo
+	magic        []byte
+	inbound      *inboundQueue
+	outbound     *outboundQueues
+	batches      *batcher
+	bans         *BanList
+	breakers     *breakerList
+	flights      *flightGroup
+	rpcs         *rpcRegistry
+	channels     *sessionRegistry
+	handlers     *handlerRegistry
+	readCache    *LRUStorage
+	limiter      *RateLimiter
+	replay       *ReplayGuard
+	tickers      republishTickers
+	loopsDone    chan struct{}
+	seq          uint64
+	tuning       atomic.Pointer[tunables]
 }
 
 type DhtOptions struct {
-	NoRepublishOnExit bool
-	ListenAddr        string
-	BootstrapAddr     string
-	Verbose           int
-	Cluster           int
-	Stats             bool
-	Interactif        bool
-	OnStore           func(Packet) bool
-	OnCustomCmd       func(Packet) interface{}
-	OnBroadcast       func(Packet) interface{}
+	NoRepublishOnExit   bool
+	NoTransferOnJoin    bool
+	ListenAddr          string
+	ListenAddrs         []string
+	BootstrapAddr       []string
+	Verbose             int
+	Cluster             int
+	Stats               bool
+	Interactif          bool
+	CompressThreshold   int
+	MaxValueSize        int
+	K                   int
+	Alpha               int
+	ReplicateInterval   time.Duration
+	RepublishInterval   time.Duration
+	RefreshInterval     time.Duration
+	KeepaliveInterval   time.Duration
+	MaxStrikes          int
+	BanDuration         time.Duration
+	RateLimit           float64
+	RateBurst           int
+	Encrypt             bool
+	ContentAddressed    bool
+	ReadRepair          bool
+	CacheFetched        bool
+	CacheTTL            time.Duration
+	ReadCacheSize       int
+	ReadCacheTTL        time.Duration
+	ChunkThreshold      int
+	ErasureShards       int
+	ErasureData         int
+	ClockSkew           time.Duration
+	IdDifficulty        int
+	IdBits              int
+	Hasher              Hasher
+	DisjointPaths       int
+	LookupQuorum        int
+	RpcTimeout          time.Duration
+	MinRpcTimeout       time.Duration
+	NoAdaptiveTimeout   bool
+	RpcRetries          int
+	RpcBackoff          time.Duration
+	BreakerThreshold    int
+	BreakerCooldown     time.Duration
+	Mdns                bool
+	PeerCachePath       string
+	PeerCacheSize       int
+	PeerCacheInterval   time.Duration
+	PexInterval         time.Duration
+	PexSampleSize       int
+	Namespaces          map[string]Namespace
+	BroadcastTTL        int
+	BroadcastFanout     int
+	StunServer          string
+	NetworkId           string
+	Transport           Transport
+	Capabilities        Capabilities
+	HttpAddr            string
+	HttpAdmin           bool
+	ObservedQuorum      int
+	RoutingPath         string
+	IdentityPath        string
+	IdentityPass        string
+	Storage             Storage
+	StoragePath         string
+	StorageBatch        bool
+	MaxStoreEntries     int
+	MaxStoreBytes       int
+	MaxPublisherEntries int
+	MaxPublisherBytes   int
+	Workers             int
+	WorkerQueue         int
+	QueuePolicy         QueuePolicy
+	OutboundQueue       int
+	OutboundPolicy      QueuePolicy
+	Readers             int
+	ReusePort           bool
+	BatchWindow         time.Duration
+	Validator           Validator
+	Resolver            Resolver
+	Logger              Logger
+	Tracer              Tracer
+	InInterceptors      []Interceptor
+	OutInterceptors     []Interceptor
+	OnCustomCmd         func(Packet) interface{}
+	OnBroadcast         func(Packet) interface{}
 }
 
 func New(options DhtOptions) *Dht {
 	res := &Dht{
-		routing:      NewRouting(),
-		options:      options,
-		running:      false,
-		store:        make(map[string]interface{}),
-		commandQueue: make(map[string]CallbackChan),
-		logger:       logging.MustGetLogger("dht"),
+		options:     options,
+		running:     false,
+		store:       options.Storage,
+		published:   make(map[string]StoreInst),
+		providers:   make(map[string][]ProviderRecord),
+		providing:   make(map[string][]byte),
+		calls:       newCallQueue(),
+		batches:     newBatcher(),
+		fragments:   make(map[string]*fragmentBuffer),
+		logger:      options.Logger,
+		bans:        NewBanList(),
+		breakers:    newBreakerList(),
+		flights:     newFlightGroup(),
+		rpcs:        newRpcRegistry(),
+		channels:    newSessionRegistry(),
+		handlers:    newHandlerRegistry(),
+		boxKey:      NewBoxKey(),
+		sessions:    make(map[string]peerSession),
+		handshakes:  make(map[string][]func(error)),
+		stunPending: make(map[string]chan string),
+		relays:      make(map[string]PacketContact),
+		observed:    make(map[string]observedVote),
+		broadcasts:  make(map[string]time.Time),
+		topics:      make(map[string][]chan TopicMessage),
+		topicSeen:   make(map[string]time.Time),
+		usage:       make(map[string]publisherUsage),
+		peers:       make(map[string]PeerInfo),
+		events:      newEventBus(),
+		counters:    newStatsCounters(),
+		seq:         uint64(time.Now().UnixNano()),
+	}
+
+	res.hashOptions()
+
+	res.routing = NewRouting(res.options.IdBits)
+
+	if res.options.MaxStoreBytes == 0 {
+		res.options.MaxStoreBytes = MAX_STORE_BYTES
+	}
+
+	if len(res.options.NetworkId) == 0 {
+		res.options.NetworkId = NETWORK_ID
+	}
+
+	res.magic = networkMagic(res.options.NetworkId)
+
+	if res.options.Capabilities == 0 {
+		res.options.Capabilities = DEFAULT_CAPABILITIES
+	}
+
+	if res.options.MaxPublisherEntries == 0 {
+		res.options.MaxPublisherEntries = PUBLISHER_MAX_ENTRIES
+	}
+
+	if res.options.MaxPublisherBytes == 0 {
+		res.options.MaxPublisherBytes = PUBLISHER_MAX_BYTES
+	}
+
+	if res.options.Workers == 0 {
+		res.options.Workers = INBOUND_WORKERS
+	}
+
+	if res.options.WorkerQueue == 0 {
+		res.options.WorkerQueue = INBOUND_QUEUE
+	}
+
+	if res.options.OutboundQueue == 0 {
+		res.options.OutboundQueue = OUTBOUND_QUEUE
+	}
+
+	if res.options.Readers == 0 {
+		res.options.Readers = READERS
+	}
+
+	if res.store == nil {
+		res.store = res.limitStorage(NewMemoryStorage())
+	}
+
+	if res.options.Validator == nil {
+		res.options.Validator = AcceptAllValidator{}
+	}
+
+	if res.options.Resolver == nil {
+		res.options.Resolver = LastWriterWins
+	}
+
+	if res.options.CompressThreshold == 0 {
+		res.options.CompressThreshold = COMPRESS_THRESHOLD
+	}
+
+	if res.options.MaxValueSize == 0 {
+		res.options.MaxValueSize = MAX_VALUE_SIZE
+	}
+
+	if res.options.ChunkThreshold > res.options.MaxValueSize {
+		res.options.ChunkThreshold = res.options.MaxValueSize
+	}
+
+	if res.options.ErasureShards > MAX_ERASURE_SHARDS {
+		res.options.ErasureShards = MAX_ERASURE_SHARDS
+	}
+
+	if res.options.ErasureData <= 0 || res.options.ErasureData > res.options.ErasureShards {
+		res.options.ErasureData = (res.options.ErasureShards + 1) / 2
+	}
+
+	if res.options.K == 0 {
+		res.options.K = BUCKET_SIZE
+	}
+
+	if res.options.Alpha == 0 {
+		res.options.Alpha = ALPHA
+	}
+
+	if res.options.ReplicateInterval == 0 {
+		res.options.ReplicateInterval = REPLICATE_INTERVAL
+	}
+
+	if res.options.RepublishInterval == 0 {
+		res.options.RepublishInterval = REPUBLISH_INTERVAL
+	}
+
+	if res.options.RefreshInterval == 0 {
+		res.options.RefreshInterval = REFRESH_INTERVAL
+	}
+
+	if res.options.KeepaliveInterval == 0 {
+		res.options.KeepaliveInterval = KEEPALIVE_INTERVAL
+	}
+
+	if res.options.MaxStrikes == 0 {
+		res.options.MaxStrikes = MAX_STRIKES
+	}
+
+	if res.options.BanDuration == 0 {
+		res.options.BanDuration = BAN_DURATION
+	}
+
+	if res.options.RateLimit == 0 {
+		res.options.RateLimit = RATE_LIMIT
+	}
+
+	if res.options.RateBurst == 0 {
+		res.options.RateBurst = RATE_BURST
+	}
+
+	res.limiter = NewRateLimiter(res.options.RateLimit, res.options.RateBurst)
+	res.tuning.Store(newTunables(res.options))
+
+	if res.options.ClockSkew == 0 {
+		res.options.ClockSkew = CLOCK_SKEW
+	}
+
+	res.replay = NewReplayGuard(res.options.ClockSkew)
+
+	if res.options.CacheTTL == 0 {
+		res.options.CacheTTL = CACHE_TTL
+	}
+
+	if res.options.ReadCacheTTL == 0 {
+		res.options.ReadCacheTTL = READ_CACHE_TTL
+	}
+
+	res.readCache = newReadCache(res.options.ReadCacheSize)
+
+	if res.options.PeerCacheSize == 0 {
+		res.options.PeerCacheSize = PEER_CACHE_SIZE
+	}
+
+	if res.options.PeerCacheInterval == 0 {
+		res.options.PeerCacheInterval = PEER_CACHE_INTERVAL
+	}
+
+	if res.options.PexInterval == 0 {
+		res.options.PexInterval = PEX_INTERVAL
+	}
+
+	if res.options.PexSampleSize == 0 {
+		res.options.PexSampleSize = PEX_SAMPLE_SIZE
+	}
+
+	if res.options.BroadcastTTL == 0 {
+		res.options.BroadcastTTL = BROADCAST_TTL
+	}
+
+	if res.options.BroadcastFanout == 0 {
+		res.options.BroadcastFanout = BROADCAST_FANOUT
+	}
+
+	if res.options.ObservedQuorum == 0 {
+		res.options.ObservedQuorum = OBSERVED_QUORUM
+	}
+
+	if res.options.RpcTimeout == 0 {
+		res.options.RpcTimeout = RPC_TIMEOUT
+	}
+
+	if res.options.MinRpcTimeout == 0 {
+		res.options.MinRpcTimeout = MIN_RPC_TIMEOUT
+	}
+
+	if res.options.RpcBackoff == 0 {
+		res.options.RpcBackoff = RPC_BACKOFF
+	}
+
+	if res.options.BreakerThreshold == 0 {
+		res.options.BreakerThreshold = BREAKER_THRESHOLD
+	}
+
+	if res.options.BreakerCooldown == 0 {
+		res.options.BreakerCooldown = BREAKER_COOLDOWN
 	}
 
 	gob.Register([]PacketContact{})
 	gob.Register(StoreInst{})
+	gob.Register(DeleteInst{})
+	gob.Register(MutableRecord{})
+	gob.Register(StoreConflict{})
+	gob.Register(QuotaExceeded{})
+	gob.Register(ProvidersInst{})
+	gob.Register(BusyError{})
+	gob.Register(ProtocolError{})
+	gob.Register(HolePunchInst{})
+	gob.Register(RelayInst{})
 	gob.Register(CustomCmd{})
-
-	initLogger(res)
+	gob.Register(RpcAnswer{})
+	gob.Register(SessionMsg{})
+	gob.Register(SessionAck{})
+	gob.Register(RegisterInst{})
+	gob.Register(PublishInst{})
+	gob.Register(HelloInst{})
+	gob.Register(StreamInfo{})
+	gob.Register(ChunkInst{})
+	gob.Register(Chunk{})
+	gob.Register(Manifest{})
+	gob.Register(ErasureManifest{})
+	gob.Register(VersionedRecord{})
+	gob.Register(GCounter{})
+	gob.Register(ORSet{})
+	gob.Register(LWWRegister{})
+
+	res.publicKey, res.privateKey = newPuzzleIdentity(options.IdDifficulty, res.NewHash)
+	res.secret = deleteSecret(res.privateKey)
+	res.hash = res.NewHash(res.publicKey)
+
+	if res.logger == nil {
+		res.logger = logging.MustGetLogger("dht")
+
+		initLogger(res)
+	}
 
 	res.routing.dht = res
 
 	res.logger.Debug("DHT version 0.0.1")
 
-	r := rand.Intn(60) - 60
-	timer := time.NewTicker(time.Minute*10 + (time.Second * time.Duration(r)))
-
-	go func() {
-		for range timer.C {
-			res.republish()
-		}
-	}()
-
 	return res
 }
 
-func initLogger(dht *Dht) {
-	var format = logging.MustStringFormatter(
-		`%{color}%{time:15:04:05.000} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`,
-	)
-
-	backend := logging.NewLogBackend(os.Stderr, "", 0)
-
-	var logLevel logging.Level
-	switch dht.options.Verbose {
+// logLevel is the level of the default logger for the Verbose option
+func logLevel(verbose int) logging.Level {
+	switch verbose {
 	case 0:
-		logLevel = logging.CRITICAL
+		return logging.CRITICAL
 	case 1:
-		logLevel = logging.ERROR
+		return logging.ERROR
 	case 2:
-		logLevel = logging.WARNING
+		return logging.WARNING
 	case 3:
-		logLevel = logging.NOTICE
+		return logging.NOTICE
 	case 4:
-		logLevel = logging.INFO
+		return logging.INFO
 	case 5:
-		logLevel = logging.DEBUG
+		return logging.DEBUG
 	default:
-		logLevel = 2
+		return 2
 	}
+}
+
+func initLogger(dht *Dht) {
+	var format = logging.MustStringFormatter(
+		`%{color}%{time:15:04:05.000} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`,
+	)
+
+	backend := logging.NewLogBackend(os.Stderr, "", 0)
 
 	backendFormatter := logging.NewBackendFormatter(backend, format)
 
 	backendLeveled := logging.AddModuleLevel(backendFormatter)
 
-	backendLeveled.SetLevel(logLevel, "")
+	backendLeveled.SetLevel(logLevel(dht.options.Verbose), "")
 
 	logging.SetBackend(backendLeveled)
 }
 
-func (this *Dht) republish() {
-	for k, v := range this.store {
-		h, _ := hex.DecodeString(k)
-		this.StoreAt(h, v)
+func (this *Dht) Store(value interface{}) ([]byte, int, error) {
+	hash, err := this.ContentHash(value)
+
+	if err != nil {
+		return []byte{}, 0, err
 	}
 
-	this.logger.Debug("Republished", len(this.store))
+	return this.StoreAt(hash, value)
 }
 
-func (this *Dht) Store(value interface{}) ([]byte, int, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(value)
+func (this *Dht) StoreAt(hash []byte, value interface{}) ([]byte, int, error) {
+	return this.StoreAtTTL(hash, value, 0)
+}
 
-	if err != nil {
+func (this *Dht) StoreAtTTL(hash []byte, value interface{}, ttl time.Duration) ([]byte, int, error) {
+	if err := this.checkContent(hash, value); err != nil {
 		return []byte{}, 0, err
 	}
 
-	hash := NewHash(buf.Bytes())
+	if blob, ok := this.chunked(value); ok {
+		return this.storeChunked(hash, blob, ttl)
+	}
 
-	return this.StoreAt(hash, value)
+	if err := this.checkValueSize(value); err != nil {
+		return []byte{}, 0, err
+	}
+
+	if blob, ok := this.erasure(value); ok {
+		return this.storeErasure(hash, blob, ttl)
+	}
+
+	return this.publishValue(hash, value, ttl)
 }
 
-func (this *Dht) StoreAt(hash []byte, value interface{}) ([]byte, int, error) {
-	bucket := this.fetchNodes(hash)
+// publishValue stores a value as its original publisher, to republish it
+func (this *Dht) publishValue(hash []byte, value interface{}, ttl time.Duration) ([]byte, int, error) {
+	inst := StoreInst{
+		Hash:        hash,
+		Data:        value,
+		DeleteToken: this.NewHash(this.deleteKey(hash)),
+	}
 
-	if len(bucket) == 0 {
-		return []byte{}, 0, errors.New("No nodes found")
+	if ttl > 0 {
+		inst.Expiration = time.Now().Add(ttl).UnixNano()
 	}
 
-	fn := func(node *Node) chan interface{} {
-		return node.Store(hash, value)
+	inst = this.signPublisher(inst)
+
+	this.Lock()
+	this.published[hex.EncodeToString(hash)] = inst
+	this.Unlock()
+
+	this.uncacheRead(hash)
+
+	return this.storeAt(inst)
+}
+
+func (this *Dht) storeAt(inst StoreInst) ([]byte, int, error) {
+	hash := inst.Hash
+
+	nodes := this.fetchNodesWith(hash, CAP_STORE)
+
+	if len(nodes) == 0 {
+		return []byte{}, 0, ErrNoNodes
 	}
 
-	query := NewQuery(hash, fn, this)
+	type storeAnswer struct {
+		stored bool
+		err    error
+	}
 
-	res := query.Run().([]bool)
+	answers := make(chan storeAnswer, len(nodes))
 
-	if len(res) == 0 {
-		return []byte{}, 0, errors.New("No answers from nodes")
+	for _, node := range nodes {
+		go func(node *Node) {
+			stored, err := node.Store(inst)
+			answers <- storeAnswer{stored, err}
+		}(node)
 	}
 
+	answeredNb := 0
 	storedOkNb := 0
-	for _, stored := range res {
-		if stored {
+
+	var rejected error
+
+	for range nodes {
+		answer := <-answers
+
+		// conflicts and exceeded quotas
+		if errors.Is(answer.err, ErrStoreRejected) {
+			answeredNb++
+			rejected = answer.err
+
+			continue
+		}
+
+		if answer.err != nil {
+			continue
+		}
+
+		answeredNb++
+
+		if answer.stored {
 			storedOkNb++
 		}
 	}
 
+	if answeredNb == 0 {
+		return []byte{}, 0, ErrTimeout
+	}
+
+	if storedOkNb == 0 && rejected != nil {
+		return []byte{}, 0, rejected
+	}
+
 	if storedOkNb == 0 {
-		return []byte{}, 0, errors.New(hex.EncodeToString(hash) + ": The key might be existing already")
+		return []byte{}, 0, fmt.Errorf("%s: %w", hex.EncodeToString(hash), ErrStoreRejected)
 	}
 
 	return hash, storedOkNb, nil
 }
 
+func (this *Dht) Delete(hash []byte) (int, error) {
+	key := hex.EncodeToString(hash)
+
+	this.uncacheRead(hash)
+
+	this.Lock()
+	record, published := this.published[key]
+	delete(this.published, key)
+
+	if existing, ok := this.store.Get(key); ok && compare(this.NewHash(this.deleteKey(hash)), existing.DeleteToken) == 0 {
+		this.store.Delete(key)
+	}
+	this.Unlock()
+
+	// the chunks and shards go with their manifest
+	if manifest, ok := record.Data.(Manifest); published && ok {
+		for _, chunk := range manifest.Chunks {
+			this.Delete(chunk)
+		}
+	}
+
+	nodes := this.fetchNodesWith(hash, CAP_STORE)
+
+	if len(nodes) == 0 {
+		return 0, ErrNoNodes
+	}
+
+	if manifest, ok := record.Data.(ErasureManifest); published && ok {
+		this.deleteShards(nodes, manifest)
+	}
+
+	inst := DeleteInst{
+		Hash: hash,
+		Key:  this.deleteKey(hash),
+	}
+
+	answers := make(chan bool, len(nodes))
+
+	for _, node := range nodes {
+		go func(node *Node) {
+			deleted, err := node.Delete(inst)
+			answers <- err == nil && deleted
+		}(node)
+	}
+
+	deletedNb := 0
+
+	for range nodes {
+		if <-answers {
+			deletedNb++
+		}
+	}
+
+	return deletedNb, nil
+}
+
+func (this *Dht) deleteKey(hash []byte) []byte {
+	return this.NewHash(append(append([]byte{}, this.secret...), hash...))
+}
+
 func (this *Dht) Fetch(hash []byte) (interface{}, error) {
-	fn := func(node *Node) chan interface{} {
-		return node.Fetch(hash)
+	value, err := this.fetchChecked(hash, "")
+
+	if err != nil {
+		return value, err
 	}
 
-	query := NewQuery(hash, fn, this)
+	return this.rebuildManifest(hash, value)
+}
 
-	res := query.Run()
+// rebuildManifest returns the value a manifest stands for, any other value
+// as is
+func (this *Dht) rebuildManifest(hash []byte, value interface{}) (interface{}, error) {
+	switch manifest := value.(type) {
+	case Manifest:
+		return this.assemble(hash, manifest)
+	case ErasureManifest:
+		return this.rebuild(hash, manifest)
+	}
 
-	switch res.(type) {
-	case []*Node:
-		return nil, errors.New("Not found")
-	default:
+	return value, nil
+}
+
+// fetchChecked checks the value found against its hash with
+// ContentAddressed, unless it is stored at the hash of a key. The local
+// values were checked when stored, and the cached ones when fetched
+func (this *Dht) fetchChecked(hash []byte, key string) (interface{}, error) {
+	if inst, ok := this.getLocal(hex.EncodeToString(hash)); ok {
+		this.emit(EVENT_VALUE_FETCHED, PacketContact{}, hash)
+
+		return inst.Data, nil
 	}
 
+	if value, ok := this.cachedRead(hash); ok {
+		this.emit(EVENT_VALUE_FETCHED, PacketContact{}, hash)
+
+		return value, nil
+	}
+
+	flight := hex.EncodeToString(hash)
+
+	if len(key) > 0 {
+		flight += "/" + key
+	}
+
+	// concurrent fetches of a hot key share a single lookup
+	return this.flights.do(flight, func() (interface{}, error) {
+		return this.fetch(hash, nil, key)
+	})
+}
+
+func (this *Dht) fetch(hash []byte, trace *LookupTrace, key string) (interface{}, error) {
+	path := &pathCache{}
+	checked := len(key) == 0
+
+	// a poisoned answer fails like an unreachable node, and the lookup goes on
+	fn := func(node *Node) (QueryResult, error) {
+		res, err := node.Fetch(hash)
+
+		if err == nil && res.Found && checked {
+			if err := this.checkContent(hash, res.Value); err != nil {
+				this.logger.Warning(node, "x FOUND", err)
+
+				return QueryResult{}, node.newError(ErrIntegrity, nil)
+			}
+		}
+
+		if err == nil {
+			path.answered(node, res)
+		}
+
+		return res, err
+	}
+
+	res, found, _ := this.lookup(hash, fn, lookupOptions{trace: trace})
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	if err := this.validate(hash, res); err != nil {
+		return nil, err
+	}
+
+	if this.options.ReadRepair {
+		res = this.readRepair(hash, key, res)
+	}
+
+	// the records of a key are not cached, their namespace policies being
+	// lost on the copy
+	if checked && this.options.CacheFetched {
+		this.cacheAlongPath(hash, res, path)
+	}
+
+	// only the checked values are served from the cache
+	if checked {
+		this.cacheRead(hash, res)
+	}
+
+	this.emit(EVENT_VALUE_FETCHED, PacketContact{}, hash)
+
 	return res, nil
 }
 
 func (this *Dht) fetchNodes(hash []byte) []*Node {
-	fn := func(node *Node) chan interface{} {
-		return node.FetchNodes(hash)
+	return this.fetchNodesWith(hash, 0)
+}
+
+func (this *Dht) fetchNodesWith(hash []byte, caps Capabilities) []*Node {
+	return this.lookupNodes(context.Background(), hash, caps)
+}
+
+func (this *Dht) lookupNodes(ctx context.Context, hash []byte, caps Capabilities) []*Node {
+	fn := func(node *Node) (QueryResult, error) {
+		nodes, err := node.FetchNodes(hash)
+
+		return QueryResult{Nodes: nodes}, err
 	}
 
-	query := NewQuery(hash, fn, this)
+	_, _, nodes := this.lookup(hash, fn, lookupOptions{require: caps, ctx: ctx})
 
-	return query.Run().([]*Node)
+	return nodes
 }
 
-func (this *Dht) bootstrap() error {
-	this.logger.Debug("Connecting to bootstrap node", this.options.BootstrapAddr)
+// FindNode runs an iterative lookup of target, any identifier of the hash
+// space, and returns the K closest contacts that answered, closest first
+func (this *Dht) FindNode(ctx context.Context, target []byte) ([]PacketContact, error) {
+	if err := this.checkHash(target); err != nil {
+		return nil, err
+	}
 
-	addr, err := net.ResolveUDPAddr("udp", this.options.BootstrapAddr)
+	nodes := this.lookupNodes(ctx, target, 0)
 
-	if err != nil {
-		return err
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
 	}
 
-	bootstrapNode := NewNode(this, addr, []byte{})
+	contacts := make([]PacketContact, len(nodes))
 
-	// this.routing.AddNode(bootstrapNode)
+	for i, node := range nodes {
+		contacts[i] = node.contact
+	}
+
+	return contacts, nil
+}
+
+// bootstrap pings every bootstrap node and cached peer in parallel, and
+// succeeds when at least one answered and the lookup of our own hash found
+// some nodes
+func (this *Dht) bootstrap(cached []string) error {
+	bootstrapAddrs := append(cached, this.resolveBootstrap(this.options.BootstrapAddr)...)
+
+	this.logger.Debug("Connecting to bootstrap nodes", bootstrapAddrs)
+
+	if len(bootstrapAddrs) == 0 {
+		return ErrNoNodes
+	}
+
+	answers := make(chan error, len(bootstrapAddrs))
+
+	for _, bootstrapAddr := range bootstrapAddrs {
+		go func(bootstrapAddr string) {
+			addr, err := net.ResolveUDPAddr("udp", bootstrapAddr)
+
+			if err != nil {
+				answers <- err
+				return
+			}
+
+			answers <- NewNode(this, addr, []byte{}).Ping()
+		}(bootstrapAddr)
+	}
 
-	err, ok := (<-bootstrapNode.Ping()).(error)
+	var err error
+	aliveNb := 0
 
-	if ok {
+	for range bootstrapAddrs {
+		if answer := <-answers; answer != nil {
+			this.logger.Warning("Bootstrap node:", answer)
+			err = answer
+		} else {
+			aliveNb++
+		}
+	}
+
+	if aliveNb == 0 {
 		return err
 	}
 
-	_ = this.fetchNodes(this.hash)
+	if len(this.fetchNodes(this.hash)) == 0 {
+		return ErrNoNodes
+	}
 
 	for i, bucket := range this.routing.buckets {
 		if len(bucket) != 0 {
 			continue
 		}
 
-		h := NewRandomHash()
-		h = this.routing.nCopy(h, this.hash, i)
-
-		_ = this.fetchNodes(h)
+		_ = this.fetchNodes(this.routing.randomHashInBucket(i))
 	}
 
 	this.logger.Info("Ready...")
@@ -236,33 +858,91 @@ func (this *Dht) Start() error {
 		return errors.New("Already started")
 	}
 
-	this.hash = NewRandomHash()
+	if err := this.options.Validate(); err != nil {
+		return err
+	}
 
-	this.logger.Debug("Own hash", hex.EncodeToString(this.hash))
+	this.stopping = false
 
-	l, err := net.ListenPacket("udp", this.options.ListenAddr)
+	if err := this.loadIdentity(); err != nil {
+		return errors.New("Identity: " + err.Error())
+	}
 
-	if err != nil {
+	if len(this.options.StoragePath) > 0 && this.options.Storage == nil {
+		storage, err := NewBoltStorage(this.options.StoragePath, this.options.StorageBatch)
+
+		if err != nil {
+			return errors.New("Storage: " + err.Error())
+		}
+
+		this.Lock()
+		this.store = this.limitStorage(storage)
+		this.Unlock()
+	}
+
+	this.hash = this.NewHash(this.publicKey)
+
+	this.logger.Debug("Own hash", hex.EncodeToString(this.hash))
+
+	if err := this.listen(); err != nil {
 		return errors.New("Error listening:" + err.Error())
 	}
 
-	this.server = l
+	this.running = true
+	this.startedAt = time.Now()
+
+	this.startWorkers()
+	this.startOutbound()
+	this.startLoops()
+
+	for _, conn := range this.servers {
+		this.logger.Info("Listening on " + conn.LocalAddr().String())
+
+		for i := 0; i < this.readers(); i++ {
+			go func(conn net.PacketConn) {
+				if err := this.loop(conn); err != nil {
+					this.running = false
+					this.logger.Error("Main loop: " + err.Error())
+				}
+			}(conn)
+		}
+	}
 
-	go func() {
-		this.logger.Info("Listening on " + this.options.ListenAddr)
+	if len(this.options.StunServer) > 0 {
+		if err := this.discoverExternalAddr(); err != nil {
+			this.logger.Warning("STUN:", err)
+		}
+	}
 
-		if err := this.loop(); err != nil {
-			this.running = false
-			this.logger.Error("Main loop: " + err.Error())
+	if this.options.Mdns {
+		if err := this.startMdns(); err != nil {
+			this.logger.Warning("mDNS:", err)
 		}
-	}()
+	}
 
-	this.running = true
+	if len(this.options.HttpAddr) > 0 {
+		if err := this.startHttp(); err != nil {
+			this.logger.Warning("HTTP:", err)
+		}
+	}
+
+	restored := this.loadRouting()
+	cached := this.loadPeerCache()
+
+	if len(this.options.BootstrapAddr) > 0 || len(cached) > 0 {
+		if err := this.bootstrap(cached); err != nil {
+			if restored == 0 {
+				this.Stop(context.Background())
+				return errors.New("Bootstrap: " + err.Error())
+			}
 
-	if len(this.options.BootstrapAddr) > 0 {
-		if err := this.bootstrap(); err != nil {
-			this.Stop()
-			return errors.New("Bootstrap: " + err.Error())
+			this.logger.Warning("Bootstrap: "+err.Error(), "Using restored routing table")
+		}
+	} else if restored > 0 {
+		this.fetchNodes(this.hash)
+
+		if this.options.Interactif {
+			go this.Cli()
 		}
 	} else {
 		if this.options.Interactif {
@@ -273,15 +953,21 @@ func (this *Dht) Start() error {
 	return nil
 }
 
-func (this *Dht) loop() error {
-	this.running = true
+func (this *Dht) loop(conn net.PacketConn) error {
+	defer conn.Close()
+
+	this.RLock()
+	inbound := this.inbound
+	this.RUnlock()
 
-	defer this.server.Close()
+	var packet *[MAX_DATAGRAM_SIZE]byte
 
 	for this.running {
-		var packet [1024 * 8]byte
+		if packet == nil {
+			packet = getDatagram()
+		}
 
-		n, addr, err := this.server.ReadFrom(packet[0:])
+		n, addr, err := conn.ReadFrom(packet[0:])
 
 		if err != nil {
 			if this.running == false {
@@ -291,78 +977,338 @@ func (this *Dht) loop() error {
 			return errors.New("Error reading:" + err.Error())
 		}
 
-		go this.handleInPacket(addr, packet[0:n])
+		if this.bans.IsBanned(addr.String()) {
+			continue
+		}
+
+		if isStunMessage(packet[0:n]) {
+			this.onStun(packet[0:n])
+			continue
+		}
+
+		// the buffer now belongs to the workers
+		this.enqueue(inbound, addr, packet, n)
+		packet = nil
 	}
 
 	return nil
 }
 
-func (this *Dht) Stop() {
-	if !this.running {
-		return
+// Stop stops answering requests, replicates the published values and waits
+// for the pending requests before closing the transport. When ctx is done,
+// it stops waiting and returns its error
+func (this *Dht) Stop(ctx context.Context) error {
+	this.Lock()
+
+	if !this.running || this.stopping {
+		this.Unlock()
+		return nil
 	}
 
-	if !this.options.NoRepublishOnExit {
-		this.republish()
+	this.stopping = true
+	this.Unlock()
+
+	replicated := make(chan struct{})
+
+	go func() {
+		if !this.options.NoRepublishOnExit {
+			this.replicate()
+		}
+
+		close(replicated)
+	}()
+
+	var err error
+
+	select {
+	case <-replicated:
+		err = this.drain(ctx)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err := this.saveRouting(); err != nil {
+		this.logger.Error("Cannot save routing table", err)
+	}
+
+	if err := this.savePeerCache(); err != nil {
+		this.logger.Error("Cannot save peer cache", err)
 	}
 
 	this.running = false
 
-	this.server.Close()
+	this.stopMdns()
+	this.stopHttp()
+	this.batches.flushAll()
+	this.closeServers()
+	this.stopWorkers()
+	this.stopOutbound()
+	this.stopLoops()
+
+	if err := this.store.Close(); err != nil {
+		this.logger.Error("Cannot close storage", err)
+	}
+
+	return err
+}
+
+// startLoops starts the periodic tasks of the node, until stopLoops
+func (this *Dht) startLoops() {
+	done := make(chan struct{})
+
+	this.Lock()
+	this.loopsDone = done
+	this.Unlock()
+
+	this.startRepublisher(done)
+	this.startSweeper(done)
+	this.startRefresher(done)
+	this.startKeepalive(done)
+	this.startPeerCache(done)
+	this.startPex(done)
+}
+
+func (this *Dht) stopLoops() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.loopsDone != nil {
+		close(this.loopsDone)
+		this.loopsDone = nil
+	}
+
+	this.tickers = republishTickers{}
+}
+
+// drain waits for the pending requests to be answered or to time out
+func (this *Dht) drain(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for {
+		if this.calls.Len() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handleInPacket handles a datagram read from addr, or relayed by relay when
+// not nil
+func (this *Dht) handleInPacket(addr net.Addr, datagram []byte, relay *PacketContact) {
+	source := addr.String()
+
+	// a malformed payload must never take the node down
+	defer func() {
+		if r := recover(); r != nil {
+			this.logger.Error("Malformed packet", r, source)
+			this.strike(source)
+		}
+	}()
+
+	datagram, err := this.stripMagic(datagram)
+
+	if err != nil {
+		this.logger.Debug("Dropped packet", err, source)
+
+		return
+	}
+
+	blobs, err := this.reassemble(datagram)
+
+	if err != nil {
+		this.logger.Warning("Invalid fragment")
+		this.strike(source)
 
+		return
+	}
+
+	for _, blob := range blobs {
+		this.handleBlob(addr, blob, relay)
+	}
 }
 
-func (this *Dht) handleInPacket(addr net.Addr, blob_ []byte) {
-	var packet Packet
+// handleBlob handles a signed packet, once reassembled or taken out of a
+// batch
+func (this *Dht) handleBlob(addr net.Addr, blob_ []byte, relay *PacketContact) {
+	source := addr.String()
 
-	var blob bytes.Buffer
-	blob.Write(blob_)
+	if len(blob_) > this.maxPacketSize() {
+		this.logger.Warning("Invalid packet", errPacketTooBig)
+		this.strike(source)
 
-	dec := gob.NewDecoder(&blob)
+		return
+	}
 
-	err := dec.Decode(&packet)
+	payload, signature, err := splitSignature(blob_)
 
 	if err != nil {
-		this.logger.Warning("Invalid packet")
+		this.logger.Warning("Invalid packet", err)
+		this.strike(source)
+
+		return
+	}
+
+	packet, err := decodePacket(payload, this.hashLen())
+
+	if err != nil {
+		this.logger.Warning("Invalid packet", err)
+		this.strike(source)
+
+		return
+	}
+
+	if err := this.verifyPacket(packet, payload, signature); err != nil {
+		this.logger.Warning("Invalid packet signature", err)
+		this.strike(source)
+
+		return
+	}
+
+	packet.Header.Signature = signature
+
+	if err := this.replay.Check(packet.Header); err != nil {
+		this.logger.Warning("Dropped packet", err)
+
+		return
+	}
+
+	this.learnBoxKey(packet.Header.Sender.Hash, packet.Header.BoxKey)
+
+	packet, err = this.decryptPacket(packet)
+
+	if err != nil {
+		this.logger.Warning("Cannot decrypt packet", err)
+		this.strike(source)
+
+		return
+	}
+
+	packet, err = decompressPacket(packet, this.maxPacketSize())
+
+	if err != nil {
+		this.logger.Warning("Invalid compressed packet", err)
+		this.strike(source)
+
+		return
+	}
+
+	this.counters.countReceived(packet.Header.Command)
+
+	if this.isContactBanned(packet.Header.Sender) {
+		return
+	}
+
+	// a refused peer can still say HELLO again, once upgraded
+	if packet.Header.Version < MIN_PROTOCOL_VERSION || (this.refused(packet.Header.Sender.Hash) && packet.Header.Command != COMMAND_HELLO) {
+		this.logger.Debug("Dropped packet of incompatible version", packet.Header.Version, source)
+
+		return
+	}
+
+	if !this.validContact(packet.Header.Sender) {
+		this.logger.Warning("Invalid node ID puzzle", packet.Header.Sender.Addr)
+		this.strike(source)
 
 		return
 	}
 
 	var node *Node
-	addr, err = net.ResolveUDPAddr("udp", packet.Header.Sender.Addr)
+	addr, err = this.resolve(packet.Header.Sender)
 
 	node = NewNodeContact(this, addr, packet.Header.Sender)
 
-	this.routing.AddNode(packet.Header.Sender)
+	// the source of a relayed packet is the relay, that the sender is then
+	// answered through, its signature being checked
+	if relay == nil {
+		node.observed = source
+	} else if compare(packet.Header.Sender.Hash, relay.Hash) != 0 {
+		if _, err := this.routing.GetNode(packet.Header.Sender.Hash); err != nil {
+			this.setRelay(packet.Header.Sender.Hash, *relay)
+		}
+	}
 
-	node.HandleInPacket(packet)
+	if len(packet.Header.ResponseTo) == 0 && this.stopping {
+		return
+	}
+
+	if len(packet.Header.ResponseTo) == 0 {
+		if ok, retryAfter := this.limiter.Allow(hostKey(source)); !ok {
+			node.Busy(packet, retryAfter)
+
+			return
+		}
+	}
+
+	this.handleInbound(node, packet, func(packet Packet) error {
+		this.routing.AddNode(packet.Header.Sender)
+
+		switch packet.Header.Command {
+		case COMMAND_HELLO, COMMAND_HELLO_ANSWER:
+		default:
+			this.greet(node)
+		}
+
+		node.HandleInPacket(packet)
+
+		return nil
+	})
 }
 
-func (this *Dht) Logger() *logging.Logger {
+// contact advertises the external address first when known, then the
+// listen addresses
+func (this *Dht) contact() PacketContact {
+	addrs := []string{}
+
+	this.RLock()
+	if len(this.externalAddr) > 0 {
+		addrs = append(addrs, this.externalAddr)
+	}
+	this.RUnlock()
+
+	for _, listenAddr := range append([]string{this.options.ListenAddr}, this.options.ListenAddrs...) {
+		if addr, err := net.ResolveUDPAddr("udp", listenAddr); err == nil {
+			addrs = append(addrs, addr.String())
+		}
+	}
+
+	if len(addrs) == 0 {
+		addrs = append(addrs, this.options.ListenAddr)
+	}
+
+	return PacketContact{
+		Addr:         addrs[0],
+		Hash:         this.hash,
+		Family:       addrFamily(addrs[0]),
+		Addrs:        addrs[1:],
+		Capabilities: this.options.Capabilities,
+	}
+}
+
+func (this *Dht) Logger() Logger {
 	return this.logger
 }
 
+// CustomCmd sends data to the closest nodes to this one, answered by their
+// OnCustomCmd option
+//
+// Deprecated: use RegisterRPC and Call
 func (this *Dht) CustomCmd(data interface{}) {
 	bucket := this.routing.FindNode(this.hash)
 
 	for _, contact := range bucket {
-		addr, _ := net.ResolveUDPAddr("udp", contact.Addr)
+		addr, _ := this.resolve(contact)
 
 		node := NewNodeContact(this, addr, contact)
-		<-node.Custom(data)
+		node.Custom(data)
 	}
 }
 
-func (this *Dht) hasBroadcast(hash []byte) bool {
-	for _, h := range this.gotBroadcast {
-		if compare(h, hash) == 0 {
-			return true
-		}
-	}
-
-	return false
-}
-
 func compare(hash1, hash2 []byte) int {
 	if len(hash1) != len(hash2) {
 		return len(hash1) - len(hash2)
@@ -377,23 +1323,14 @@ func compare(hash1, hash2 []byte) int {
 	return 0
 }
 
+// Broadcast gossips data to BroadcastFanout random peers, each forwarding
+// it the same way for at most BroadcastTTL hops
 func (this *Dht) Broadcast(data interface{}) {
-	bucket := this.routing.FindNode(this.hash)
-
-	var packet Packet
-	switch data.(type) {
-	case Packet:
-		packet = data.(Packet)
-	default:
-		packet = NewPacket(this, COMMAND_BROADCAST, []byte{}, data)
-	}
+	packet := NewPacket(this, COMMAND_BROADCAST, []byte{}, data)
+	packet.Header.TTL = this.options.BroadcastTTL
 
-	for _, contact := range bucket {
-		addr, _ := net.ResolveUDPAddr("udp", contact.Addr)
-
-		node := NewNodeContact(this, addr, contact)
-		node.Broadcast(packet)
-	}
+	this.markBroadcast(packet.Header.MessageHash)
+	this.forwardBroadcast(packet, nil)
 }
 
 func (this *Dht) Running() bool {
@@ -422,18 +1359,37 @@ func (this *Dht) onBroadcast(packet Packet) interface{} {
 	return nil
 }
 
-func (this *Dht) onStore(packet Packet) bool {
-	if this.options.OnStore != nil {
-		return this.options.OnStore(packet)
+// Ping checks the node at addr answers, and returns the round trip time
+func (this *Dht) Ping(addr string) (time.Duration, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+
+	if err != nil {
+		return 0, err
 	}
 
-	return true
+	start := time.Now()
+
+	if err := NewNode(this, udpAddr, []byte{}).Ping(); err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
 }
 
 func (this *Dht) GetConnectedNumber() int {
 	return this.routing.Size()
 }
 
+// GetClosestLocalPeers returns the k contacts of the routing table closest
+// to key, closest first, without any network I/O. K of them when k is 0
+func (this *Dht) GetClosestLocalPeers(key []byte, k int) []PacketContact {
+	if k <= 0 {
+		k = this.tuned().K
+	}
+
+	return this.routing.Closest(key, k)
+}
+
 func (this *Dht) StoredKeys() int {
-	return len(this.store)
+	return this.storage().Len()
 }
@@ -0,0 +1,183 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	"github.com/champii/go-dht/dht/kad"
+)
+
+type lookupResult struct {
+	value interface{}
+	found bool
+	nodes []*Node
+}
+
+// lookupOptions are the optional trace of a lookup, the capabilities the
+// nodes it returns must have, and the context ending it
+type lookupOptions struct {
+	trace   *LookupTrace
+	require Capabilities
+	ctx     context.Context
+}
+
+func (this lookupOptions) context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+
+	return this.ctx
+}
+
+// lookupPaths runs DisjointPaths lookups in parallel, each one starting
+// from its share of the closest known contacts. A node is only ever queried
+// by one path, and there are no more paths than contacts to start from, so
+// fewer paths than DisjointPaths may run
+func (this *Dht) lookupPaths(hash []byte, job QueryJob, options lookupOptions) []lookupResult {
+	paths := this.options.DisjointPaths
+	contacts := []PacketContact{}
+
+	if paths > 1 {
+		contacts = this.routing.FindNode(hash)
+	}
+
+	if paths > len(contacts) {
+		paths = len(contacts)
+	}
+
+	if paths <= 1 {
+		lookup := NewLookup(hash, job, this)
+		lookup.trace = options.trace
+		lookup.require = options.require
+		lookup.ctx = options.context()
+
+		value, found, nodes := lookup.Run()
+
+		return []lookupResult{{value: value, found: found, nodes: nodes}}
+	}
+
+	seeds := make([][]PacketContact, paths)
+
+	for i, contact := range contacts {
+		seeds[i%paths] = append(seeds[i%paths], contact)
+	}
+
+	claims := &sync.Map{}
+	results := make([]lookupResult, paths)
+
+	var wg sync.WaitGroup
+
+	for i := range seeds {
+		lookup := NewLookup(hash, job, this)
+		lookup.seeds = seeds[i]
+		lookup.claims = claims
+		lookup.path = i
+		lookup.trace = options.trace
+		lookup.require = options.require
+		lookup.ctx = options.context()
+
+		wg.Add(1)
+
+		go func(i int, lookup *Lookup) {
+			defer wg.Done()
+
+			value, found, nodes := lookup.Run()
+			results[i] = lookupResult{value: value, found: found, nodes: nodes}
+		}(i, lookup)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// lookup returns a value only when LookupQuorum of the disjoint paths, a
+// majority of DisjointPaths by default, agree on it. The quorum is never
+// lowered when fewer paths could run
+func (this *Dht) lookup(hash []byte, job QueryJob, options lookupOptions) (interface{}, bool, []*Node) {
+	this.emit(EVENT_LOOKUP_STARTED, PacketContact{}, hash)
+	defer this.emit(EVENT_LOOKUP_FINISHED, PacketContact{}, hash)
+
+	results := this.lookupPaths(hash, job, options)
+
+	nodes := this.mergeNodes(hash, results)
+
+	paths := this.options.DisjointPaths
+
+	if paths <= 1 {
+		return results[0].value, results[0].found, nodes
+	}
+
+	quorum := this.options.LookupQuorum
+
+	if quorum <= 0 {
+		quorum = paths/2 + 1
+	}
+
+	if len(results) < quorum {
+		this.logger.Warning(hex.EncodeToString(hash), "Only", len(results), "disjoint paths for a quorum of", quorum)
+
+		return nil, false, nodes
+	}
+
+	votes := make(map[string]int)
+
+	for _, result := range results {
+		if !result.found {
+			continue
+		}
+
+		key := valueKey(result.value)
+		votes[key]++
+
+		if votes[key] >= quorum {
+			return result.value, true, nodes
+		}
+	}
+
+	if len(votes) > 0 {
+		this.logger.Warning(hex.EncodeToString(hash), "No quorum between disjoint paths")
+	}
+
+	return nil, false, nodes
+}
+
+func (this *Dht) mergeNodes(hash []byte, results []lookupResult) []*Node {
+	res := []*Node{}
+	seen := make(map[string]bool)
+
+	for _, result := range results {
+		for _, node := range result.nodes {
+			key := hex.EncodeToString(node.contact.Hash)
+
+			if !seen[key] {
+				seen[key] = true
+				res = append(res, node)
+			}
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return kad.Closer(res[i].contact.Hash, res[j].contact.Hash, hash)
+	})
+
+	if k := this.tuned().K; len(res) > k {
+		res = res[:k]
+	}
+
+	return res
+}
+
+func valueKey(value interface{}) string {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(NewHash(buf.Bytes()))
+}
@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	DNS_SEED_PREFIX = "dns://"
+	DNS_SEED_PORT   = "3000"
+)
+
+// resolveBootstrap expands the dns:// entries of the bootstrap list. The A
+// and AAAA records of the seed are used with the given port (or 3000), and
+// its TXT records can list full ip:port addresses
+func (this *Dht) resolveBootstrap(entries []string) []string {
+	res := []string{}
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, DNS_SEED_PREFIX) {
+			res = append(res, entry)
+			continue
+		}
+
+		addrs, err := resolveDnsSeed(strings.TrimPrefix(entry, DNS_SEED_PREFIX))
+
+		if err != nil {
+			this.logger.Warning("DNS seed", entry, err)
+			continue
+		}
+
+		this.logger.Debug("DNS seed", entry, "resolved", len(addrs), "peers")
+
+		res = append(res, addrs...)
+	}
+
+	return res
+}
+
+func resolveDnsSeed(seed string) ([]string, error) {
+	host, port, err := net.SplitHostPort(seed)
+
+	if err != nil {
+		host = seed
+		port = DNS_SEED_PORT
+	}
+
+	res := []string{}
+
+	ips, err := net.LookupHost(host)
+
+	for _, ip := range ips {
+		res = append(res, net.JoinHostPort(ip, port))
+	}
+
+	txts, _ := net.LookupTXT(host)
+
+	for _, txt := range txts {
+		for _, addr := range strings.Fields(txt) {
+			if _, _, err := net.SplitHostPort(addr); err == nil {
+				res = append(res, addr)
+			}
+		}
+	}
+
+	if len(res) == 0 && err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
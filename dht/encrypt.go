@@ -0,0 +1,217 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+)
+
+type peerSession struct {
+	boxKey []byte
+	aead   cipher.AEAD
+}
+
+func NewBoxKey() *ecdh.PrivateKey {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// learnBoxKey derives the session key shared with the sender of a verified
+// packet
+func (this *Dht) learnBoxKey(hash []byte, boxKey []byte) {
+	if len(boxKey) == 0 || len(hash) == 0 {
+		return
+	}
+
+	key := hex.EncodeToString(hash)
+
+	this.RLock()
+	session, ok := this.sessions[key]
+	this.RUnlock()
+
+	if ok && compare(session.boxKey, boxKey) == 0 {
+		return
+	}
+
+	pub, err := ecdh.X25519().NewPublicKey(boxKey)
+
+	if err != nil {
+		return
+	}
+
+	secret, err := this.boxKey.ECDH(pub)
+
+	if err != nil {
+		return
+	}
+
+	shared := sha256.Sum256(secret)
+
+	block, err := aes.NewCipher(shared[:])
+
+	if err != nil {
+		return
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return
+	}
+
+	this.Lock()
+	this.sessions[key] = peerSession{
+		boxKey: boxKey,
+		aead:   aead,
+	}
+	this.Unlock()
+}
+
+func (this *Dht) session(hash []byte) (cipher.AEAD, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	session, ok := this.sessions[hex.EncodeToString(hash)]
+
+	return session.aead, ok
+}
+
+// needsHandshake tells if the packet is to be encrypted for a node whose
+// session key is not known yet
+func (this *Node) needsHandshake(packet Packet) bool {
+	if !this.dht.options.Encrypt || packet.Data == nil || len(this.contact.Hash) == 0 {
+		return false
+	}
+
+	_, ok := this.dht.session(this.contact.Hash)
+
+	return !ok
+}
+
+// afterHandshake writes the packet once the session key of the node is
+// known, without making the caller wait for the handshake
+func (this *Node) afterHandshake(packet Packet) chan interface{} {
+	res := make(chan interface{}, 1)
+
+	this.handshake(func(err error) {
+		if err != nil {
+			res <- this.newError(ErrEncode, err)
+
+			return
+		}
+
+		answer := this.write(packet)
+
+		go func() { res <- <-answer }()
+	})
+
+	return res
+}
+
+// handshake calls done once the session key of the node is known, or could
+// not be. The pong carries the box key of the peer, so a single ping is sent
+// for all the packets waiting for it
+func (this *Node) handshake(done func(error)) {
+	key := hex.EncodeToString(this.contact.Hash)
+
+	this.dht.Lock()
+	waiting, pending := this.dht.handshakes[key]
+	this.dht.handshakes[key] = append(waiting, done)
+	this.dht.Unlock()
+
+	if pending {
+		return
+	}
+
+	go func() {
+		err := this.Ping()
+
+		if _, ok := this.dht.session(this.contact.Hash); err == nil && !ok {
+			err = errors.New("No session key")
+		}
+
+		this.dht.Lock()
+		waiting := this.dht.handshakes[key]
+		delete(this.dht.handshakes, key)
+		this.dht.Unlock()
+
+		// in the order they were sent
+		for _, done := range waiting {
+			done(err)
+		}
+	}()
+}
+
+func (this *Node) encryptPacket(packet Packet) (Packet, error) {
+	if packet.Data == nil || len(this.contact.Hash) == 0 {
+		return packet, nil
+	}
+
+	aead, ok := this.dht.session(this.contact.Hash)
+
+	if !ok {
+		return packet, errors.New("No session key")
+	}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	if err := enc.Encode(&packet.Data); err != nil {
+		return packet, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+
+	packet.Header.Encrypted = true
+	packet.Data = aead.Seal(nonce, nonce, buf.Bytes(), packet.Header.MessageHash)
+
+	return packet, nil
+}
+
+func (this *Dht) decryptPacket(packet Packet) (Packet, error) {
+	if !packet.Header.Encrypted {
+		return packet, nil
+	}
+
+	aead, ok := this.session(packet.Header.Sender.Hash)
+
+	if !ok {
+		return packet, errors.New("No session key")
+	}
+
+	blob, ok := packet.Data.([]byte)
+
+	if !ok || len(blob) < aead.NonceSize() {
+		return packet, errors.New("Invalid encrypted payload")
+	}
+
+	raw, err := aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], packet.Header.MessageHash)
+
+	if err != nil {
+		return packet, err
+	}
+
+	var data interface{}
+	dec := gob.NewDecoder(bytes.NewReader(raw))
+
+	if err := dec.Decode(&data); err != nil {
+		return packet, err
+	}
+
+	packet.Header.Encrypted = false
+	packet.Data = data
+
+	return packet, nil
+}
@@ -0,0 +1,341 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErasureManifest is stored in place of a value with ErasureShards, listing
+// the hashes of the shards of its gob encoding, each stored at its own hash.
+// Any Data of them rebuild the value
+type ErasureManifest struct {
+	Size   int
+	Data   int
+	Shards [][]byte
+}
+
+// erasure returns the encoding of a value to store in shards
+func (this *Dht) erasure(value interface{}) ([]byte, bool) {
+	if this.options.ErasureShards <= 0 {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return nil, false
+	}
+
+	return buf.Bytes(), true
+}
+
+// storeErasure stores each shard once, on its own node among the closest to
+// the hash of the value, where rebuild looks for them, then the manifest once
+// enough of them are to rebuild the value. The shards are not replicated: the
+// parity ones stand for the copies, and the publisher stores them again on
+// the nodes closest by then when it republishes the manifest
+func (this *Dht) storeErasure(hash []byte, blob []byte, ttl time.Duration) ([]byte, int, error) {
+	data := this.options.ErasureData
+
+	codec, err := newReedSolomon(data, this.options.ErasureShards-data)
+
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	nodes := this.fetchNodesWith(hash, CAP_STORE)
+
+	if len(nodes) == 0 {
+		return []byte{}, 0, ErrNoNodes
+	}
+
+	shards := codec.encode(blob)
+	manifest := ErasureManifest{Size: len(blob), Data: data}
+
+	for _, shard := range shards {
+		manifest.Shards = append(manifest.Shards, this.NewHash(shard))
+	}
+
+	var expiration int64
+
+	if ttl > 0 {
+		expiration = time.Now().Add(ttl).UnixNano()
+	}
+
+	stored, err := this.storeShards(nodes, manifest, shards, expiration)
+
+	if stored < data {
+		return []byte{}, 0, err
+	}
+
+	this.logger.Debug("Stored", stored, "of", len(shards), "shards of", len(blob), "bytes")
+
+	return this.publishValue(hash, manifest, ttl)
+}
+
+// storeShards stores the shard i on the node i of nodes, and returns how
+// many were stored with the last error
+func (this *Dht) storeShards(nodes []*Node, manifest ErasureManifest, shards [][]byte, expiration int64) (int, error) {
+	errs := make(chan error, len(shards))
+	slots := make(chan struct{}, CHUNK_PARALLELISM)
+
+	for i, shard := range shards {
+		slots <- struct{}{}
+
+		inst := StoreInst{
+			Hash:        manifest.Shards[i],
+			Data:        shard,
+			Expiration:  expiration,
+			DeleteToken: this.NewHash(this.deleteKey(manifest.Shards[i])),
+			Shard:       true,
+		}
+
+		// with fewer nodes than shards, some nodes hold several
+		go func(node *Node, inst StoreInst) {
+			defer func() { <-slots }()
+
+			stored, err := node.Store(inst)
+
+			if err == nil && !stored {
+				err = fmt.Errorf("%s: %w", hex.EncodeToString(inst.Hash), ErrStoreRejected)
+			}
+
+			errs <- err
+		}(nodes[i%len(nodes)], inst)
+	}
+
+	var res error
+
+	stored := 0
+
+	for range shards {
+		if err := <-errs; err != nil {
+			res = err
+
+			continue
+		}
+
+		stored++
+	}
+
+	return stored, res
+}
+
+// republishShards rebuilds the encoding of a published value from the
+// shards left, and stores them all again on the nodes now closest to it, so
+// that the shards of the nodes gone are not lost
+func (this *Dht) republishShards(inst StoreInst, manifest ErasureManifest) {
+	blob, err := this.rebuildBlob(inst.Hash, manifest)
+
+	if err != nil {
+		this.logger.Warning(hex.EncodeToString(inst.Hash), "Cannot rebuild the shards:", err)
+
+		return
+	}
+
+	codec, err := newReedSolomon(manifest.Data, len(manifest.Shards)-manifest.Data)
+
+	if err != nil {
+		return
+	}
+
+	nodes := this.fetchNodesWith(inst.Hash, CAP_STORE)
+
+	if len(nodes) == 0 {
+		return
+	}
+
+	shards := codec.encode(blob)
+
+	// nodes already holding a shard refuse it again
+	stored, _ := this.storeShards(nodes, manifest, shards, inst.Expiration)
+
+	this.logger.Debug("Republished", stored, "of", len(shards), "shards of", hex.EncodeToString(inst.Hash))
+}
+
+// rebuild fetches the shards of a manifest, each checked against its hash,
+// until it has enough of them to decode the value they hold
+func (this *Dht) rebuild(hash []byte, manifest ErasureManifest) (interface{}, error) {
+	// the manifest comes from any peer, before its content can be checked
+	if err := this.checkManifest(manifest); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIntegrity, err)
+	}
+
+	blob, err := this.rebuildBlob(hash, manifest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+
+	if err := gob.NewDecoder(bytes.NewReader(blob)).Decode(&value); err != nil {
+		return nil, err
+	}
+
+	if err := this.checkContent(hash, value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// rebuildBlob decodes the encoding of a value from the first Data shards
+// of its manifest found
+func (this *Dht) rebuildBlob(hash []byte, manifest ErasureManifest) ([]byte, error) {
+
+	codec, err := newReedSolomon(manifest.Data, len(manifest.Shards)-manifest.Data)
+
+	if err != nil {
+		return nil, ErrIntegrity
+	}
+
+	type shardResult struct {
+		index int
+		shard []byte
+		err   error
+	}
+
+	nodes := this.fetchNodesWith(hash, CAP_STORE)
+
+	results := make(chan shardResult, len(manifest.Shards))
+	slots := make(chan struct{}, CHUNK_PARALLELISM)
+	done := make(chan struct{})
+
+	defer close(done)
+
+	go func() {
+		for i, shardHash := range manifest.Shards {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			go func(i int, shardHash []byte) {
+				defer func() { <-slots }()
+
+				shard, err := this.fetchShard(nodes, i, shardHash)
+
+				results <- shardResult{i, shard, err}
+			}(i, shardHash)
+		}
+	}()
+
+	shards := make([][]byte, len(manifest.Shards))
+	found := 0
+
+	var lastErr error
+
+	for range manifest.Shards {
+		res := <-results
+
+		if res.err != nil {
+			lastErr = res.err
+
+			continue
+		}
+
+		shards[res.index] = res.shard
+		found++
+
+		if found == manifest.Data {
+			break
+		}
+	}
+
+	if found < manifest.Data {
+		return nil, lastErr
+	}
+
+	blob, err := codec.decode(shards, manifest.Size)
+
+	if err != nil {
+		return nil, ErrIntegrity
+	}
+
+	return blob, nil
+}
+
+// fetchShard asks first the node the shard was stored on, then the other
+// nodes closest to the value, as they may have changed since
+func (this *Dht) fetchShard(nodes []*Node, i int, shardHash []byte) ([]byte, error) {
+	if inst, ok := this.getLocal(hex.EncodeToString(shardHash)); ok {
+		if shard, ok := inst.Data.([]byte); ok {
+			return shard, nil
+		}
+	}
+
+	err := ErrNotFound
+
+	for j := range nodes {
+		res, fetchErr := nodes[(i+j)%len(nodes)].Fetch(shardHash)
+
+		if fetchErr != nil {
+			err = fetchErr
+
+			continue
+		}
+
+		if !res.Found {
+			continue
+		}
+
+		shard, ok := res.Value.([]byte)
+
+		if !ok || compare(this.NewHash(shard), shardHash) != 0 {
+			err = ErrIntegrity
+
+			continue
+		}
+
+		return shard, nil
+	}
+
+	return nil, err
+}
+
+// deleteShards deletes the shards on every node closest to the value, any of
+// them holding some
+func (this *Dht) deleteShards(nodes []*Node, manifest ErasureManifest) {
+	var wg sync.WaitGroup
+
+	for _, node := range nodes {
+		wg.Add(1)
+
+		go func(node *Node) {
+			defer wg.Done()
+
+			for _, shardHash := range manifest.Shards {
+				node.Delete(DeleteInst{
+					Hash: shardHash,
+					Key:  this.deleteKey(shardHash),
+				})
+			}
+		}(node)
+	}
+
+	wg.Wait()
+}
+
+func (this *Dht) checkManifest(manifest ErasureManifest) error {
+	if manifest.Data <= 0 || manifest.Data > len(manifest.Shards) || len(manifest.Shards) > MAX_ERASURE_SHARDS {
+		return errShardsNumbers
+	}
+
+	if manifest.Size < 0 || manifest.Size > this.options.MaxValueSize {
+		return errErasureSize
+	}
+
+	for _, shardHash := range manifest.Shards {
+		if err := this.checkHash(shardHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
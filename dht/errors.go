@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrTimeout         = errors.New("Timeout")
+	ErrNotFound        = errors.New("Not found")
+	ErrNoNodes         = errors.New("No nodes found")
+	ErrStoreRejected   = errors.New("Store rejected")
+	ErrEncode          = errors.New("Encode error")
+	ErrTransport       = errors.New("Transport error")
+	ErrInvalidResponse = errors.New("Invalid response")
+	ErrProtocol        = errors.New("Protocol error")
+	ErrCircuitOpen     = errors.New("Circuit open")
+	ErrIntegrity       = errors.New("Value does not match its hash")
+	ErrQuorum          = errors.New("Quorum not reached")
+	ErrRpc             = errors.New("RPC failed")
+	ErrIntercepted     = errors.New("Dropped by an interceptor")
+)
+
+// PeerError is returned by the node RPCs. It matches its Kind with
+// errors.Is and unwraps to the underlying cause, if any
+type PeerError struct {
+	Kind    error
+	Contact PacketContact
+	Err     error
+}
+
+func (this *Node) newError(kind error, err error) *PeerError {
+	return &PeerError{
+		Kind:    kind,
+		Contact: this.contact,
+		Err:     err,
+	}
+}
+
+func (this *PeerError) Error() string {
+	var peer string
+
+	if len(this.Contact.Hash) > 0 {
+		peer = hex.EncodeToString(this.Contact.Hash)
+	} else {
+		peer = this.Contact.Addr
+	}
+
+	if this.Err != nil {
+		return peer + " " + this.Kind.Error() + ": " + this.Err.Error()
+	}
+
+	return peer + " " + this.Kind.Error()
+}
+
+func (this *PeerError) Is(target error) bool {
+	return target == this.Kind
+}
+
+func (this *PeerError) Unwrap() error {
+	return this.Err
+}
+
+func (this StoreConflict) Is(target error) bool {
+	return target == ErrStoreRejected
+}
+
+func (this QuotaExceeded) Is(target error) bool {
+	return target == ErrStoreRejected
+}
@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_BUFFER = 64
+)
+
+type EventType int
+
+const (
+	EVENT_PEER_ADDED EventType = iota
+	EVENT_PEER_REMOVED
+	EVENT_VALUE_STORED
+	EVENT_VALUE_FETCHED
+	EVENT_LOOKUP_STARTED
+	EVENT_LOOKUP_FINISHED
+	EVENT_TIMEOUT
+	EVENT_RECONFIGURED
+)
+
+// Event carries the contact and the hash involved, when relevant
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Contact PacketContact
+	Hash    []byte
+}
+
+// eventBus has its own lock, as events are emitted while holding the Dht one
+type eventBus struct {
+	sync.RWMutex
+	subscribers map[EventType][]chan Event
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[EventType][]chan Event),
+	}
+}
+
+// Subscribe returns a channel receiving the events of the given type. Events
+// are dropped when the channel is full, so a slow reader never blocks the Dht
+func (this *Dht) Subscribe(eventType EventType) <-chan Event {
+	this.events.Lock()
+	defer this.events.Unlock()
+
+	c := make(chan Event, EVENT_BUFFER)
+
+	this.events.subscribers[eventType] = append(this.events.subscribers[eventType], c)
+
+	return c
+}
+
+func (this *Dht) Unsubscribe(c <-chan Event) {
+	this.events.Lock()
+	defer this.events.Unlock()
+
+	for eventType, subscribers := range this.events.subscribers {
+		for i, subscriber := range subscribers {
+			if subscriber == c {
+				this.events.subscribers[eventType] = append(subscribers[:i], subscribers[i+1:]...)
+				close(subscriber)
+
+				return
+			}
+		}
+	}
+}
+
+func (this *Dht) emit(eventType EventType, contact PacketContact, hash []byte) {
+	this.events.RLock()
+	defer this.events.RUnlock()
+
+	event := Event{
+		Type:    eventType,
+		Time:    time.Now(),
+		Contact: contact,
+		Hash:    hash,
+	}
+
+	for _, subscriber := range this.events.subscribers[eventType] {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package dht
+
+import (
+	"sync"
+)
+
+// flight is a lookup in progress, that the callers asking for the same key
+// wait for instead of starting their own
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type flightGroup struct {
+	sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// do runs fn once for all the concurrent callers of the same key, and gives
+// each of them its result. The value is shared, not copied
+func (this *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	this.Lock()
+
+	if current, ok := this.flights[key]; ok {
+		this.Unlock()
+
+		<-current.done
+
+		return current.value, current.err
+	}
+
+	current := &flight{done: make(chan struct{})}
+	this.flights[key] = current
+
+	this.Unlock()
+
+	current.value, current.err = fn()
+
+	this.Lock()
+	delete(this.flights, key)
+	this.Unlock()
+
+	close(current.done)
+
+	return current.value, current.err
+}
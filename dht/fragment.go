@@ -0,0 +1,154 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	FRAGMENT_SIZE    = 1200
+	FRAGMENT_TIMEOUT = time.Second * 5
+	MAX_VALUE_SIZE   = 1024 * 64
+)
+
+type Fragment struct {
+	MessageHash []byte
+	Index       int
+	Total       int
+	Data        []byte
+	Batch       [][]byte
+}
+
+type fragmentBuffer struct {
+	timer    *time.Timer
+	parts    [][]byte
+	received int
+}
+
+// fragmentBlob returns the datagrams in pooled buffers, each starting with
+// prefix, to be put back once written
+func fragmentBlob(messageHash []byte, blob []byte, prefix []byte) ([]*bytes.Buffer, error) {
+	total := (len(blob) + FRAGMENT_SIZE - 1) / FRAGMENT_SIZE
+
+	if total == 0 {
+		total = 1
+	}
+
+	res := make([]*bytes.Buffer, 0, total)
+
+	for i := 0; i < total; i++ {
+		end := (i + 1) * FRAGMENT_SIZE
+
+		if end > len(blob) {
+			end = len(blob)
+		}
+
+		buf := getBuffer()
+		buf.Write(prefix)
+
+		err := gob.NewEncoder(buf).Encode(Fragment{
+			MessageHash: messageHash,
+			Index:       i,
+			Total:       total,
+			Data:        blob[i*FRAGMENT_SIZE : end],
+		})
+
+		if err != nil {
+			putBuffer(buf)
+
+			for _, datagram := range res {
+				putBuffer(datagram)
+			}
+
+			return nil, err
+		}
+
+		res = append(res, buf)
+	}
+
+	return res, nil
+}
+
+func (this *Dht) maxFragments() int {
+	return this.options.MaxValueSize/FRAGMENT_SIZE + 2
+}
+
+// reassemble returns the packets once all the fragments are received, a
+// batch holding several of them
+func (this *Dht) reassemble(blob []byte) ([][]byte, error) {
+	var fragment Fragment
+
+	dec := gob.NewDecoder(bytes.NewReader(blob))
+
+	if err := dec.Decode(&fragment); err != nil {
+		return nil, err
+	}
+
+	if fragment.Total <= 0 || fragment.Total > this.maxFragments() || fragment.Index < 0 || fragment.Index >= fragment.Total {
+		return nil, errors.New("Invalid fragment")
+	}
+
+	if len(fragment.Batch) > 0 {
+		return this.unbatch(fragment)
+	}
+
+	if fragment.Total == 1 {
+		return [][]byte{fragment.Data}, nil
+	}
+
+	key := hex.EncodeToString(fragment.MessageHash)
+
+	this.Lock()
+	defer this.Unlock()
+
+	buffer, ok := this.fragments[key]
+
+	if !ok {
+		buffer = &fragmentBuffer{
+			parts: make([][]byte, fragment.Total),
+			timer: time.AfterFunc(FRAGMENT_TIMEOUT, func() {
+				this.Lock()
+				delete(this.fragments, key)
+				this.Unlock()
+			}),
+		}
+
+		this.fragments[key] = buffer
+	}
+
+	if len(buffer.parts) != fragment.Total {
+		return nil, errors.New("Invalid fragment")
+	}
+
+	if buffer.parts[fragment.Index] == nil {
+		buffer.parts[fragment.Index] = fragment.Data
+		buffer.received++
+	}
+
+	if buffer.received < fragment.Total {
+		return nil, nil
+	}
+
+	buffer.timer.Stop()
+	delete(this.fragments, key)
+
+	return [][]byte{bytes.Join(buffer.parts, []byte{})}, nil
+}
+
+func (this *Dht) checkValueSize(value interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := gob.NewEncoder(buf).Encode(&value); err != nil {
+		return err
+	}
+
+	if buf.Len() > this.options.MaxValueSize {
+		return errors.New("Value too big")
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build gofuzz
+
+package dht
+
+var fuzzDht = New(DhtOptions{})
+
+// Fuzz is the go-fuzz entry point of the decoder path, from the datagram
+// read on the wire to the decompressed packet
+func Fuzz(data []byte) int {
+	datagram, err := fuzzDht.stripMagic(data)
+
+	if err != nil {
+		return 0
+	}
+
+	blobs, err := fuzzDht.reassemble(datagram)
+
+	if err != nil || len(blobs) == 0 {
+		return 0
+	}
+
+	for _, blob := range blobs {
+		if !fuzzBlob(blob) {
+			return 0
+		}
+	}
+
+	return 1
+}
+
+func fuzzBlob(blob []byte) bool {
+	if len(blob) > fuzzDht.maxPacketSize() {
+		return false
+	}
+
+	payload, _, err := splitSignature(blob)
+
+	if err != nil {
+		return false
+	}
+
+	packet, err := decodePacket(payload, fuzzDht.hashLen())
+
+	if err != nil {
+		return false
+	}
+
+	_, err = decompressPacket(packet, fuzzDht.maxPacketSize())
+
+	return err == nil
+}
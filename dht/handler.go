@@ -0,0 +1,56 @@
+package dht
+
+import "sync"
+
+// CommandHandler handles a query node sent, in place of the built-in handler
+// of its command. It answers with the methods of node, Found for a FETCH for
+// instance, or calls builtin to handle the packet the default way
+type CommandHandler func(node *Node, packet Packet, builtin func(Packet))
+
+type handlerRegistry struct {
+	sync.RWMutex
+	handlers map[int]CommandHandler
+}
+
+func newHandlerRegistry() *handlerRegistry {
+	return &handlerRegistry{
+		handlers: make(map[int]CommandHandler),
+	}
+}
+
+// HandleCommand makes the handler serve the queries of command, COMMAND_FETCH
+// for instance, replacing the previous one. A nil handler restores the
+// built-in one
+func (this *Dht) HandleCommand(command int, handler CommandHandler) {
+	this.handlers.Lock()
+	defer this.handlers.Unlock()
+
+	if handler == nil {
+		delete(this.handlers.handlers, command)
+
+		return
+	}
+
+	this.handlers.handlers[command] = handler
+}
+
+func (this *Dht) commandHandler(command int) (CommandHandler, bool) {
+	this.handlers.RLock()
+	defer this.handlers.RUnlock()
+
+	handler, ok := this.handlers.handlers[command]
+
+	return handler, ok
+}
+
+// handleQuery runs the handler registered for the command of packet, the
+// built-in one otherwise
+func (this *Node) handleQuery(packet Packet) {
+	if handler, ok := this.dht.commandHandler(packet.Header.Command); ok {
+		handler(this, packet, this.handleBuiltin)
+
+		return
+	}
+
+	this.handleBuiltin(packet)
+}
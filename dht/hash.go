@@ -3,13 +3,21 @@ package dht
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"hash"
 )
 
 const (
-	HASH_SIZE   = 128
-	BUCKET_SIZE = HASH_SIZE / 8
+	HASH_SIZE     = 128
+	MIN_HASH_SIZE = 64
+	BUCKET_SIZE   = HASH_SIZE / 8
 )
 
+// Hasher makes the hash function of the keys and node IDs, sha1.New or
+// sha256.New for instance
+type Hasher func() hash.Hash
+
+// NewHash hashes val with the default hash function and ID length, the ones
+// of a node without Hasher nor IdBits
 func NewHash(val []byte) []byte {
 	h := sha256.New()
 
@@ -25,3 +33,52 @@ func NewRandomHash() []byte {
 
 	return res[:BUCKET_SIZE]
 }
+
+// NewHash hashes val with the Hasher of the node, cut to IdBits
+func (this *Dht) NewHash(val []byte) []byte {
+	h := this.options.Hasher()
+
+	h.Write(val)
+
+	return h.Sum(nil)[:this.hashLen()]
+}
+
+func (this *Dht) newRandomHash() []byte {
+	res := make([]byte, this.hashLen())
+
+	rand.Read(res)
+
+	return res
+}
+
+// hashLen is the length in bytes of the keys and node IDs
+func (this *Dht) hashLen() int {
+	return this.options.IdBits / 8
+}
+
+func (this *Dht) checkHash(hash []byte) error {
+	return checkHash(hash, this.hashLen())
+}
+
+// hashOptions makes IdBits a whole number of bytes, between MIN_HASH_SIZE
+// and the size of the Hasher. A Hasher shorter than MIN_HASH_SIZE, that
+// Validate rejects, is replaced by the default one
+func (this *Dht) hashOptions() {
+	if this.options.Hasher == nil || this.options.Hasher().Size()*8 < MIN_HASH_SIZE {
+		this.options.Hasher = sha256.New
+	}
+
+	if this.options.IdBits == 0 {
+		this.options.IdBits = HASH_SIZE
+	}
+
+	this.options.IdBits -= this.options.IdBits % 8
+
+	if max := this.options.Hasher().Size() * 8; this.options.IdBits > max {
+		this.options.IdBits = max
+	}
+
+	if this.options.IdBits < MIN_HASH_SIZE {
+		this.options.IdBits = MIN_HASH_SIZE
+	}
+}
@@ -0,0 +1,168 @@
+package dht
+
+import (
+	"encoding/hex"
+)
+
+const (
+	PROTOCOL_VERSION     = 1
+	MIN_PROTOCOL_VERSION = 1
+)
+
+const (
+	CODEC_GOB    = "gob"
+	CODEC_SNAPPY = "snappy"
+)
+
+var codecs = []string{CODEC_GOB, CODEC_SNAPPY}
+
+type HelloInst struct {
+	Hash         []byte
+	Version      int
+	MinVersion   int
+	Codecs       []string
+	Capabilities Capabilities
+}
+
+// PeerInfo is what a peer announced in its HELLO
+type PeerInfo struct {
+	Version      int
+	MinVersion   int
+	Codecs       []string
+	Capabilities Capabilities
+}
+
+// Compatible tells if both nodes can speak the version of the other
+func (this PeerInfo) Compatible() bool {
+	return this.Version >= MIN_PROTOCOL_VERSION && this.MinVersion <= PROTOCOL_VERSION
+}
+
+func (this PeerInfo) Supports(codec string) bool {
+	for _, c := range this.Codecs {
+		if c == codec {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (this *Dht) hello() HelloInst {
+	return HelloInst{
+		Hash:         this.hash,
+		Version:      PROTOCOL_VERSION,
+		MinVersion:   MIN_PROTOCOL_VERSION,
+		Codecs:       codecs,
+		Capabilities: this.options.Capabilities,
+	}
+}
+
+func (this *Dht) PeerInfo(hash []byte) (PeerInfo, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	info, ok := this.peers[hex.EncodeToString(hash)]
+
+	return info, ok && info.Version > 0
+}
+
+// learnPeer records the HELLO of a peer, and forgets an incompatible one
+func (this *Dht) learnPeer(contact PacketContact, inst HelloInst) {
+	info := PeerInfo{
+		Version:      inst.Version,
+		MinVersion:   inst.MinVersion,
+		Codecs:       inst.Codecs,
+		Capabilities: inst.Capabilities,
+	}
+
+	this.Lock()
+	this.peers[hex.EncodeToString(contact.Hash)] = info
+	this.Unlock()
+
+	if !info.Compatible() {
+		this.logger.Warning(contact, "Incompatible protocol version", info.Version, "min", info.MinVersion)
+
+		this.routing.RemoveNode(contact)
+	}
+}
+
+// refused tells if the peer announced an incompatible version
+func (this *Dht) refused(hash []byte) bool {
+	info, ok := this.PeerInfo(hash)
+
+	return ok && !info.Compatible()
+}
+
+// greet sends a HELLO on first contact with a peer
+func (this *Dht) greet(node *Node) {
+	key := hex.EncodeToString(node.contact.Hash)
+
+	this.Lock()
+
+	if _, ok := this.peers[key]; ok {
+		this.Unlock()
+		return
+	}
+
+	// pending until answered
+	this.peers[key] = PeerInfo{}
+	this.Unlock()
+
+	go func() {
+		if err := node.Hello(); err != nil {
+			this.Lock()
+			delete(this.peers, key)
+			this.Unlock()
+		}
+	}()
+}
+
+// compressThreshold disables compression toward the peers without snappy
+func (this *Dht) compressThreshold(hash []byte) int {
+	if info, ok := this.PeerInfo(hash); ok && !info.Supports(CODEC_SNAPPY) {
+		return -1
+	}
+
+	return this.options.CompressThreshold
+}
+
+func (this *Node) Hello() error {
+	this.dht.logger.Debug(this, "< HELLO")
+
+	res, err := this.request(this.newPacket(COMMAND_HELLO, []byte{}, this.dht.hello()))
+
+	if err != nil {
+		return err
+	}
+
+	inst, ok := res.Data.(HelloInst)
+
+	if !ok {
+		return this.newError(ErrInvalidResponse, nil)
+	}
+
+	this.dht.learnPeer(this.contact, inst)
+
+	return nil
+}
+
+func (this *Node) OnHello(packet Packet) {
+	inst, ok := packet.Data.(HelloInst)
+
+	if !ok {
+		return
+	}
+
+	this.dht.logger.Debug(this, "> HELLO", LogField{"version", inst.Version})
+
+	// answered even when incompatible, for the peer to know why
+	this.send(this.newPacket(COMMAND_HELLO_ANSWER, packet.Header.MessageHash, this.dht.hello()))
+
+	this.dht.learnPeer(this.contact, inst)
+}
+
+func (this *Node) OnHelloAnswer(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> HELLO ANSWER")
+
+	done.c <- packet
+}
@@ -0,0 +1,228 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	HOLEPUNCH_ATTEMPTS = 3
+	HOLEPUNCH_TIMEOUT  = time.Second
+	RELAY_MAX_ENTRIES  = 256
+)
+
+var errRelayDisabled = errors.New("Rendezvous node does not relay")
+
+type HolePunchInst struct {
+	Target PacketContact
+}
+
+type RelayInst struct {
+	Target   PacketContact
+	Datagram []byte
+}
+
+// Connect reaches a node behind a NAT. The rendezvous node, that both nodes
+// already know, asks the target to ping us while we ping it, so each NAT
+// lets the other side in. When punching fails, the packets to the target
+// are relayed by the rendezvous node
+func (this *Dht) Connect(target PacketContact, rendezvous PacketContact) error {
+	if !rendezvous.Has(CAP_RELAY) {
+		return errRelayDisabled
+	}
+
+	rendezvousAddr, err := this.resolve(rendezvous)
+
+	if err != nil {
+		return err
+	}
+
+	targetAddr, err := this.resolve(target)
+
+	if err != nil {
+		return err
+	}
+
+	if err := NewNodeContact(this, rendezvousAddr, rendezvous).HolePunch(target); err != nil {
+		return err
+	}
+
+	node := NewNodeContact(this, targetAddr, target)
+
+	for i := 0; i < HOLEPUNCH_ATTEMPTS; i++ {
+		if node.WithTimeout(HOLEPUNCH_TIMEOUT, 0).Ping() == nil {
+			return nil
+		}
+	}
+
+	this.logger.Info("Hole punching failed, relaying through", rendezvous.Addr)
+
+	this.setRelay(target.Hash, rendezvous)
+
+	return node.Ping()
+}
+
+// setRelay makes the packets to hash go through relay, forgetting another
+// node when RELAY_MAX_ENTRIES are already relayed
+func (this *Dht) setRelay(hash []byte, relay PacketContact) {
+	this.Lock()
+	defer this.Unlock()
+
+	key := hex.EncodeToString(hash)
+
+	if _, ok := this.relays[key]; !ok && len(this.relays) >= RELAY_MAX_ENTRIES {
+		for other := range this.relays {
+			delete(this.relays, other)
+
+			break
+		}
+	}
+
+	this.relays[key] = relay
+}
+
+func (this *Dht) relayFor(hash []byte) (PacketContact, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	relay, ok := this.relays[hex.EncodeToString(hash)]
+
+	return relay, ok
+}
+
+// writeDatagram sends the datagram directly, or through the relay of the
+// node if it could not be reached by hole punching
+func (this *Node) writeDatagram(datagram []byte) error {
+	relay, ok := this.dht.relayFor(this.contact.Hash)
+
+	if !ok {
+		return this.dht.writeTo(datagram, this.addr)
+	}
+
+	addr, err := this.dht.resolve(relay)
+
+	if err != nil {
+		return err
+	}
+
+	node := NewNodeContact(this.dht, addr, relay)
+
+	node.send(node.newPacket(COMMAND_RELAY, []byte{}, RelayInst{
+		Target:   this.contact,
+		Datagram: datagram,
+	}))
+
+	return nil
+}
+
+func (this *Node) HolePunch(target PacketContact) error {
+	this.dht.logger.Debug(this, "< HOLEPUNCH", target.Addr)
+
+	res, err := this.request(this.newPacket(COMMAND_HOLEPUNCH, []byte{}, HolePunchInst{Target: target}))
+
+	if err != nil {
+		return err
+	}
+
+	if found, ok := res.Data.(bool); !ok || !found {
+		return this.newError(ErrNotFound, nil)
+	}
+
+	return nil
+}
+
+func (this *Node) OnHolePunch(packet Packet) {
+	inst, _ := packet.Data.(HolePunchInst)
+
+	this.dht.logger.Debug(this, "> HOLEPUNCH", inst.Target.Addr)
+
+	target, err := this.dht.routing.GetNode(inst.Target.Hash)
+
+	if err == nil && !this.dht.capable(CAP_RELAY) {
+		err = errRelayDisabled
+	}
+
+	var addr *net.UDPAddr
+
+	if err == nil {
+		addr, err = this.dht.resolve(target)
+	}
+
+	if err != nil {
+		this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, false))
+		return
+	}
+
+	node := NewNodeContact(this.dht, addr, target)
+
+	node.dht.logger.Debug(node, "< HOLEPUNCH INTENT", this.contact.Addr)
+	node.send(node.newPacket(COMMAND_HOLEPUNCH_INTENT, []byte{}, HolePunchInst{Target: this.contact}))
+
+	this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, true))
+}
+
+func (this *Node) OnHolePunchIntent(packet Packet) {
+	inst, _ := packet.Data.(HolePunchInst)
+
+	this.dht.logger.Debug(this, "> HOLEPUNCH INTENT", inst.Target.Addr)
+
+	addr, err := this.dht.resolve(inst.Target)
+
+	if err != nil || compare(inst.Target.Hash, this.dht.hash) == 0 {
+		return
+	}
+
+	node := NewNodeContact(this.dht, addr, inst.Target).WithTimeout(HOLEPUNCH_TIMEOUT, 0)
+
+	go func() {
+		for i := 0; i < HOLEPUNCH_ATTEMPTS; i++ {
+			if node.Ping() == nil {
+				return
+			}
+		}
+	}()
+}
+
+// OnRelay forwards a datagram to a node of the routing table only, so the
+// relay cannot be used to reach arbitrary addresses
+func (this *Node) OnRelay(packet Packet) {
+	inst, _ := packet.Data.(RelayInst)
+
+	if !this.dht.capable(CAP_RELAY) {
+		return
+	}
+
+	target, err := this.dht.routing.GetNode(inst.Target.Hash)
+
+	if err != nil {
+		this.dht.logger.Debug(this, "x RELAY unknown target")
+		return
+	}
+
+	addr, err := this.dht.resolve(target)
+
+	if err != nil {
+		return
+	}
+
+	node := NewNodeContact(this.dht, addr, target)
+
+	node.dht.logger.Debug(node, "< RELAYED", len(inst.Datagram))
+	node.send(node.newPacket(COMMAND_RELAYED, []byte{}, RelayInst{
+		Target:   this.contact,
+		Datagram: inst.Datagram,
+	}))
+}
+
+// OnRelayed handles a datagram relayed from a node that could not be reached
+// directly. Its sender, once its signature checked, is answered through the
+// same relay
+func (this *Node) OnRelayed(packet Packet) {
+	inst, _ := packet.Data.(RelayInst)
+
+	this.dht.logger.Debug(this, "> RELAYED", len(inst.Datagram))
+
+	this.dht.handleInPacket(this.addr, inst.Datagram, &this.contact)
+}
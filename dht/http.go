@@ -0,0 +1,156 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	HTTP_SHUTDOWN_TIMEOUT = time.Second * 5
+)
+
+type Status struct {
+	Hash      string        `json:"hash"`
+	Addrs     []string      `json:"addrs"`
+	Uptime    time.Duration `json:"uptime"`
+	Peers     int           `json:"peers"`
+	Stored    int           `json:"stored"`
+	Published int           `json:"published"`
+	Providing int           `json:"providing"`
+	Pending   int           `json:"pending"`
+}
+
+type StoreEntry struct {
+	Key        string    `json:"key"`
+	Size       int       `json:"size"`
+	Expiration time.Time `json:"expiration,omitempty"`
+}
+
+func (this *Dht) Status() Status {
+	contact := this.contact()
+
+	this.RLock()
+	defer this.RUnlock()
+
+	return Status{
+		Hash:      hex.EncodeToString(this.hash),
+		Addrs:     contactAddrs(contact),
+		Uptime:    time.Since(this.startedAt),
+		Peers:     this.routing.Size(),
+		Stored:    this.store.Len(),
+		Published: len(this.published),
+		Providing: len(this.providing),
+		Pending:   this.calls.Len(),
+	}
+}
+
+// startHttp serves /status, /stats, /routing and /store as JSON on HttpAddr,
+// and the operations of handleAdmin with HttpAdmin
+func (this *Dht) startHttp() error {
+	listener, err := net.Listen("tcp", this.options.HttpAddr)
+
+	if err != nil {
+		return err
+	}
+
+	// localhost could resolve to another address
+	if tcp, ok := listener.Addr().(*net.TCPAddr); this.options.HttpAdmin && (!ok || !tcp.IP.IsLoopback()) {
+		listener.Close()
+
+		return fmt.Errorf("HttpAdmin: %s is not a loopback address", listener.Addr())
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, this.Status())
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, this.Stats())
+	})
+
+	mux.HandleFunc("/routing", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, this.RoutingTable())
+	})
+
+	mux.HandleFunc("/store", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && this.options.HttpAdmin {
+			this.adminStore(w, r)
+
+			return
+		}
+
+		writeJson(w, this.storeEntries(r.URL.Query().Get("prefix")))
+	})
+
+	var handler http.Handler = mux
+
+	if this.options.HttpAdmin {
+		this.handleAdmin(mux)
+
+		handler = localOnly(mux)
+	}
+
+	this.httpServer = &http.Server{Handler: handler}
+
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			this.logger.Error("HTTP:", err)
+		}
+	}(this.httpServer)
+
+	this.logger.Info("Serving HTTP on " + listener.Addr().String())
+
+	return nil
+}
+
+func (this *Dht) stopHttp() {
+	if this.httpServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HTTP_SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	this.httpServer.Shutdown(ctx)
+	this.httpServer = nil
+}
+
+func (this *Dht) storeEntries(prefix string) []StoreEntry {
+	res := []StoreEntry{}
+
+	ForEachPrefix(this.storage(), prefix, func(key string, inst StoreInst) bool {
+		if inst.Expired() {
+			return true
+		}
+
+		entry := StoreEntry{
+			Key:  key,
+			Size: instSize(inst),
+		}
+
+		if inst.Expiration != 0 {
+			entry.Expiration = time.Unix(0, inst.Expiration)
+		}
+
+		res = append(res, entry)
+
+		return true
+	})
+
+	return res
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
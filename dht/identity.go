@@ -0,0 +1,169 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"os"
+)
+
+const (
+	IDENTITY_ITERATIONS = 600000
+)
+
+var (
+	errIdentityPass   = errors.New("Wrong identity passphrase")
+	errIdentityKey    = errors.New("Invalid identity key")
+	errIdentityPuzzle = errors.New("Identity does not solve the ID puzzle")
+)
+
+// identityFile is the private key saved to IdentityPath, sealed with a key
+// derived from IdentityPass when Salt is set
+type identityFile struct {
+	Salt  []byte
+	Nonce []byte
+	Key   []byte
+}
+
+// ID returns the hash of the node, derived from its public key
+func (this *Dht) ID() []byte {
+	return this.hash
+}
+
+func (this *Dht) PublicKey() ed25519.PublicKey {
+	return this.publicKey
+}
+
+// loadIdentity takes the identity saved at IdentityPath, or saves the new
+// one there the first time, so the node keeps its hash across restarts
+func (this *Dht) loadIdentity() error {
+	if len(this.options.IdentityPath) == 0 {
+		return nil
+	}
+
+	blob, err := os.ReadFile(this.options.IdentityPath)
+
+	if os.IsNotExist(err) {
+		return this.saveIdentity()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	file := identityFile{}
+
+	if err := gob.NewDecoder(bytes.NewReader(blob)).Decode(&file); err != nil {
+		return err
+	}
+
+	key := file.Key
+
+	if len(file.Salt) > 0 {
+		aead, err := identityCipher(this.options.IdentityPass, file.Salt)
+
+		if err != nil {
+			return err
+		}
+
+		if key, err = aead.Open(nil, file.Nonce, file.Key, nil); err != nil {
+			return errIdentityPass
+		}
+	}
+
+	if len(key) != ed25519.PrivateKeySize {
+		return errIdentityKey
+	}
+
+	privateKey := ed25519.PrivateKey(key)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	if !CheckPuzzle(this.NewHash(publicKey), this.options.IdDifficulty) {
+		return errIdentityPuzzle
+	}
+
+	this.publicKey, this.privateKey = publicKey, privateKey
+	this.secret = deleteSecret(privateKey)
+
+	return nil
+}
+
+// deleteSecret derives the secret of the delete tokens from the private key,
+// so that a node keeping its identity can still delete its values
+func deleteSecret(privateKey ed25519.PrivateKey) []byte {
+	mac := hmac.New(sha256.New, privateKey.Seed())
+	mac.Write([]byte("delete"))
+
+	return mac.Sum(nil)
+}
+
+func (this *Dht) saveIdentity() error {
+	file := identityFile{Key: this.privateKey}
+
+	if len(this.options.IdentityPass) > 0 {
+		file.Salt = make([]byte, 16)
+		rand.Read(file.Salt)
+
+		aead, err := identityCipher(this.options.IdentityPass, file.Salt)
+
+		if err != nil {
+			return err
+		}
+
+		file.Nonce = make([]byte, aead.NonceSize())
+		rand.Read(file.Nonce)
+
+		file.Key = aead.Seal(nil, file.Nonce, this.privateKey, nil)
+	}
+
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(file); err != nil {
+		return err
+	}
+
+	return os.WriteFile(this.options.IdentityPath, buf.Bytes(), 0600)
+}
+
+func identityCipher(pass string, salt []byte) (cipher.AEAD, error) {
+	if len(pass) == 0 {
+		return nil, errIdentityPass
+	}
+
+	block, err := aes.NewCipher(pbkdf2([]byte(pass), salt, IDENTITY_ITERATIONS))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a 32 bytes key from pass (PBKDF2 with HMAC-SHA256), one
+// block being enough
+func pbkdf2(pass []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, pass)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+
+	u := mac.Sum(nil)
+	res := append([]byte{}, u...)
+
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+
+		for j := range res {
+			res[j] ^= u[j]
+		}
+	}
+
+	return res
+}
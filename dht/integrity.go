@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// ContentHash is the hash a value is stored at by Store, with the default
+// hash function
+func ContentHash(value interface{}) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := gob.NewEncoder(buf).Encode(value); err != nil {
+		return []byte{}, err
+	}
+
+	return NewHash(buf.Bytes()), nil
+}
+
+// ContentHash hashes value with the Hasher and IdBits of the node, as Store
+// does
+func (this *Dht) ContentHash(value interface{}) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := gob.NewEncoder(buf).Encode(value); err != nil {
+		return []byte{}, err
+	}
+
+	return this.NewHash(buf.Bytes()), nil
+}
+
+// checkContent enforces, with ContentAddressed, that the value is stored at
+// its own hash. A raw blob can also be stored at the hash of its bytes, as
+// TypedStore does, and a mutable record is checked against its signature
+// instead
+func (this *Dht) checkContent(hash []byte, value interface{}) error {
+	if !this.options.ContentAddressed {
+		return nil
+	}
+
+	// a chunked or sharded value is checked once rebuilt
+	switch value.(type) {
+	case MutableRecord, Manifest, ErasureManifest:
+		return nil
+	}
+
+	if blob, ok := value.([]byte); ok && bytes.Equal(this.NewHash(blob), hash) {
+		return nil
+	}
+
+	res, err := this.ContentHash(value)
+
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(res, hash) {
+		return ErrIntegrity
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package dht
+
+// PacketHandler goes on with a packet: handles an inbound one, or sends an
+// outbound one
+type PacketHandler func(Packet) error
+
+// Interceptor wraps the handling of the packets exchanged with peer. It goes
+// on by calling next, possibly with another packet, or drops the packet by
+// returning without calling it
+type Interceptor func(peer PacketContact, packet Packet, next PacketHandler) error
+
+// intercept runs the packet through the interceptors, the first one being
+// the outermost, then through handler. The packet is dropped with
+// ErrIntercepted when one of them does not go on
+func intercept(interceptors []Interceptor, peer PacketContact, packet Packet, handler PacketHandler) error {
+	called := false
+
+	next := func(packet Packet) error {
+		called = true
+
+		return handler(packet)
+	}
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		inner := next
+
+		next = func(packet Packet) error {
+			return interceptor(peer, packet, inner)
+		}
+	}
+
+	err := next(packet)
+
+	if !called && err == nil {
+		return ErrIntercepted
+	}
+
+	return err
+}
+
+// handleInbound runs the interceptors before the routing table and the
+// handlers ever see the packet
+func (this *Dht) handleInbound(node *Node, packet Packet, handler PacketHandler) {
+	if len(this.options.InInterceptors) == 0 {
+		handler(packet)
+
+		return
+	}
+
+	if err := intercept(this.options.InInterceptors, node.contact, packet, handler); err != nil {
+		this.logger.Debug(node, "x Intercepted", commandName(packet.Header.Command), err)
+	}
+}
+
+// send runs the interceptors before writing the packet. A dropped request
+// fails at once with ErrIntercepted
+func (this *Node) send(packet Packet) chan interface{} {
+	if len(this.dht.options.OutInterceptors) == 0 {
+		return this.write(packet)
+	}
+
+	var res chan interface{}
+
+	err := intercept(this.dht.options.OutInterceptors, this.contact, packet, func(packet Packet) error {
+		res = this.write(packet)
+
+		return nil
+	})
+
+	if res == nil {
+		if err == ErrIntercepted {
+			err = nil
+		}
+
+		res = make(chan interface{}, 1)
+		res <- this.newError(ErrIntercepted, err)
+	}
+
+	return res
+}
@@ -0,0 +1,142 @@
+// Package kad holds the XOR metric of the DHT, for applications to reason
+// about which nodes own a key the way the DHT does
+package kad
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/bits"
+	"sort"
+)
+
+// Distance is the XOR of two keys, compared as a big-endian number
+type Distance []byte
+
+// Xor returns the distance between a and b. A missing byte of the shorter
+// key counts as 0
+func Xor(a, b []byte) Distance {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+
+	res := make(Distance, len(a))
+
+	for i := range a {
+		res[i] = a[i]
+
+		if i < len(b) {
+			res[i] ^= b[i]
+		}
+	}
+
+	return res
+}
+
+// Cmp returns -1, 0 or 1 as the distance is shorter than, equal to or longer
+// than other
+func (this Distance) Cmp(other Distance) int {
+	a, b := []byte(this), []byte(other)
+
+	// leading zero bytes do not make a distance longer
+	for len(a) > len(b) {
+		if a[0] != 0 {
+			return 1
+		}
+
+		a = a[1:]
+	}
+
+	for len(b) > len(a) {
+		if b[0] != 0 {
+			return -1
+		}
+
+		b = b[1:]
+	}
+
+	return bytes.Compare(a, b)
+}
+
+func (this Distance) Less(other Distance) bool {
+	return this.Cmp(other) < 0
+}
+
+// LeadingZeros is the number of leading zero bits, the common prefix length
+// of the keys the distance is between
+func (this Distance) LeadingZeros() int {
+	count := 0
+
+	for _, b := range this {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+
+		count += 8
+	}
+
+	return count
+}
+
+func (this Distance) String() string {
+	return hex.EncodeToString(this)
+}
+
+// CommonPrefixLen is the number of leading bits a and b share
+func CommonPrefixLen(a, b []byte) int {
+	n := len(a)
+
+	if len(b) < n {
+		n = len(b)
+	}
+
+	return Xor(a[:n], b[:n]).LeadingZeros()
+}
+
+// Closer tells if a is strictly closer to target than b. Of two keys
+// shorter than target, the longer one is the closer
+func Closer(a, b, target []byte) bool {
+	for i := range target {
+		if i >= len(a) || i >= len(b) {
+			return len(a) > len(b)
+		}
+
+		da := a[i] ^ target[i]
+		db := b[i] ^ target[i]
+
+		if da != db {
+			return da < db
+		}
+	}
+
+	return false
+}
+
+// Sort sorts keys from the closest to target to the farthest, keeping the
+// order of keys at the same distance
+func Sort(keys [][]byte, target []byte) {
+	SortBy(keys, target, func(key []byte) []byte {
+		return key
+	})
+}
+
+// SortBy sorts items by the distance of their key to target, the closest
+// first, keeping the order of items at the same distance
+func SortBy[T any](items []T, target []byte, key func(T) []byte) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return Closer(key(items[i]), key(items[j]), target)
+	})
+}
+
+// Closest returns the n items closest to target, closest first, leaving
+// items as is
+func Closest[T any](items []T, target []byte, n int, key func(T) []byte) []T {
+	res := append([]T{}, items...)
+
+	SortBy(res, target, key)
+
+	if len(res) > n {
+		res = res[:n]
+	}
+
+	return res
+}
@@ -0,0 +1,62 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	KEEPALIVE_INTERVAL = time.Minute * 5
+)
+
+func (this *Dht) startKeepalive(done chan struct{}) {
+	timer := time.NewTicker(this.options.KeepaliveInterval)
+
+	go func() {
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				if this.running {
+					this.keepalive()
+				}
+			}
+		}
+	}()
+}
+
+// keepalive pings the contacts not seen for a while and removes the ones
+// that do not answer
+func (this *Dht) keepalive() {
+	stale := this.routing.staleContacts(this.options.KeepaliveInterval)
+
+	var wg sync.WaitGroup
+
+	for _, contact := range stale {
+		addr, err := this.resolve(contact)
+
+		if err != nil {
+			this.routing.RemoveNode(contact)
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(node *Node) {
+			defer wg.Done()
+
+			if err := node.Ping(); err != nil {
+				this.routing.RemoveNode(node.contact)
+			}
+		}(NewNodeContact(this, addr, contact))
+	}
+
+	wg.Wait()
+
+	if len(stale) > 0 {
+		this.logger.Debug("Keepalive pinged", len(stale), "Size:", this.routing.Size())
+	}
+}
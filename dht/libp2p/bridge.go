@@ -0,0 +1,144 @@
+package libp2p
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/champii/go-dht/dht"
+)
+
+// Bridge answers the kad-dht requests of libp2p peers from a Dht. The libp2p
+// keys are stored at their dht.KeyHash, and the peers are the nodes of the
+// Dht, with identity multihash ids and udp multiaddrs
+type Bridge struct {
+	dht *dht.Dht
+}
+
+func NewBridge(d *dht.Dht) *Bridge {
+	return &Bridge{dht: d}
+}
+
+// ServeStream answers the requests of a stream until it is closed. It
+// returns the error of a PUT_VALUE it could not store, for the stream to be
+// closed instead of answered
+func (this *Bridge) ServeStream(stream io.ReadWriter) error {
+	reader := bufio.NewReader(stream)
+
+	for {
+		msg, err := ReadMessage(reader)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		res, err := this.Handle(msg)
+
+		if err != nil {
+			return err
+		}
+
+		if err := WriteMessage(stream, res); err != nil {
+			return err
+		}
+	}
+}
+
+// Handle answers a request. The values of PUT_VALUE are stored as replicas,
+// their writers being the ones to store them again
+func (this *Bridge) Handle(msg Message) (Message, error) {
+	res := Message{
+		Type:            msg.Type,
+		Key:             msg.Key,
+		ClusterLevelRaw: msg.ClusterLevelRaw,
+	}
+
+	hash := this.dht.KeyHash(string(msg.Key))
+
+	switch msg.Type {
+	case PUT_VALUE:
+		if msg.Record != nil {
+			if _, _, err := this.dht.StoreKeyReplica(string(msg.Key), msg.Record.Value); err != nil {
+				return res, err
+			}
+
+			res.Record = msg.Record
+		}
+	case GET_VALUE:
+		if value, err := this.dht.FetchKey(string(msg.Key)); err == nil {
+			if blob, ok := value.([]byte); ok {
+				res.Record = &Record{Key: msg.Key, Value: blob}
+			}
+		}
+
+		res.CloserPeers = this.peers(this.dht.FindPeers(hash, 0))
+	case ADD_PROVIDER:
+		for _, contact := range this.contacts(msg.ProviderPeers) {
+			this.dht.AddProvider(hash, contact)
+		}
+	case GET_PROVIDERS:
+		if providers, err := this.dht.FindProviders(hash); err == nil {
+			res.ProviderPeers = this.peers(providers)
+		}
+
+		res.CloserPeers = this.peers(this.dht.FindPeers(hash, 0))
+	case FIND_NODE:
+		res.CloserPeers = this.peers(this.dht.FindPeers(hash, 0))
+	}
+
+	return res, nil
+}
+
+// contacts are the libp2p peers with an identity id and at least one udp
+// multiaddr, the others being skipped
+func (this *Bridge) contacts(peers []Peer) []dht.PacketContact {
+	res := []dht.PacketContact{}
+
+	for _, peer := range peers {
+		hash, err := HashFromPeerId(peer.Id)
+
+		if err != nil {
+			continue
+		}
+
+		addrs := []string{}
+
+		for _, multiaddr := range peer.Addrs {
+			if addr, err := ParseMultiaddr(multiaddr); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+
+		if len(addrs) == 0 {
+			continue
+		}
+
+		res = append(res, dht.PacketContact{Hash: hash, Addr: addrs[0], Addrs: addrs[1:]})
+	}
+
+	return res
+}
+
+func (this *Bridge) peers(contacts []dht.PacketContact) []Peer {
+	res := []Peer{}
+
+	for _, contact := range contacts {
+		peer := Peer{
+			Id:         PeerId(contact.Hash),
+			Connection: CAN_CONNECT,
+		}
+
+		for _, addr := range append([]string{contact.Addr}, contact.Addrs...) {
+			if multiaddr, err := Multiaddr(addr); err == nil {
+				peer.Addrs = append(peer.Addrs, multiaddr)
+			}
+		}
+
+		res = append(res, peer)
+	}
+
+	return res
+}
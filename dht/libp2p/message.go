@@ -0,0 +1,229 @@
+package libp2p
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Message types of the kad-dht protocol
+const (
+	PUT_VALUE = iota
+	GET_VALUE
+	ADD_PROVIDER
+	GET_PROVIDERS
+	FIND_NODE
+	PING
+)
+
+// Connection types of a Peer
+const (
+	NOT_CONNECTED = iota
+	CONNECTED
+	CAN_CONNECT
+	CANNOT_CONNECT
+)
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+var errMalformed = errors.New("Malformed message")
+
+type Record struct {
+	Key          []byte
+	Value        []byte
+	TimeReceived string
+}
+
+type Peer struct {
+	Id         []byte
+	Addrs      [][]byte
+	Connection int
+}
+
+// Message is the kad-dht protobuf message, encoded by hand for the few
+// fields it has
+type Message struct {
+	Type            int
+	ClusterLevelRaw int
+	Key             []byte
+	Record          *Record
+	CloserPeers     []Peer
+	ProviderPeers   []Peer
+}
+
+func appendVarint(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3|wireVarint))
+
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendBytes(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3|wireBytes))
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+
+	return append(buf, value...)
+}
+
+func (this Record) Marshal() []byte {
+	buf := []byte{}
+
+	buf = appendBytes(buf, 1, this.Key)
+	buf = appendBytes(buf, 2, this.Value)
+
+	if len(this.TimeReceived) > 0 {
+		buf = appendBytes(buf, 5, []byte(this.TimeReceived))
+	}
+
+	return buf
+}
+
+func (this Peer) Marshal() []byte {
+	buf := []byte{}
+
+	buf = appendBytes(buf, 1, this.Id)
+
+	for _, addr := range this.Addrs {
+		buf = appendBytes(buf, 2, addr)
+	}
+
+	return appendVarint(buf, 3, uint64(this.Connection))
+}
+
+func (this Message) Marshal() []byte {
+	buf := []byte{}
+
+	buf = appendVarint(buf, 1, uint64(this.Type))
+
+	if len(this.Key) > 0 {
+		buf = appendBytes(buf, 2, this.Key)
+	}
+
+	if this.Record != nil {
+		buf = appendBytes(buf, 3, this.Record.Marshal())
+	}
+
+	for _, peer := range this.CloserPeers {
+		buf = appendBytes(buf, 8, peer.Marshal())
+	}
+
+	for _, peer := range this.ProviderPeers {
+		buf = appendBytes(buf, 9, peer.Marshal())
+	}
+
+	if this.ClusterLevelRaw != 0 {
+		buf = appendVarint(buf, 10, uint64(this.ClusterLevelRaw))
+	}
+
+	return buf
+}
+
+// readFields calls fn with each field of a protobuf message. Bytes fields
+// get their value in raw, varint ones in num
+func readFields(buf []byte, fn func(field int, num uint64, raw []byte) error) error {
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+
+		if n <= 0 {
+			return errMalformed
+		}
+
+		buf = buf[n:]
+
+		field := int(tag >> 3)
+
+		switch tag & 7 {
+		case wireVarint:
+			num, n := binary.Uvarint(buf)
+
+			if n <= 0 {
+				return errMalformed
+			}
+
+			buf = buf[n:]
+
+			if err := fn(field, num, nil); err != nil {
+				return err
+			}
+		case wireBytes:
+			size, n := binary.Uvarint(buf)
+
+			if n <= 0 || size > uint64(len(buf)-n) {
+				return errMalformed
+			}
+
+			raw := buf[n : n+int(size)]
+			buf = buf[n+int(size):]
+
+			if err := fn(field, 0, raw); err != nil {
+				return err
+			}
+		default:
+			return errMalformed
+		}
+	}
+
+	return nil
+}
+
+func (this *Record) Unmarshal(buf []byte) error {
+	return readFields(buf, func(field int, num uint64, raw []byte) error {
+		switch field {
+		case 1:
+			this.Key = append([]byte{}, raw...)
+		case 2:
+			this.Value = append([]byte{}, raw...)
+		case 5:
+			this.TimeReceived = string(raw)
+		}
+
+		return nil
+	})
+}
+
+func (this *Peer) Unmarshal(buf []byte) error {
+	return readFields(buf, func(field int, num uint64, raw []byte) error {
+		switch field {
+		case 1:
+			this.Id = append([]byte{}, raw...)
+		case 2:
+			this.Addrs = append(this.Addrs, append([]byte{}, raw...))
+		case 3:
+			this.Connection = int(num)
+		}
+
+		return nil
+	})
+}
+
+func (this *Message) Unmarshal(buf []byte) error {
+	return readFields(buf, func(field int, num uint64, raw []byte) error {
+		switch field {
+		case 1:
+			this.Type = int(num)
+		case 2:
+			this.Key = append([]byte{}, raw...)
+		case 3:
+			this.Record = &Record{}
+
+			return this.Record.Unmarshal(raw)
+		case 8, 9:
+			var peer Peer
+
+			if err := peer.Unmarshal(raw); err != nil {
+				return err
+			}
+
+			if field == 8 {
+				this.CloserPeers = append(this.CloserPeers, peer)
+			} else {
+				this.ProviderPeers = append(this.ProviderPeers, peer)
+			}
+		case 10:
+			this.ClusterLevelRaw = int(num)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+package libp2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// multiaddr protocol codes
+const (
+	P_IP4 = 4
+	P_IP6 = 41
+	P_UDP = 273
+)
+
+// multihash identity code, used for peer ids as the hashes of the DHT are
+// shorter than the libp2p ones
+const (
+	MH_IDENTITY = 0
+)
+
+func PeerId(hash []byte) []byte {
+	return append([]byte{MH_IDENTITY, byte(len(hash))}, hash...)
+}
+
+func HashFromPeerId(id []byte) ([]byte, error) {
+	if len(id) < 2 || id[0] != MH_IDENTITY || int(id[1]) != len(id)-2 {
+		return nil, errors.New("Unsupported peer id")
+	}
+
+	return id[2:], nil
+}
+
+// Multiaddr encodes an ip:port UDP address as a binary multiaddr
+func Multiaddr(addr string) ([]byte, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf := []byte{}
+
+	if ip := udpAddr.IP.To4(); ip != nil {
+		buf = binary.AppendUvarint(buf, P_IP4)
+		buf = append(buf, ip...)
+	} else if ip := udpAddr.IP.To16(); ip != nil {
+		buf = binary.AppendUvarint(buf, P_IP6)
+		buf = append(buf, ip...)
+	} else {
+		return nil, errors.New("Unsupported address")
+	}
+
+	buf = binary.AppendUvarint(buf, P_UDP)
+
+	return binary.BigEndian.AppendUint16(buf, uint16(udpAddr.Port)), nil
+}
+
+// ParseMultiaddr decodes the ip4 or ip6 udp multiaddrs into ip:port
+func ParseMultiaddr(buf []byte) (string, error) {
+	code, n := binary.Uvarint(buf)
+
+	if n <= 0 {
+		return "", errMalformed
+	}
+
+	buf = buf[n:]
+
+	var ip net.IP
+
+	switch code {
+	case P_IP4:
+		if len(buf) < net.IPv4len {
+			return "", errMalformed
+		}
+
+		ip, buf = net.IP(buf[:net.IPv4len]), buf[net.IPv4len:]
+	case P_IP6:
+		if len(buf) < net.IPv6len {
+			return "", errMalformed
+		}
+
+		ip, buf = net.IP(buf[:net.IPv6len]), buf[net.IPv6len:]
+	default:
+		return "", errors.New("Unsupported multiaddr")
+	}
+
+	code, n = binary.Uvarint(buf)
+
+	if n <= 0 || code != P_UDP || len(buf[n:]) != 2 {
+		return "", errors.New("Unsupported multiaddr")
+	}
+
+	port := binary.BigEndian.Uint16(buf[n:])
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
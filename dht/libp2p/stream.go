@@ -0,0 +1,120 @@
+package libp2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	PROTOCOL_ID = "/ipfs/kad/1.0.0"
+	MAX_MESSAGE = 1024 * 1024 * 4
+)
+
+// ReadMessage reads a message prefixed with its uvarint length, as sent on
+// kad-dht streams
+func ReadMessage(r *bufio.Reader) (Message, error) {
+	var msg Message
+
+	size, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return msg, err
+	}
+
+	if size > MAX_MESSAGE {
+		return msg, errors.New("Message too big")
+	}
+
+	buf := make([]byte, size)
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return msg, err
+	}
+
+	err = msg.Unmarshal(buf)
+
+	return msg, err
+}
+
+func WriteMessage(w io.Writer, msg Message) error {
+	blob := msg.Marshal()
+
+	_, err := w.Write(append(binary.AppendUvarint(nil, uint64(len(blob))), blob...))
+
+	return err
+}
+
+// Client sends requests on a stream opened by the application to a libp2p
+// peer with PROTOCOL_ID
+type Client struct {
+	stream io.ReadWriter
+	reader *bufio.Reader
+}
+
+func NewClient(stream io.ReadWriter) *Client {
+	return &Client{
+		stream: stream,
+		reader: bufio.NewReader(stream),
+	}
+}
+
+func (this *Client) Call(msg Message) (Message, error) {
+	if err := WriteMessage(this.stream, msg); err != nil {
+		return Message{}, err
+	}
+
+	return ReadMessage(this.reader)
+}
+
+func (this *Client) Ping() error {
+	_, err := this.Call(Message{Type: PING})
+
+	return err
+}
+
+// GetValue returns the record value, or the closer peers to ask next
+func (this *Client) GetValue(key []byte) ([]byte, []Peer, error) {
+	res, err := this.Call(Message{Type: GET_VALUE, Key: key})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if res.Record == nil {
+		return nil, res.CloserPeers, nil
+	}
+
+	return res.Record.Value, res.CloserPeers, nil
+}
+
+func (this *Client) PutValue(key []byte, value []byte) error {
+	_, err := this.Call(Message{
+		Type:   PUT_VALUE,
+		Key:    key,
+		Record: &Record{Key: key, Value: value},
+	})
+
+	return err
+}
+
+func (this *Client) GetProviders(key []byte) ([]Peer, []Peer, error) {
+	res, err := this.Call(Message{Type: GET_PROVIDERS, Key: key})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res.ProviderPeers, res.CloserPeers, nil
+}
+
+func (this *Client) FindNode(key []byte) ([]Peer, error) {
+	res, err := this.Call(Message{Type: FIND_NODE, Key: key})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.CloserPeers, nil
+}
@@ -0,0 +1,259 @@
+package dht
+
+import (
+	"net"
+)
+
+const (
+	FAMILY_ANY  = 0
+	FAMILY_IPV4 = 4
+	FAMILY_IPV6 = 6
+	READERS     = 1
+)
+
+// addrFamily returns the family of an ip:port address, or FAMILY_ANY for a
+// wildcard or a hostname
+func addrFamily(addr string) int {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return FAMILY_ANY
+	}
+
+	ip := net.ParseIP(host)
+
+	switch {
+	case ip == nil || ip.IsUnspecified():
+		return FAMILY_ANY
+	case ip.To4() != nil:
+		return FAMILY_IPV4
+	default:
+		return FAMILY_IPV6
+	}
+}
+
+// connFamily returns the family of a socket, wildcard ones included
+func connFamily(conn net.PacketConn) int {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+
+	switch {
+	case !ok || addr.IP == nil:
+		return FAMILY_ANY
+	case addr.IP.To4() != nil:
+		return FAMILY_IPV4
+	default:
+		return FAMILY_IPV6
+	}
+}
+
+func contactFamily(contact PacketContact) int {
+	if contact.Family != FAMILY_ANY {
+		return contact.Family
+	}
+
+	return addrFamily(contact.Addr)
+}
+
+// listen opens the sockets of the ListenAddr and of the ListenAddrs
+func (this *Dht) listen() error {
+	servers := []net.PacketConn{}
+
+	for _, addr := range append([]string{this.options.ListenAddr}, this.options.ListenAddrs...) {
+		conns, err := this.listenAddr(addr)
+
+		if err != nil {
+			for _, conn := range servers {
+				conn.Close()
+			}
+
+			return err
+		}
+
+		servers = append(servers, conns...)
+	}
+
+	this.Lock()
+	this.servers = servers
+	this.Unlock()
+
+	return nil
+}
+
+// listenAddr opens a socket for the address. A wildcard address is listened
+// on both IPv4 and IPv6, the IPv6 one being optional
+func (this *Dht) listenAddr(addr string) ([]net.PacketConn, error) {
+	host, port, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	network := "udp"
+
+	switch addrFamily(addr) {
+	case FAMILY_IPV4:
+		network = "udp4"
+	case FAMILY_IPV6:
+		network = "udp6"
+	default:
+		if host == "0.0.0.0" || len(host) == 0 || host == "::" {
+			conns, err := this.listenPacket("udp4", net.JoinHostPort("0.0.0.0", port))
+
+			if err != nil {
+				return nil, err
+			}
+
+			conns6, err := this.listenPacket("udp6", net.JoinHostPort("::", port))
+
+			if err != nil {
+				this.logger.Warning("IPv6 disabled:", err)
+
+				return conns, nil
+			}
+
+			return append(conns, conns6...), nil
+		}
+	}
+
+	return this.listenPacket(network, addr)
+}
+
+// listenPacket opens one socket, or with ReusePort as many as Readers
+// sharing the address, the kernel spreading the packets between them
+func (this *Dht) listenPacket(network, addr string) ([]net.PacketConn, error) {
+	conn, err := this.transport().ListenPacket(network, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []net.PacketConn{conn}
+
+	if !this.options.ReusePort || this.options.Transport != nil {
+		return res, nil
+	}
+
+	// the port the first socket got, when asked for any
+	addr = conn.LocalAddr().String()
+
+	for len(res) < this.options.Readers {
+		conn, err := this.transport().ListenPacket(network, addr)
+
+		if err != nil {
+			for _, conn := range res {
+				conn.Close()
+			}
+
+			return nil, err
+		}
+
+		res = append(res, conn)
+	}
+
+	return res, nil
+}
+
+// readers is the number of read loops per socket. With ReusePort, the
+// Readers are the sockets themselves
+func (this *Dht) readers() int {
+	if this.options.ReusePort && this.options.Transport == nil {
+		return 1
+	}
+
+	return this.options.Readers
+}
+
+// server returns the first socket of the family, or of any family if none
+func (this *Dht) server(family int) net.PacketConn {
+	this.RLock()
+	defer this.RUnlock()
+
+	var res net.PacketConn
+
+	for _, conn := range this.servers {
+		if connFamily(conn) == family {
+			return conn
+		}
+
+		if res == nil || connFamily(conn) == FAMILY_ANY {
+			res = conn
+		}
+	}
+
+	return res
+}
+
+// writeTo sends the datagram with a socket of the same family as addr
+func (this *Dht) writeTo(datagram []byte, addr net.Addr) error {
+	conn := this.server(addrFamily(addr.String()))
+
+	if conn == nil {
+		return ErrTransport
+	}
+
+	_, err := conn.WriteTo(datagram, addr)
+
+	return err
+}
+
+func (this *Dht) canReachFamily(family int) bool {
+	if family == FAMILY_ANY {
+		return true
+	}
+
+	this.RLock()
+	defer this.RUnlock()
+
+	for _, conn := range this.servers {
+		if connFamily(conn) == family || connFamily(conn) == FAMILY_ANY {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (this *Dht) canReachAddr(addr string) bool {
+	return this.canReachFamily(addrFamily(addr))
+}
+
+// canReach tells if one of the addresses of the contact is in a family we
+// have a socket for
+func (this *Dht) canReach(contact PacketContact) bool {
+	if this.canReachFamily(contactFamily(contact)) {
+		return true
+	}
+
+	for _, addr := range contact.Addrs {
+		if this.canReachAddr(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contactAddrs(contact PacketContact) []string {
+	return append([]string{contact.Addr}, contact.Addrs...)
+}
+
+// resolve returns the address to reach the contact at: the first of its
+// addresses, ordered by preference by their owner, that we can reach
+func (this *Dht) resolve(contact PacketContact) (*net.UDPAddr, error) {
+	for _, addr := range contactAddrs(contact) {
+		if this.canReachAddr(addr) {
+			return net.ResolveUDPAddr("udp", addr)
+		}
+	}
+
+	return net.ResolveUDPAddr("udp", contact.Addr)
+}
+
+func (this *Dht) closeServers() {
+	this.Lock()
+	defer this.Unlock()
+
+	for _, conn := range this.servers {
+		conn.Close()
+	}
+}
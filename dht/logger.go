@@ -0,0 +1,89 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Logger is satisfied by *logging.Logger, the default one, and by the
+// slog adapter returned by NewSlogLogger
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warning(args ...interface{})
+	Error(args ...interface{})
+	Critical(args ...interface{})
+}
+
+// LogField is a structured value passed among the log arguments. Text loggers
+// print it as key=value
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+func (this LogField) String() string {
+	return this.Key + "=" + fmt.Sprint(this.Value)
+}
+
+const (
+	slogLevelCritical = slog.LevelError + 4
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger logs to a slog.Logger. The nodes among the arguments become
+// peer and addr attributes, the LogFields become attributes, and the other
+// arguments make the message
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func (this slogLogger) Debug(args ...interface{}) {
+	this.log(slog.LevelDebug, args)
+}
+
+func (this slogLogger) Info(args ...interface{}) {
+	this.log(slog.LevelInfo, args)
+}
+
+func (this slogLogger) Warning(args ...interface{}) {
+	this.log(slog.LevelWarn, args)
+}
+
+func (this slogLogger) Error(args ...interface{}) {
+	this.log(slog.LevelError, args)
+}
+
+func (this slogLogger) Critical(args ...interface{}) {
+	this.log(slogLevelCritical, args)
+}
+
+func (this slogLogger) log(level slog.Level, args []interface{}) {
+	if !this.logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	msg := []string{}
+	attrs := []interface{}{}
+
+	for _, arg := range args {
+		switch value := arg.(type) {
+		case *Node:
+			attrs = append(attrs, slog.String("peer", hex.EncodeToString(value.contact.Hash)), slog.String("addr", value.contact.Addr))
+		case PacketContact:
+			attrs = append(attrs, slog.String("peer", hex.EncodeToString(value.Hash)), slog.String("addr", value.Addr))
+		case LogField:
+			attrs = append(attrs, slog.Any(value.Key, value.Value))
+		default:
+			msg = append(msg, fmt.Sprint(value))
+		}
+	}
+
+	this.logger.Log(context.Background(), level, strings.Join(msg, " "), attrs...)
+}
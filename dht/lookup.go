@@ -0,0 +1,225 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/champii/go-dht/dht/kad"
+)
+
+const (
+	ALPHA = 3
+)
+
+// QueryResult is the answer of a node to a lookup query: either the value,
+// or closer nodes to continue with
+type QueryResult struct {
+	Value interface{}
+	Found bool
+	Nodes []PacketContact
+}
+
+type QueryJob func(*Node) (QueryResult, error)
+
+type lookupEntry struct {
+	node      *Node
+	queried   bool
+	responded bool
+}
+
+type lookupAnswer struct {
+	entry *lookupEntry
+	res   QueryResult
+	err   error
+}
+
+type Lookup struct {
+	dht       *Dht
+	hash      []byte
+	job       QueryJob
+	shortlist []*lookupEntry
+	seen      map[string]bool
+	inflight  int
+	answers   chan lookupAnswer
+	seeds     []PacketContact
+	claims    *sync.Map
+	path      int
+	trace     *LookupTrace
+	span      string
+	require   Capabilities
+	ctx       context.Context
+	k         int
+	alpha     int
+}
+
+// NewLookup copies K and Alpha, for a Reconfigure not to change them while
+// the lookup runs
+func NewLookup(hash []byte, job QueryJob, dht *Dht) *Lookup {
+	tuned := dht.tuned()
+
+	return &Lookup{
+		dht:     dht,
+		hash:    hash,
+		job:     job,
+		seen:    make(map[string]bool),
+		answers: make(chan lookupAnswer, tuned.Alpha),
+		ctx:     context.Background(),
+		k:       tuned.K,
+		alpha:   tuned.Alpha,
+	}
+}
+
+// Run returns the value if a node answered with FOUND or with some providers,
+// and the K closest nodes that answered otherwise. Once its context ends, it
+// returns the closest nodes that answered so far, and the queries in flight
+// end with it
+func (this *Lookup) Run() (interface{}, bool, []*Node) {
+	span := this.dht.startSpan("", "dht.lookup", SPAN_INTERNAL)
+	defer span.End(nil)
+
+	this.span = span.TraceParent()
+
+	this.dht.routing.Touch(this.hash)
+
+	if this.seeds == nil {
+		this.seeds = this.dht.routing.FindNode(this.hash)
+	}
+
+	for _, contact := range this.seeds {
+		this.addContact(contact)
+	}
+
+	for {
+		this.queryNext()
+
+		if this.inflight == 0 {
+			break
+		}
+
+		var answer lookupAnswer
+
+		select {
+		case answer = <-this.answers:
+		case <-this.ctx.Done():
+			return nil, false, this.closest()
+		}
+
+		this.inflight--
+
+		if answer.err != nil {
+			this.remove(answer.entry)
+			continue
+		}
+
+		answer.entry.responded = true
+
+		if answer.res.Found {
+			return answer.res.Value, true, this.closest()
+		}
+
+		for _, contact := range answer.res.Nodes {
+			this.addContact(contact)
+		}
+	}
+
+	return nil, false, this.closest()
+}
+
+func (this *Lookup) queryNext() {
+	for i, entry := range this.shortlist {
+		if this.inflight >= this.alpha || i >= this.k {
+			return
+		}
+
+		if entry.queried {
+			continue
+		}
+
+		// a node already queried by another disjoint path is skipped
+		if this.claims != nil {
+			if owner, loaded := this.claims.LoadOrStore(hex.EncodeToString(entry.node.contact.Hash), this); loaded && owner != this {
+				this.remove(entry)
+				this.queryNext()
+
+				return
+			}
+		}
+
+		entry.queried = true
+		entry.node.span = this.span
+		entry.node.ctx = this.ctx
+		this.inflight++
+
+		go func(entry *lookupEntry) {
+			sent := time.Now()
+
+			res, err := this.job(entry.node)
+
+			this.trace.addHop(LookupHop{
+				Path:     this.path,
+				Contact:  entry.node.contact,
+				Duration: time.Since(sent),
+				Nodes:    res.Nodes,
+				Found:    res.Found,
+				Err:      err,
+			})
+
+			this.answers <- lookupAnswer{
+				entry: entry,
+				res:   res,
+				err:   err,
+			}
+		}(entry)
+	}
+}
+
+func (this *Lookup) addContact(contact PacketContact) {
+	key := hex.EncodeToString(contact.Hash)
+
+	if this.seen[key] || compare(contact.Hash, this.dht.hash) == 0 || this.dht.isContactBanned(contact) || !this.dht.validContact(contact) || !this.dht.canReach(contact) {
+		return
+	}
+
+	addr, err := this.dht.resolve(contact)
+
+	if err != nil {
+		return
+	}
+
+	this.seen[key] = true
+	this.shortlist = append(this.shortlist, &lookupEntry{
+		node: NewNodeContact(this.dht, addr, contact),
+	})
+
+	sort.SliceStable(this.shortlist, func(i, j int) bool {
+		return kad.Closer(this.shortlist[i].node.contact.Hash, this.shortlist[j].node.contact.Hash, this.hash)
+	})
+}
+
+func (this *Lookup) remove(entry *lookupEntry) {
+	for i, e := range this.shortlist {
+		if e == entry {
+			this.shortlist = append(this.shortlist[:i], this.shortlist[i+1:]...)
+			return
+		}
+	}
+}
+
+func (this *Lookup) closest() []*Node {
+	res := []*Node{}
+
+	for _, entry := range this.shortlist {
+		if len(res) == this.k {
+			break
+		}
+
+		if entry.responded && entry.node.contact.Has(this.require) {
+			res = append(res, entry.node)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,283 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	MDNS_ADDR     = "224.0.0.251:5353"
+	MDNS_SERVICE  = "_go-dht._udp.local."
+	MDNS_INTERVAL = time.Minute
+
+	dnsTypeTXT   = 16
+	dnsClassIN   = 1
+	dnsFlagReply = 0x8400
+)
+
+// startMdns announces this node on the local network and pings the peers
+// announcing themselves, so the pong adds them to the routing table
+func (this *Dht) startMdns() error {
+	group, err := net.ResolveUDPAddr("udp4", MDNS_ADDR)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	this.mdns = conn
+	this.Unlock()
+
+	go func() {
+		var buf [9000]byte
+
+		for {
+			n, from, err := conn.ReadFromUDP(buf[:])
+
+			if err != nil {
+				return
+			}
+
+			this.onMdns(conn, group, from, buf[:n])
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(MDNS_INTERVAL)
+		defer ticker.Stop()
+
+		conn.WriteToUDP(mdnsQuery(), group)
+
+		for this.running {
+			conn.WriteToUDP(this.mdnsAnnounce(), group)
+
+			<-ticker.C
+		}
+	}()
+
+	return nil
+}
+
+func (this *Dht) stopMdns() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.mdns != nil {
+		this.mdns.Close()
+		this.mdns = nil
+	}
+}
+
+func (this *Dht) onMdns(conn *net.UDPConn, group *net.UDPAddr, from *net.UDPAddr, msg []byte) {
+	query, txts, err := parseMdns(msg)
+
+	if err != nil {
+		return
+	}
+
+	if query {
+		conn.WriteToUDP(this.mdnsAnnounce(), group)
+		return
+	}
+
+	for _, txt := range txts {
+		fields := make(map[string]string)
+
+		for _, field := range txt {
+			if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+				fields[kv[0]] = kv[1]
+			}
+		}
+
+		hash, err := hex.DecodeString(fields["id"])
+
+		if err != nil || len(fields["port"]) == 0 || compare(hash, this.hash) == 0 {
+			continue
+		}
+
+		if _, err := this.routing.GetNode(hash); err == nil {
+			continue
+		}
+
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(from.IP.String(), fields["port"]))
+
+		if err != nil {
+			continue
+		}
+
+		this.logger.Debug("mDNS peer", addr)
+
+		go NewNode(this, addr, []byte{}).Ping()
+	}
+}
+
+func (this *Dht) mdnsAnnounce() []byte {
+	_, port, _ := net.SplitHostPort(this.options.ListenAddr)
+
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, []uint16{0, dnsFlagReply, 0, 1, 0, 0})
+
+	writeDnsName(&buf, MDNS_SERVICE)
+
+	var rdata bytes.Buffer
+
+	for _, field := range []string{"id=" + hex.EncodeToString(this.hash), "port=" + port} {
+		rdata.WriteByte(byte(len(field)))
+		rdata.WriteString(field)
+	}
+
+	binary.Write(&buf, binary.BigEndian, []uint16{dnsTypeTXT, dnsClassIN})
+	binary.Write(&buf, binary.BigEndian, uint32(MDNS_INTERVAL.Seconds()*2))
+	binary.Write(&buf, binary.BigEndian, uint16(rdata.Len()))
+	buf.Write(rdata.Bytes())
+
+	return buf.Bytes()
+}
+
+func mdnsQuery() []byte {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, []uint16{0, 0, 1, 0, 0, 0})
+
+	writeDnsName(&buf, MDNS_SERVICE)
+
+	binary.Write(&buf, binary.BigEndian, []uint16{dnsTypeTXT, dnsClassIN})
+
+	return buf.Bytes()
+}
+
+func writeDnsName(buf *bytes.Buffer, name string) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+
+	buf.WriteByte(0)
+}
+
+// parseMdns tells if the message queries our service, or returns the TXT
+// records announcing it
+func parseMdns(msg []byte) (bool, [][]string, error) {
+	if len(msg) < 12 {
+		return false, nil, errors.New("Invalid mDNS message")
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	query := false
+
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readDnsName(msg, off)
+
+		if err != nil || next+4 > len(msg) {
+			return false, nil, errors.New("Invalid mDNS question")
+		}
+
+		if flags&0x8000 == 0 && strings.EqualFold(name, MDNS_SERVICE) {
+			query = true
+		}
+
+		off = next + 4
+	}
+
+	txts := [][]string{}
+
+	for i := 0; i < anCount; i++ {
+		name, next, err := readDnsName(msg, off)
+
+		if err != nil || next+10 > len(msg) {
+			return query, txts, errors.New("Invalid mDNS answer")
+		}
+
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		rdLength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		off = next + 10
+
+		if off+rdLength > len(msg) {
+			return query, txts, errors.New("Invalid mDNS answer")
+		}
+
+		if rtype == dnsTypeTXT && strings.EqualFold(name, MDNS_SERVICE) {
+			txts = append(txts, readTxt(msg[off:off+rdLength]))
+		}
+
+		off += rdLength
+	}
+
+	return query, txts, nil
+}
+
+func readDnsName(msg []byte, off int) (string, int, error) {
+	labels := []string{}
+	next := -1
+
+	// bounded to avoid compression pointer loops
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, errors.New("Invalid DNS name")
+		}
+
+		length := int(msg[off])
+
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("Invalid DNS name")
+			}
+
+			if next < 0 {
+				next = off + 2
+			}
+
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errors.New("Invalid DNS name")
+			}
+
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+
+	return "", 0, errors.New("Invalid DNS name")
+}
+
+func readTxt(rdata []byte) []string {
+	res := []string{}
+
+	for len(rdata) > 0 {
+		length := int(rdata[0])
+
+		if 1+length > len(rdata) {
+			break
+		}
+
+		res = append(res, string(rdata[1:1+length]))
+		rdata = rdata[1+length:]
+	}
+
+	return res
+}
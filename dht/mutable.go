@@ -0,0 +1,205 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+type MutableRecord struct {
+	PublicKey    []byte
+	Seq          int64
+	Cas          int64
+	CheckCas     bool
+	ExpectAbsent bool
+	Value        interface{}
+	Signature    []byte
+}
+
+type StoreConflict struct {
+	Hash []byte
+	Seq  int64
+}
+
+func (this StoreConflict) Error() string {
+	return hex.EncodeToString(this.Hash) + ": Conflict, current seq is " + strconv.FormatInt(this.Seq, 10)
+}
+
+func (this MutableRecord) signedBytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.Write(this.PublicKey)
+	binary.Write(&buf, binary.BigEndian, this.Seq)
+	binary.Write(&buf, binary.BigEndian, this.Cas)
+	binary.Write(&buf, binary.BigEndian, this.CheckCas)
+	binary.Write(&buf, binary.BigEndian, this.ExpectAbsent)
+
+	if err := gob.NewEncoder(&buf).Encode(&this.Value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Verify checks the signature of the record, and that hash is the one of its
+// public key with the default hash function
+func (this MutableRecord) Verify(hash []byte) error {
+	return this.verify(hash, NewHash)
+}
+
+func (this MutableRecord) verify(hash []byte, newHash func([]byte) []byte) error {
+	if len(this.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("Invalid public key")
+	}
+
+	if compare(newHash(this.PublicKey), hash) != 0 {
+		return errors.New("Key does not match public key")
+	}
+
+	blob, err := this.signedBytes()
+
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(this.PublicKey, blob, this.Signature) {
+		return errors.New("Invalid signature")
+	}
+
+	return nil
+}
+
+func NewMutableRecord(priv ed25519.PrivateKey, seq int64, value interface{}) (MutableRecord, error) {
+	record := MutableRecord{
+		PublicKey: priv.Public().(ed25519.PublicKey),
+		Seq:       seq,
+		Value:     value,
+	}
+
+	err := record.Sign(priv)
+
+	return record, err
+}
+
+// Sign signs the record with priv, its store conditions included, so that
+// they cannot be changed on the way
+func (this *MutableRecord) Sign(priv ed25519.PrivateKey) error {
+	blob, err := this.signedBytes()
+
+	if err != nil {
+		return err
+	}
+
+	this.Signature = ed25519.Sign(priv, blob)
+
+	return nil
+}
+
+func (this *Dht) StoreMutable(priv ed25519.PrivateKey, seq int64, value interface{}) ([]byte, int, error) {
+	return this.StoreMutableCAS(priv, seq, 0, value)
+}
+
+// StoreMutableCAS only replaces the record if its current seq is cas. A node
+// without the record refuses it
+func (this *Dht) StoreMutableCAS(priv ed25519.PrivateKey, seq int64, cas int64, value interface{}) ([]byte, int, error) {
+	return this.storeMutableIf(priv, MutableRecord{Seq: seq, Cas: cas, CheckCas: true, Value: value})
+}
+
+// StoreMutableNew only stores the record on the nodes that have none yet
+func (this *Dht) StoreMutableNew(priv ed25519.PrivateKey, seq int64, value interface{}) ([]byte, int, error) {
+	return this.storeMutableIf(priv, MutableRecord{Seq: seq, ExpectAbsent: true, Value: value})
+}
+
+func (this *Dht) storeMutableIf(priv ed25519.PrivateKey, record MutableRecord) ([]byte, int, error) {
+	record.PublicKey = priv.Public().(ed25519.PublicKey)
+
+	if err := record.Sign(priv); err != nil {
+		return []byte{}, 0, err
+	}
+
+	hash := this.NewHash(record.PublicKey)
+
+	return this.StoreAt(hash, record)
+}
+
+func (this *Dht) FetchMutable(pub ed25519.PublicKey) (interface{}, int64, error) {
+	hash := this.NewHash(pub)
+
+	res, err := this.Fetch(hash)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	record, ok := res.(MutableRecord)
+
+	if !ok {
+		return nil, 0, errors.New("Not a mutable record")
+	}
+
+	if err := record.verify(hash, this.NewHash); err != nil {
+		return nil, 0, err
+	}
+
+	return record.Value, record.Seq, nil
+}
+
+// acceptStore tells if inst can be stored over the existing local value
+func (this *Dht) acceptStore(inst StoreInst, existing StoreInst, exists bool) error {
+	// the CRDT were merged instead
+	if _, ok := inst.Data.(CRDT); ok {
+		if _, ok := existing.Data.(CRDT); exists && !ok {
+			return errAlreadyExists
+		}
+
+		return nil
+	}
+
+	record, mutable := inst.Data.(MutableRecord)
+
+	if !mutable {
+		if exists {
+			return errAlreadyExists
+		}
+
+		return nil
+	}
+
+	if err := record.verify(inst.Hash, this.NewHash); err != nil {
+		return err
+	}
+
+	if !exists {
+		if record.CheckCas {
+			return StoreConflict{Hash: inst.Hash, Seq: 0}
+		}
+
+		return nil
+	}
+
+	old, ok := existing.Data.(MutableRecord)
+
+	if !ok {
+		return errAlreadyExists
+	}
+
+	if record.ExpectAbsent {
+		return StoreConflict{Hash: inst.Hash, Seq: old.Seq}
+	}
+
+	// a copy behind cas is a stale one, that the owner has signed the record
+	// over a newer seq of. Only a copy that moved past cas conflicts
+	if record.CheckCas && old.Seq > record.Cas {
+		return StoreConflict{Hash: inst.Hash, Seq: old.Seq}
+	}
+
+	if record.Seq <= old.Seq {
+		return StoreConflict{Hash: inst.Hash, Seq: old.Seq}
+	}
+
+	return nil
+}
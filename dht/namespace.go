@@ -0,0 +1,159 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Namespace holds the policies of the keys under a prefix such as "/app".
+// Its Validator applies on top of the DhtOptions one, and TTL caps the
+// lifetime of its records
+type Namespace struct {
+	Validator Validator
+	TTL       time.Duration
+}
+
+// KeyHash is the DHT key of a namespaced key such as "/app/users/<id>"
+func KeyHash(key string) []byte {
+	return NewHash([]byte(key))
+}
+
+// KeyHash hashes key with the Hasher and IdBits of the node
+func (this *Dht) KeyHash(key string) []byte {
+	return this.NewHash([]byte(key))
+}
+
+// namespace returns the registered namespace with the longest prefix of key
+func (this *Dht) namespace(key string) (Namespace, bool) {
+	res := Namespace{}
+	found := ""
+
+	for prefix, namespace := range this.options.Namespaces {
+		prefix = strings.TrimSuffix(prefix, "/")
+
+		if key != prefix && !strings.HasPrefix(key, prefix+"/") {
+			continue
+		}
+
+		if len(prefix) >= len(found) {
+			res = namespace
+			found = prefix
+		}
+	}
+
+	return res, len(found) > 0
+}
+
+// checkNamespace rejects the records whose key does not hash to their hash
+// or that their namespace refuses, and caps their expiration to its TTL
+func (this *Dht) checkNamespace(inst StoreInst) (StoreInst, error) {
+	if len(inst.Key) == 0 {
+		return inst, nil
+	}
+
+	if compare(this.KeyHash(inst.Key), inst.Hash) != 0 {
+		return inst, errors.New("Key does not match hash")
+	}
+
+	namespace, ok := this.namespace(inst.Key)
+
+	if !ok {
+		return inst, nil
+	}
+
+	if namespace.Validator != nil {
+		if err := namespace.Validator.Validate(inst.Hash, inst.Data); err != nil {
+			return inst, err
+		}
+	}
+
+	if namespace.TTL > 0 {
+		max := time.Now().Add(namespace.TTL).UnixNano()
+
+		if inst.Expiration == 0 || inst.Expiration > max {
+			inst.Expiration = max
+		}
+	}
+
+	return inst, nil
+}
+
+// selector picks the conflicting records with the Validator of their
+// namespace, if any
+func (this *Dht) selector(key string) Validator {
+	if namespace, ok := this.namespace(key); ok && namespace.Validator != nil {
+		return namespace.Validator
+	}
+
+	return this.options.Validator
+}
+
+// StoreKey stores value at the hash of key, keeping key in the record so
+// the nodes storing it apply the policies of its namespace
+func (this *Dht) StoreKey(key string, value interface{}) ([]byte, int, error) {
+	return this.storeKey(key, value, true)
+}
+
+// StoreKeyReplica stores value at the hash of key in the name of another
+// writer: this node neither signs nor republishes it, and cannot delete it
+func (this *Dht) StoreKeyReplica(key string, value interface{}) ([]byte, int, error) {
+	return this.storeKey(key, value, false)
+}
+
+func (this *Dht) storeKey(key string, value interface{}, publish bool) ([]byte, int, error) {
+	if err := this.checkValueSize(value); err != nil {
+		return []byte{}, 0, err
+	}
+
+	hash := this.KeyHash(key)
+
+	inst := StoreInst{
+		Hash: hash,
+		Key:  key,
+		Data: value,
+	}
+
+	if publish {
+		inst.DeleteToken = this.NewHash(this.deleteKey(hash))
+	}
+
+	inst, err := this.checkNamespace(inst)
+
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	if publish {
+		inst = this.signPublisher(inst)
+
+		this.Lock()
+		this.published[hex.EncodeToString(hash)] = inst
+		this.Unlock()
+	}
+
+	this.uncacheRead(hash)
+
+	return this.storeAt(inst)
+}
+
+// FetchKey fetches the value stored with StoreKey, checked against the
+// Validator of its namespace
+func (this *Dht) FetchKey(key string) (interface{}, error) {
+	hash := this.KeyHash(key)
+
+	value, err := this.fetchChecked(hash, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace, ok := this.namespace(key); ok && namespace.Validator != nil {
+		if err := namespace.Validator.Validate(hash, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,25 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	NETWORK_ID   = "go-dht"
+	NETWORK_SIZE = 4
+)
+
+// networkMagic prefixes every datagram, so nodes of separate deployments
+// sharing bootstrap nodes ignore each other
+func networkMagic(networkId string) []byte {
+	return NewHash([]byte(networkId))[:NETWORK_SIZE]
+}
+
+func (this *Dht) stripMagic(datagram []byte) ([]byte, error) {
+	if len(datagram) < NETWORK_SIZE || !bytes.Equal(datagram[:NETWORK_SIZE], this.magic) {
+		return nil, errors.New("Foreign network")
+	}
+
+	return datagram[NETWORK_SIZE:], nil
+}
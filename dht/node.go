@@ -1,7 +1,7 @@
 package dht
 
 import (
-	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
@@ -24,12 +24,47 @@ const (
 	COMMAND_BROADCAST
 	COMMAND_CUSTOM
 	COMMAND_CUSTOM_ANSWER
+	COMMAND_DELETE
+	COMMAND_DELETED
+	COMMAND_ADD_PROVIDER
+	COMMAND_GET_PROVIDERS
+	COMMAND_PROVIDERS
+	COMMAND_BUSY
+	COMMAND_PEX
+	COMMAND_PEX_ANSWER
+	COMMAND_HOLEPUNCH
+	COMMAND_HOLEPUNCH_INTENT
+	COMMAND_RELAY
+	COMMAND_RELAYED
+	COMMAND_PUBLISH
+	COMMAND_HELLO
+	COMMAND_HELLO_ANSWER
+	COMMAND_ERROR
+	COMMAND_FETCH_STREAM
+	COMMAND_STREAM_INFO
+	COMMAND_FETCH_CHUNK
+	COMMAND_CHUNK
+	COMMAND_RPC
+	COMMAND_RPC_ANSWER
+	COMMAND_SESSION
+	COMMAND_SESSION_ACK
+	COMMAND_REGISTER
+	COMMAND_FETCH_RECORD
+)
+
+const (
+	RPC_TIMEOUT = time.Second * 5
+	RPC_BACKOFF = time.Millisecond * 500
 )
 
 type Callback func(val Packet, err error)
 
 type CallbackChan struct {
-	timer *time.Timer
+	timer rpcTimer
+	sent  time.Time
+	peer  string
+	hash  []byte
+	addrs []string
 	c     chan interface{}
 }
 
@@ -38,11 +73,22 @@ type Node struct {
 	lastSeen int64
 	addr     net.Addr
 	dht      *Dht
+	timeout  time.Duration
+	retries  int
+	observed string
+	span     string
+	ctx      context.Context
+	batching bool
 }
 
+// PacketContact holds the preferred address of a node, and the other ones
+// it can be reached at
 type PacketContact struct {
-	Hash []byte
-	Addr string
+	Hash         []byte
+	Addr         string
+	Family       int
+	Addrs        []string
+	Capabilities Capabilities
 }
 
 type PacketHeader struct {
@@ -51,6 +97,19 @@ type PacketHeader struct {
 	Sender      PacketContact
 	ResponseTo  []byte
 	MessageHash []byte
+	Compressed  bool
+	PublicKey   []byte
+	Signature   []byte
+	BoxKey      []byte
+	Encrypted   bool
+	Seq         uint64
+	Observed    string
+	TraceParent string
+	TTL         int
+	BroadcastId []byte
+	Ack         bool
+	Version     int
+	Rpc         string
 }
 
 type Packet struct {
@@ -59,8 +118,20 @@ type Packet struct {
 }
 
 type StoreInst struct {
+	Hash         []byte
+	Key          string
+	Data         interface{}
+	Expiration   int64
+	DeleteToken  []byte
+	Publisher    []byte
+	PublisherKey []byte
+	PublisherSig []byte
+	Shard        bool
+}
+
+type DeleteInst struct {
 	Hash []byte
-	Data interface{}
+	Key  []byte
 }
 
 type CustomCmd struct {
@@ -69,18 +140,17 @@ type CustomCmd struct {
 }
 
 func NewPacket(dht *Dht, command int, responseTo []byte, data interface{}) Packet {
-	addr, err := net.ResolveUDPAddr("udp", dht.options.ListenAddr)
-
 	packet := Packet{
 		Header: PacketHeader{
 			DateSent:    time.Now().UnixNano(),
 			Command:     command,
 			ResponseTo:  responseTo,
 			MessageHash: []byte{},
-			Sender: PacketContact{
-				Addr: addr.String(),
-				Hash: dht.hash,
-			},
+			Sender:      dht.contact(),
+			PublicKey:   dht.publicKey,
+			BoxKey:      dht.boxKey.PublicKey().Bytes(),
+			Seq:         dht.nextSeq(),
+			Version:     PROTOCOL_VERSION,
 		},
 		Data: data,
 	}
@@ -91,13 +161,38 @@ func NewPacket(dht *Dht, command int, responseTo []byte, data interface{}) Packe
 		dht.logger.Warning(err)
 	}
 
-	packet.Header.MessageHash = NewHash(tmp)
+	packet.Header.MessageHash = dht.NewHash(tmp)
 
 	return packet
 }
 
+// refreshPacket gives the packet a new date, sequence number and hash,
+// keeping the other header fields
+func (this *Dht) refreshPacket(packet Packet) Packet {
+	packet.Header.DateSent = time.Now().UnixNano()
+	packet.Header.Seq = this.nextSeq()
+	packet.Header.MessageHash = []byte{}
+
+	tmp, err := msgpack.Marshal(&packet)
+
+	if err != nil {
+		this.logger.Warning(err)
+	}
+
+	packet.Header.MessageHash = this.NewHash(tmp)
+
+	return packet
+}
+
+// newPacket echoes in the answers the address the request came from
 func (this *Node) newPacket(command int, responseTo []byte, data interface{}) Packet {
-	return NewPacket(this.dht, command, responseTo, data)
+	packet := NewPacket(this.dht, command, responseTo, data)
+
+	if len(responseTo) > 0 {
+		packet.Header.Observed = this.observed
+	}
+
+	return packet
 }
 
 func NewNodeContact(dht *Dht, addr net.Addr, contact PacketContact) *Node {
@@ -106,6 +201,7 @@ func NewNodeContact(dht *Dht, addr net.Addr, contact PacketContact) *Node {
 		addr:     addr,
 		lastSeen: time.Now().Unix(),
 		contact:  contact,
+		retries:  -1,
 	}
 }
 
@@ -116,6 +212,42 @@ func NewNode(dht *Dht, addr net.Addr, hash []byte) *Node {
 	})
 }
 
+// WithTimeout returns a copy of the node whose requests use the given
+// timeout and number of retries instead of the DhtOptions ones. A timeout
+// of 0 keeps RpcTimeout, negative retries keep RpcRetries, and 0 retries
+// sends each request once
+func (this *Node) WithTimeout(timeout time.Duration, retries int) *Node {
+	node := *this
+	node.timeout = timeout
+	node.retries = retries
+
+	return &node
+}
+
+// withContext returns a copy of the node whose requests end with ctx
+func (this *Node) withContext(ctx context.Context) *Node {
+	node := *this
+	node.ctx = ctx
+
+	return &node
+}
+
+func (this *Node) context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+
+	return this.ctx
+}
+
+func (this *Node) rpcRetries() int {
+	if this.retries >= 0 {
+		return this.retries
+	}
+
+	return this.dht.options.RpcRetries
+}
+
 func (this *Node) Redacted() interface{} {
 	if len(this.contact.Hash) == 0 {
 		return this.contact.Addr
@@ -126,17 +258,34 @@ func (this *Node) Redacted() interface{} {
 
 func (this *Node) HandleInPacket(packet Packet) {
 	if len(packet.Header.ResponseTo) > 0 {
-		this.dht.Lock()
-		cb, ok := this.dht.commandQueue[hex.EncodeToString(packet.Header.ResponseTo)]
+		cb, err := this.dht.calls.takeAnswer(packet.Header.ResponseTo, packet.Header.Sender, this.observed)
 
-		if !ok {
+		if err == errWrongSender {
+			this.dht.logger.Warning(this, "x", err, hex.EncodeToString(packet.Header.ResponseTo), this.observed)
+			return
+		}
+
+		if err != nil {
 			this.dht.logger.Info(this, "x Unknown response: ", hex.EncodeToString(packet.Header.ResponseTo), packet)
-			this.dht.Unlock()
 			return
 		}
 
-		cb.timer.Stop()
-		this.dht.Unlock()
+		// too late, the timeout is being delivered
+		if !cb.timer.Stop() {
+			return
+		}
+
+		this.dht.observeAddr(this.observed, packet.Header.Observed)
+
+		latency := time.Since(cb.sent)
+
+		this.dht.routing.UpdateRTT(this.contact.Hash, latency)
+
+		this.dht.logger.Debug(this, "> ANSWER",
+			LogField{"command", packet.Header.Command},
+			LogField{"message", hex.EncodeToString(packet.Header.ResponseTo)},
+			LogField{"latency", latency},
+		)
 
 		switch packet.Header.Command {
 		case COMMAND_NOOP:
@@ -152,43 +301,115 @@ func (this *Node) HandleInPacket(packet Packet) {
 			this.OnStored(packet, cb)
 		case COMMAND_CUSTOM_ANSWER:
 			this.OnCustomAnswer(packet, cb)
+		case COMMAND_DELETED:
+			this.OnDeleted(packet, cb)
+		case COMMAND_PROVIDERS:
+			this.OnProviders(packet, cb)
+		case COMMAND_BUSY:
+			this.OnBusy(packet, cb)
+		case COMMAND_PEX_ANSWER:
+			this.OnPexAnswer(packet, cb)
+		case COMMAND_HELLO_ANSWER:
+			this.OnHelloAnswer(packet, cb)
+		case COMMAND_ERROR:
+			this.OnError(packet, cb)
+		case COMMAND_STREAM_INFO:
+			this.OnStreamInfo(packet, cb)
+		case COMMAND_CHUNK:
+			this.OnChunk(packet, cb)
+		case COMMAND_RPC_ANSWER:
+			this.OnCallAnswer(packet, cb)
+		case COMMAND_SESSION_ACK:
+			this.OnSessionAck(packet, cb)
 
 		default:
 			this.dht.logger.Error(this, "x answer: UNKNOWN COMMAND", packet.Header.Command)
-			return
+			cb.c <- this.newError(ErrInvalidResponse, nil)
 		}
-
-		this.dht.Lock()
-		// close(cb.c)
-		delete(this.dht.commandQueue, hex.EncodeToString(packet.Header.ResponseTo))
-		this.dht.Unlock()
 	} else {
-		switch packet.Header.Command {
-		case COMMAND_NOOP:
-		case COMMAND_PING:
-			this.OnPing(packet)
-		case COMMAND_FETCH:
-			this.OnFetch(packet)
-		case COMMAND_FETCH_NODES:
-			this.OnFetchNodes(packet)
-		case COMMAND_BROADCAST:
-			this.OnBroadcast(packet)
-		case COMMAND_STORE:
-			this.OnStore(packet)
-		case COMMAND_CUSTOM:
-			this.OnCustom(packet)
-		default:
-			this.dht.logger.Error(this, "x query: UNKNOWN COMMAND", packet.Header.Command)
+		span := this.dht.startSpan(packet.Header.TraceParent, spanName(packet.Header.Command), SPAN_SERVER)
+		defer span.End(nil)
+
+		// requests sent while handling this one are children of its span
+		this.span = span.TraceParent()
+
+		if err := this.dht.checkPayload(packet); err != nil {
+			this.dht.logger.Warning(this, "x", commandName(packet.Header.Command), err)
+
+			if len(this.observed) > 0 {
+				this.dht.strike(this.observed)
+			}
+
+			if expectsAnswer(packet) {
+				this.Invalid(packet, err)
+			}
+
 			return
 		}
+
+		this.handleQuery(packet)
 	}
 
 }
 
-func (this *Node) Ping() chan interface{} {
+func (this *Node) handleBuiltin(packet Packet) {
+	switch packet.Header.Command {
+	case COMMAND_NOOP:
+	case COMMAND_PING:
+		this.OnPing(packet)
+	case COMMAND_FETCH:
+		this.OnFetch(packet)
+	case COMMAND_FETCH_NODES:
+		this.OnFetchNodes(packet)
+	case COMMAND_BROADCAST:
+		this.OnBroadcast(packet)
+	case COMMAND_STORE:
+		this.OnStore(packet)
+	case COMMAND_CUSTOM:
+		this.OnCustom(packet)
+	case COMMAND_DELETE:
+		this.OnDelete(packet)
+	case COMMAND_ADD_PROVIDER:
+		this.OnAddProvider(packet)
+	case COMMAND_GET_PROVIDERS:
+		this.OnGetProviders(packet)
+	case COMMAND_PEX:
+		this.OnPex(packet)
+	case COMMAND_HOLEPUNCH:
+		this.OnHolePunch(packet)
+	case COMMAND_HOLEPUNCH_INTENT:
+		this.OnHolePunchIntent(packet)
+	case COMMAND_RELAY:
+		this.OnRelay(packet)
+	case COMMAND_RELAYED:
+		this.OnRelayed(packet)
+	case COMMAND_PUBLISH:
+		this.OnPublish(packet)
+	case COMMAND_HELLO:
+		this.OnHello(packet)
+	case COMMAND_FETCH_STREAM:
+		this.OnFetchStream(packet)
+	case COMMAND_FETCH_CHUNK:
+		this.OnFetchChunk(packet)
+	case COMMAND_RPC:
+		this.OnCall(packet)
+	case COMMAND_SESSION:
+		this.OnSessionMsg(packet)
+	case COMMAND_REGISTER:
+		this.OnRegister(packet)
+	case COMMAND_FETCH_RECORD:
+		this.OnFetchRecord(packet)
+	default:
+		this.dht.logger.Error(this, "x query: UNKNOWN COMMAND", packet.Header.Command)
+	}
+}
+
+func (this *Node) Ping() error {
 	this.dht.logger.Debug(this, "< PING")
 
-	return this.send(this.newPacket(COMMAND_PING, []byte{}, nil))
+	_, err := this.request(this.newPacket(COMMAND_PING, []byte{}, nil))
+
+	return err
 }
 
 func (this *Node) OnPing(packet Packet) {
@@ -197,53 +418,82 @@ func (this *Node) OnPing(packet Packet) {
 	this.Pong(packet.Header.MessageHash)
 }
 
-func (this *Node) Pong(responseTo []byte) chan interface{} {
+func (this *Node) Pong(responseTo []byte) {
 	this.dht.logger.Debug(this, "< PONG")
 
 	data := this.newPacket(COMMAND_PONG, responseTo, nil)
 
-	return this.send(data)
+	this.send(data)
 }
 
 func (this *Node) OnPong(packet Packet, cb CallbackChan) {
 	this.dht.logger.Debug(this, "> PONG")
 
-	cb.c <- nil
+	cb.c <- packet
 }
 
-func (this *Node) Fetch(hash []byte) chan interface{} {
+// Fetch returns the value if the node has it, or the closest nodes it knows
+func (this *Node) Fetch(hash []byte) (QueryResult, error) {
 	this.dht.logger.Debug(this, "< FETCH", hex.EncodeToString(hash)[:16])
 
-	data := this.newPacket(COMMAND_FETCH, []byte{}, hash)
+	res, err := this.request(this.newPacket(COMMAND_FETCH, []byte{}, hash))
+
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	if res.Header.Command == COMMAND_FOUND {
+		return QueryResult{Value: res.Data, Found: true}, nil
+	}
+
+	nodes, ok := res.Data.([]PacketContact)
+
+	if !ok {
+		return QueryResult{}, this.newError(ErrInvalidResponse, nil)
+	}
 
-	return this.send(data)
+	return QueryResult{Nodes: nodes}, nil
 }
 
 func (this *Node) OnFetch(packet Packet) {
-	this.dht.logger.Debug(this, "> FETCH", hex.EncodeToString(packet.Data.([]byte))[:16])
+	hash, _ := packet.Data.([]byte)
+
+	this.dht.logger.Debug(this, "> FETCH", hex.EncodeToString(hash))
 
-	val, ok := this.dht.store[hex.EncodeToString(packet.Data.([]byte))]
+	inst, ok := this.dht.getLocal(hex.EncodeToString(hash))
 
 	if ok {
-		this.Found(packet, val)
+		this.Found(packet, inst.Data)
 		return
 	}
 
 	this.OnFetchNodes(packet)
 }
 
-func (this *Node) FetchNodes(hash []byte) chan interface{} {
+func (this *Node) FetchNodes(hash []byte) ([]PacketContact, error) {
 	this.dht.logger.Debug(this, "< FETCH NODES", hex.EncodeToString(hash)[:16])
 
-	data := this.newPacket(COMMAND_FETCH_NODES, []byte{}, hash)
+	res, err := this.request(this.newPacket(COMMAND_FETCH_NODES, []byte{}, hash))
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, ok := res.Data.([]PacketContact)
+
+	if !ok {
+		return nil, this.newError(ErrInvalidResponse, nil)
+	}
 
-	return this.send(data)
+	return nodes, nil
 }
 
 func (this *Node) OnFetchNodes(packet Packet) {
-	this.dht.logger.Debug(this, "> FETCH NODES", hex.EncodeToString(packet.Data.([]byte))[:16])
+	hash, _ := packet.Data.([]byte)
 
-	bucket := this.dht.routing.FindNode(packet.Data.([]byte))
+	this.dht.logger.Debug(this, "> FETCH NODES", hex.EncodeToString(hash))
+
+	bucket := this.dht.routing.FindNode(hash)
 
 	var nodesContact []PacketContact
 
@@ -263,7 +513,9 @@ func (this *Node) FoundNodes(packet Packet, nodesContact []PacketContact) {
 }
 
 func (this *Node) OnFoundNodes(packet Packet, done CallbackChan) {
-	this.dht.logger.Debug(this, "> FOUND NODES", len(packet.Data.([]PacketContact)))
+	nodes, _ := packet.Data.([]PacketContact)
+
+	this.dht.logger.Debug(this, "> FOUND NODES", len(nodes))
 
 	done.c <- packet
 }
@@ -282,29 +534,123 @@ func (this *Node) OnFound(packet Packet, done CallbackChan) {
 	done.c <- packet
 }
 
-func (this *Node) Store(hash []byte, value interface{}) chan interface{} {
-	this.dht.logger.Debug(this, "< STORE", hex.EncodeToString(hash)[:16], value)
+// Store returns whether the node has stored the value. A refused mutable
+// record is returned as a StoreConflict error
+func (this *Node) Store(inst StoreInst) (bool, error) {
+	this.dht.logger.Debug(this, "< STORE", hex.EncodeToString(inst.Hash)[:16], inst.Data)
+
+	res, err := this.request(this.newPacket(COMMAND_STORE, []byte{}, inst))
+
+	if err != nil {
+		return false, err
+	}
 
-	data := this.newPacket(COMMAND_STORE, []byte{}, StoreInst{Hash: hash, Data: value})
+	switch data := res.Data.(type) {
+	case bool:
+		return data, nil
+	case StoreConflict:
+		return false, data
+	case QuotaExceeded:
+		return false, data
+	}
 
-	return this.send(data)
+	return false, this.newError(ErrInvalidResponse, nil)
 }
 
 func (this *Node) OnStore(packet Packet) {
-	this.dht.logger.Debug(this, "> STORE", packet.Data.(StoreInst).Hash, packet.Data.(StoreInst).Data)
+	inst, _ := packet.Data.(StoreInst)
+
+	this.dht.logger.Debug(this, "> STORE", hex.EncodeToString(inst.Hash), inst.Data)
+
+	if inst.Expired() || !this.dht.capable(CAP_STORE) {
+		this.Stored(packet, false)
+		return
+	}
+
+	// checked before the value is merged or its expiration bounded
+	signed := this.dht.authenticPublisher(inst) && this.dht.knownPublisher(inst)
+
+	if err := this.dht.validate(inst.Hash, inst.Data); err != nil {
+		this.dht.logger.Debug(this, "x STORE", err)
+		this.Stored(packet, false)
+		return
+	}
+
+	// the records of a key are checked by checkNamespace instead
+	if len(inst.Key) == 0 {
+		if err := this.dht.checkContent(inst.Hash, inst.Data); err != nil {
+			this.dht.logger.Warning(this, "x STORE", err)
+			this.Stored(packet, false)
+			return
+		}
+	}
+
+	inst, err := this.dht.checkNamespace(inst)
+
+	if err != nil {
+		this.dht.logger.Debug(this, "x STORE", inst.Key, err)
+		this.Stored(packet, false)
+		return
+	}
+
+	// the value is charged to the peer storing it, unless it replicates the
+	// value of a known peer that signed it, charged to that peer instead
+	if !signed && compare(inst.Publisher, this.contact.Hash) != 0 {
+		inst.Publisher = this.contact.Hash
+		inst.PublisherKey = nil
+		inst.PublisherSig = nil
+	}
 
 	this.dht.Lock()
-	_, ok := this.dht.store[hex.EncodeToString(packet.Data.(StoreInst).Hash)]
+	existing, ok := this.dht.store.Get(hex.EncodeToString(inst.Hash))
+
+	// a merged CRDT stays charged to the publisher of the stored one
+	inst = mergeStored(inst, existing, ok && !existing.Expired())
 
-	if ok || !this.dht.onStore(packet) {
+	err = this.dht.acceptStore(inst, existing, ok && !existing.Expired())
+
+	if err == errAlreadyExists && this.dht.selectNew(inst, existing) {
+		err = nil
+	}
+
+	if err == nil {
+		err = this.dht.checkQuota(inst, existing, ok && !existing.Expired())
+	}
+
+	if err != nil {
 		this.dht.Unlock()
+
+		switch err.(type) {
+		case StoreConflict, QuotaExceeded:
+			this.dht.logger.Debug(this, "< STORED", err)
+			this.send(this.newPacket(COMMAND_STORED, packet.Header.MessageHash, err))
+			return
+		}
+
 		this.Stored(packet, false)
 		return
 	}
 
-	this.dht.store[hex.EncodeToString(packet.Data.(StoreInst).Hash)] = packet.Data.(StoreInst).Data
+	err = this.dht.store.Set(hex.EncodeToString(inst.Hash), inst)
+
+	if err == nil {
+		if ok {
+			this.dht.trackUsage(existing, true)
+		}
+
+		this.dht.trackUsage(inst, false)
+	}
+
 	this.dht.Unlock()
 
+	if err != nil {
+		this.dht.logger.Error(this, "x STORE", err)
+		this.Stored(packet, false)
+		return
+	}
+
+	this.dht.emit(EVENT_VALUE_STORED, this.contact, inst.Hash)
+
 	this.Stored(packet, true)
 }
 
@@ -317,17 +663,80 @@ func (this *Node) Stored(packet Packet, hasStored bool) {
 }
 
 func (this *Node) OnStored(packet Packet, done CallbackChan) {
-	this.dht.logger.Debug(this, "> STORED", packet.Data.(bool))
+	this.dht.logger.Debug(this, "> STORED", packet.Data)
 
 	done.c <- packet
 }
 
-func (this *Node) Custom(value interface{}) chan interface{} {
+func (this *Node) Delete(inst DeleteInst) (bool, error) {
+	this.dht.logger.Debug(this, "< DELETE", hex.EncodeToString(inst.Hash)[:16])
+
+	res, err := this.request(this.newPacket(COMMAND_DELETE, []byte{}, inst))
+
+	if err != nil {
+		return false, err
+	}
+
+	deleted, ok := res.Data.(bool)
+
+	if !ok {
+		return false, this.newError(ErrInvalidResponse, nil)
+	}
+
+	return deleted, nil
+}
+
+func (this *Node) OnDelete(packet Packet) {
+	inst, _ := packet.Data.(DeleteInst)
+
+	this.dht.logger.Debug(this, "> DELETE", hex.EncodeToString(inst.Hash))
+
+	key := hex.EncodeToString(inst.Hash)
+
+	this.dht.Lock()
+	existing, ok := this.dht.store.Get(key)
+
+	if !ok || len(existing.DeleteToken) == 0 || compare(this.dht.NewHash(inst.Key), existing.DeleteToken) != 0 {
+		this.dht.Unlock()
+		this.Deleted(packet, false)
+		return
+	}
+
+	err := this.dht.store.Delete(key)
+
+	if err == nil {
+		this.dht.trackUsage(existing, true)
+	}
+
+	this.dht.Unlock()
+
+	this.Deleted(packet, err == nil)
+}
+
+func (this *Node) Deleted(packet Packet, hasDeleted bool) {
+	this.dht.logger.Debug(this, "< DELETED", hasDeleted)
+
+	data := this.newPacket(COMMAND_DELETED, packet.Header.MessageHash, hasDeleted)
+
+	this.send(data)
+}
+
+func (this *Node) OnDeleted(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> DELETED", packet.Data)
+
+	done.c <- packet
+}
+
+func (this *Node) Custom(value interface{}) (interface{}, error) {
 	this.dht.logger.Debug(this, "< CUSTOM")
 
-	data := this.newPacket(COMMAND_CUSTOM, []byte{}, value)
+	res, err := this.request(this.newPacket(COMMAND_CUSTOM, []byte{}, value))
 
-	return this.send(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Data, nil
 }
 
 func (this *Node) OnCustom(packet Packet) {
@@ -350,102 +759,275 @@ func (this *Node) OnCustomAnswer(packet Packet, done CallbackChan) {
 	done.c <- packet
 }
 
-func (this *Node) Broadcast(packet Packet) chan interface{} {
-	if !this.dht.hasBroadcast(packet.Header.MessageHash) {
-		this.dht.gotBroadcast = append(this.dht.gotBroadcast, packet.Header.MessageHash)
-	}
+func (this *Node) Broadcast(packet Packet) {
+	// forwarded broadcasts are signed by the forwarder
+	packet.Header.Sender = this.dht.contact()
+	packet.Header.PublicKey = this.dht.publicKey
+	packet.Header.Seq = this.dht.nextSeq()
 
 	this.dht.logger.Debug(this, "< BROADCAST")
 	// data := this.newPacket(COMMAND_BROADCAST, "", value)
 
-	return this.send(packet)
+	this.send(packet)
 }
 
 func (this *Node) OnBroadcast(packet Packet) {
-	if this.dht.hasBroadcast(packet.Header.MessageHash) {
+	// a retried reliable broadcast is acknowledged again
+	if packet.Header.Ack {
+		this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, nil))
+	}
+
+	if !this.dht.markBroadcast(broadcastId(packet)) {
 		return
 	}
 
-	this.dht.gotBroadcast = append(this.dht.gotBroadcast, packet.Header.MessageHash)
+	this.dht.logger.Debug(this, "> BROADCAST", LogField{"ttl", packet.Header.TTL})
+
+	if packet.Header.TTL > 1 {
+		packet.Header.TTL--
+		packet.Header.Ack = false
 
-	this.dht.logger.Debug(this, "> BROADCAST")
+		this.dht.forwardBroadcast(packet, this.contact.Hash)
+	}
 
-	this.dht.Broadcast(packet)
 	this.dht.onBroadcast(packet)
 
 	// this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, nil))
 }
 
-func (this *Node) send(packet Packet) chan interface{} {
+// request sends the packet and waits for the answer. On timeout it is sent
+// again with an exponential backoff, and the node is disconnected once all
+// the retries failed. It fails at once while the circuit of the node is open
+func (this *Node) request(packet Packet) (_ Packet, err error) {
+	span := this.dht.startSpan(this.span, spanName(packet.Header.Command), SPAN_CLIENT)
+	defer func() { span.End(err) }()
+
+	key := this.breakerKey()
+	ctx := this.context()
+
+	if err := ctx.Err(); err != nil {
+		return Packet{}, err
+	}
+
+	if !this.dht.breakers.allow(key) {
+		return Packet{}, this.newError(ErrCircuitOpen, nil)
+	}
+
+	backoff := this.dht.options.RpcBackoff
+
+	for retry := 0; ; retry++ {
+		packet.Header.TraceParent = span.TraceParent()
+
+		timeout := this.rpcTimeout()
+
+		var answer interface{}
+
+		// an ended context drops the pending call, its answer being ignored
+		select {
+		case answer = <-this.send(packet):
+		case <-ctx.Done():
+			if cb, ok := this.dht.calls.take(packet.Header.MessageHash); ok {
+				cb.timer.Stop()
+			}
+
+			this.dht.breakers.release(key)
+
+			return Packet{}, ctx.Err()
+		}
+
+		switch res := answer.(type) {
+		case Packet:
+			this.dht.breakers.success(key)
+
+			return res, nil
+		case error:
+			err = res
+		default:
+			this.dht.breakers.release(key)
+
+			return Packet{}, this.newError(ErrInvalidResponse, nil)
+		}
+
+		if !errors.Is(err, ErrTimeout) {
+			this.dht.breakers.release(key)
+
+			return Packet{}, err
+		}
+
+		if this.dht.stopping {
+			this.disconnect()
+
+			return Packet{}, err
+		}
+
+		// an adaptive timeout is sent again at once with the backed off RTO,
+		// and only counts as a retry once it reaches RpcTimeout
+		if timeout < this.dht.options.RpcTimeout && this.timeout == 0 {
+			retry--
+		} else if retry >= this.rpcRetries() {
+			this.disconnect()
+
+			return Packet{}, err
+		} else {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				this.dht.breakers.release(key)
+
+				return Packet{}, ctx.Err()
+			}
+
+			backoff *= 2
+		}
+
+		// a fresh packet, as the same one would be dropped as a replay
+		packet = this.dht.refreshPacket(packet)
+	}
+}
+
+func (this *Node) write(packet Packet) chan interface{} {
 	// this.Lock()
 	// defer this.Unlock()
 
 	// blob, err := msgpack.Marshal(&packet)
-	var blob bytes.Buffer
-	enc := gob.NewEncoder(&blob)
+	if this.needsHandshake(packet) {
+		return this.afterHandshake(packet)
+	}
 
-	err := enc.Encode(packet)
+	wire, err := compressPacket(packet, this.dht.compressThreshold(this.contact.Hash))
 
-	res := make(chan interface{})
+	if err == nil && this.dht.options.Encrypt {
+		wire, err = this.encryptPacket(wire)
+	}
+
+	wire.Header.Signature = nil
+
+	blob := getBuffer()
+	defer putBuffer(blob)
+
+	if err == nil {
+		err = gob.NewEncoder(blob).Encode(wire)
+	}
+
+	// buffered, so that whoever delivers the result never waits for the
+	// caller to read it
+	res := make(chan interface{}, 1)
 
 	if err != nil {
-		res <- errors.New("Error Encode" + err.Error())
+		res <- this.newError(ErrEncode, err)
 
 		return res
 	}
 
-	timer := time.NewTimer(time.Second * 5)
+	key := hex.EncodeToString(packet.Header.MessageHash)
+	expectAnswer := expectsAnswer(packet)
+
+	if expectAnswer {
+		this.dht.calls.add(packet.Header.MessageHash, CallbackChan{
+			timer: newRpcTimer(this.rpcTimeout(), func() { this.timedOut(packet, key, res) }),
+			sent:  time.Now(),
+			peer:  this.breakerKey(),
+			hash:  this.contact.Hash,
+			addrs: this.targetAddrs(),
+			c:     res,
+		})
+	}
+
+	signed := this.dht.sign(blob.Bytes())
 
-	this.dht.Lock()
-	this.dht.commandQueue[hex.EncodeToString(packet.Header.MessageHash)] = CallbackChan{
-		timer: timer,
-		c:     res,
+	if window := this.batchWindow(); window > 0 && len(signed) < FRAGMENT_SIZE {
+		this.writeBatched(signed, window)
+		this.dht.counters.countSent(packet.Header.Command)
+
+		return res
 	}
-	this.dht.Unlock()
 
-	_, err = this.dht.server.WriteTo(blob.Bytes(), this.addr)
+	datagrams, err := fragmentBlob(packet.Header.MessageHash, signed, this.dht.magic)
+
+	outbound := outboundPacket{
+		node:         this,
+		packet:       packet,
+		datagrams:    datagrams,
+		expectAnswer: expectAnswer,
+		res:          res,
+	}
 
 	if err != nil {
-		res <- errors.New("Error Writing" + err.Error())
+		outbound.fail(err)
 
 		return res
 	}
 
-	go func() {
-		<-timer.C
+	this.dht.enqueueOutbound(outbound)
 
-		this.dht.Lock()
-		delete(this.dht.commandQueue, hex.EncodeToString(packet.Header.MessageHash))
-		this.dht.Unlock()
+	return res
+}
 
-		var err string
+// targetAddrs are the addresses an answer can come from: the one the request
+// is written to, and the others known for the node before sending
+func (this *Node) targetAddrs() []string {
+	res := contactAddrs(this.contact)
 
-		if len(this.contact.Hash) > 0 {
-			err = hex.EncodeToString(this.contact.Hash[:16]) + " Timeout"
-		} else {
-			err = this.contact.Addr + " Timeout"
-		}
+	if this.addr != nil {
+		res = append(res, this.addr.String())
+	}
 
-		res <- errors.New(err)
+	return res
+}
 
-		// close(res)
+func (this *Node) timedOut(packet Packet, key string, res chan interface{}) {
+	this.dht.calls.take(packet.Header.MessageHash)
 
-		this.disconnect()
-	}()
+	this.dht.logger.Debug(this, "x TIMEOUT",
+		LogField{"command", packet.Header.Command},
+		LogField{"message", key},
+	)
 
-	this.dht.Lock()
-	defer this.dht.Unlock()
-	return this.dht.commandQueue[hex.EncodeToString(packet.Header.MessageHash)].c
+	this.dht.counters.countTimeout()
+	this.dht.routing.backoffRTO(this.contact.Hash)
+	this.dht.emit(EVENT_TIMEOUT, this.contact, packet.Header.MessageHash)
+
+	res <- this.newError(ErrTimeout, nil)
 }
 
+// expectsAnswer is false for the answers themselves and for the one-way
+// commands
+func expectsAnswer(packet Packet) bool {
+	if len(packet.Header.ResponseTo) > 0 {
+		return false
+	}
+
+	switch packet.Header.Command {
+	case COMMAND_BROADCAST:
+		return packet.Header.Ack
+	case COMMAND_HOLEPUNCH_INTENT, COMMAND_RELAY, COMMAND_RELAYED:
+		return false
+	}
+
+	return true
+}
+
+// disconnect counts a failed request. The node is only removed from the
+// routing table once its circuit opens, its failures being remembered
 func (this *Node) disconnect() {
-	this.dht.Lock()
-	defer this.dht.Unlock()
+	cooldown, opened := this.dht.breakers.failure(this.breakerKey(), this.dht.options.BreakerThreshold, this.dht.options.BreakerCooldown)
 
+	if !opened {
+		return
+	}
+
+	this.dht.logger.Debug(this, "x CIRCUIT OPEN", LogField{"cooldown", cooldown})
+
+	this.dht.Lock()
 	this.dht.routing.RemoveNode(this.contact)
+	delete(this.dht.relays, hex.EncodeToString(this.contact.Hash))
+	this.dht.Unlock()
 
-	for _, res := range this.dht.commandQueue {
-		res.timer.Stop()
-		// close(res.c)
+	// the other requests to the node fail too, instead of waiting for their
+	// own timeout
+	for _, cb := range this.dht.calls.takePeer(this.breakerKey()) {
+		if cb.timer.Stop() {
+			cb.c <- this.newError(ErrCircuitOpen, nil)
+		}
 	}
 }
@@ -0,0 +1,92 @@
+package dht
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	OBSERVED_QUORUM = 3
+	OBSERVED_MAX    = 32
+	OBSERVED_TTL    = time.Hour
+)
+
+// observedVote is the address a subnet last saw our requests coming from
+type observedVote struct {
+	addr string
+	at   time.Time
+}
+
+// subnetKey is the /24 of an IPv4 source, or the /64 of an IPv6 one, so that
+// the peers of a single network only count as one observer. Loopback and
+// non-IP sources are kept as is
+func subnetKey(source string) string {
+	host, _, err := net.SplitHostPort(source)
+
+	if err != nil {
+		host = source
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || ip.IsLoopback() {
+		return source
+	}
+
+	bits := 128
+	ones := 64
+
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+		ones = 24
+	}
+
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}).String()
+}
+
+// observeAddr records the address a peer saw our request coming from, one
+// vote per subnet of the peers' source addresses. Once enough distinct
+// subnets agree on an address, it becomes our advertised one. When
+// OBSERVED_MAX subnets voted within OBSERVED_TTL, new ones are not counted
+func (this *Dht) observeAddr(source string, addr string) {
+	if len(addr) == 0 || len(source) == 0 {
+		return
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return
+	}
+
+	key := subnetKey(source)
+	now := time.Now()
+
+	this.Lock()
+
+	if _, ok := this.observed[key]; !ok && len(this.observed) >= OBSERVED_MAX {
+		for k, vote := range this.observed {
+			if now.Sub(vote.at) > OBSERVED_TTL {
+				delete(this.observed, k)
+			}
+		}
+	}
+
+	if _, ok := this.observed[key]; ok || len(this.observed) < OBSERVED_MAX {
+		this.observed[key] = observedVote{addr: addr, at: now}
+	}
+
+	votes := 0
+
+	for _, vote := range this.observed {
+		if vote.addr == addr && now.Sub(vote.at) <= OBSERVED_TTL {
+			votes++
+		}
+	}
+
+	current := this.externalAddr
+	this.Unlock()
+
+	if votes >= this.options.ObservedQuorum && addr != current {
+		this.setExternalAddr(addr)
+	}
+}
@@ -0,0 +1,379 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option sets a field of DhtOptions for NewWithOptions, failing on a value
+// that can never work
+type Option func(*DhtOptions) error
+
+// NewWithOptions makes a node from the options applied in order over the
+// defaults, and checks the result with Validate
+func NewWithOptions(opts ...Option) (*Dht, error) {
+	options := DhtOptions{}
+
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	return New(options), nil
+}
+
+// WithOptions starts from a whole DhtOptions, for the fields without an
+// Option of their own
+func WithOptions(base DhtOptions) Option {
+	return func(options *DhtOptions) error {
+		*options = base
+
+		return nil
+	}
+}
+
+func WithListenAddr(addrs ...string) Option {
+	return func(options *DhtOptions) error {
+		if len(addrs) == 0 {
+			return errors.New("WithListenAddr: No address")
+		}
+
+		for _, addr := range addrs {
+			if err := checkAddr(addr); err != nil {
+				return fmt.Errorf("WithListenAddr: %v", err)
+			}
+		}
+
+		options.ListenAddr = addrs[0]
+		options.ListenAddrs = addrs[1:]
+
+		return nil
+	}
+}
+
+// WithBootstrap sets the bootstrap nodes, addresses or DNS seeds
+func WithBootstrap(addrs ...string) Option {
+	return func(options *DhtOptions) error {
+		for _, addr := range addrs {
+			if err := checkBootstrap(addr); err != nil {
+				return fmt.Errorf("WithBootstrap: %v", err)
+			}
+		}
+
+		options.BootstrapAddr = addrs
+
+		return nil
+	}
+}
+
+func WithK(k int) Option {
+	return func(options *DhtOptions) error {
+		if k <= 0 {
+			return fmt.Errorf("WithK: K must be positive, got %d", k)
+		}
+
+		options.K = k
+
+		return nil
+	}
+}
+
+func WithAlpha(alpha int) Option {
+	return func(options *DhtOptions) error {
+		if alpha <= 0 {
+			return fmt.Errorf("WithAlpha: Alpha must be positive, got %d", alpha)
+		}
+
+		options.Alpha = alpha
+
+		return nil
+	}
+}
+
+// WithCodec sets the codec of the payloads sent: CODEC_SNAPPY compresses
+// the ones above CompressThreshold, CODEC_GOB never does
+func WithCodec(codec string) Option {
+	return func(options *DhtOptions) error {
+		switch codec {
+		case CODEC_GOB:
+			options.CompressThreshold = -1
+		case CODEC_SNAPPY:
+			if options.CompressThreshold < 0 {
+				options.CompressThreshold = 0
+			}
+		default:
+			return fmt.Errorf("WithCodec: Unknown codec %q, expected one of %v", codec, codecs)
+		}
+
+		return nil
+	}
+}
+
+func WithTransport(transport Transport) Option {
+	return func(options *DhtOptions) error {
+		options.Transport = transport
+
+		return nil
+	}
+}
+
+func WithStorage(storage Storage) Option {
+	return func(options *DhtOptions) error {
+		options.Storage = storage
+
+		return nil
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(options *DhtOptions) error {
+		options.Logger = logger
+
+		return nil
+	}
+}
+
+func WithNetworkId(id string) Option {
+	return func(options *DhtOptions) error {
+		options.NetworkId = id
+
+		return nil
+	}
+}
+
+// WithHash sets the hash function of the keys and node IDs, and the length
+// of their hash
+func WithHash(hasher Hasher, bits int) Option {
+	return func(options *DhtOptions) error {
+		options.Hasher = hasher
+		options.IdBits = bits
+
+		if err := checkHashOptions(*options); err != nil {
+			return fmt.Errorf("WithHash: %v", err)
+		}
+
+		return nil
+	}
+}
+
+// WithIdentity keeps the identity of the node at path, sealed with pass
+// when not empty
+func WithIdentity(path string, pass string) Option {
+	return func(options *DhtOptions) error {
+		if len(path) == 0 {
+			return errors.New("WithIdentity: Empty path")
+		}
+
+		options.IdentityPath = path
+		options.IdentityPass = pass
+
+		return nil
+	}
+}
+
+func WithRpcTimeout(timeout time.Duration, retries int) Option {
+	return func(options *DhtOptions) error {
+		if timeout <= 0 || retries < 0 {
+			return fmt.Errorf("WithRpcTimeout: Invalid timeout %v or retries %d", timeout, retries)
+		}
+
+		options.RpcTimeout = timeout
+		options.RpcRetries = retries
+
+		return nil
+	}
+}
+
+// Validate checks the options New would otherwise only fail on at Start,
+// or later. A zero value is fine, as it stands for the default
+func (this DhtOptions) Validate() error {
+	if len(this.ListenAddr) == 0 {
+		return errors.New("Missing ListenAddr")
+	}
+
+	if err := checkAddr(this.ListenAddr); err != nil {
+		return fmt.Errorf("ListenAddr: %v", err)
+	}
+
+	for _, addr := range this.ListenAddrs {
+		if err := checkAddr(addr); err != nil {
+			return fmt.Errorf("ListenAddrs: %v", err)
+		}
+	}
+
+	for _, addr := range this.BootstrapAddr {
+		if err := checkBootstrap(addr); err != nil {
+			return fmt.Errorf("BootstrapAddr: %v", err)
+		}
+	}
+
+	if len(this.HttpAddr) > 0 {
+		if err := checkAddr(this.HttpAddr); err != nil {
+			return fmt.Errorf("HttpAddr: %v", err)
+		}
+
+		// the admin operations are not authenticated
+		if this.HttpAdmin && !isLoopback(this.HttpAddr) {
+			return fmt.Errorf("HttpAdmin: HttpAddr %q is not a loopback address", this.HttpAddr)
+		}
+	}
+
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"K", this.K},
+		{"Alpha", this.Alpha},
+		{"DisjointPaths", this.DisjointPaths},
+		{"LookupQuorum", this.LookupQuorum},
+		{"ErasureShards", this.ErasureShards},
+		{"ErasureData", this.ErasureData},
+		{"IdDifficulty", this.IdDifficulty},
+		{"RpcRetries", this.RpcRetries},
+		{"Workers", this.Workers},
+	}
+
+	for _, field := range counts {
+		if field.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", field.name, field.value)
+		}
+	}
+
+	if err := checkDurations(this); err != nil {
+		return err
+	}
+
+	if this.ErasureData > this.ErasureShards {
+		return fmt.Errorf("ErasureData %d is above ErasureShards %d", this.ErasureData, this.ErasureShards)
+	}
+
+	if this.DisjointPaths > 1 && this.LookupQuorum > this.DisjointPaths {
+		return fmt.Errorf("LookupQuorum %d is above DisjointPaths %d", this.LookupQuorum, this.DisjointPaths)
+	}
+
+	if this.ChunkThreshold > 0 && this.MaxValueSize > 0 && this.ChunkThreshold > this.MaxValueSize {
+		return fmt.Errorf("ChunkThreshold %d is above MaxValueSize %d", this.ChunkThreshold, this.MaxValueSize)
+	}
+
+	if len(this.IdentityPass) > 0 && len(this.IdentityPath) == 0 {
+		return errors.New("IdentityPass without IdentityPath")
+	}
+
+	return checkHashOptions(this)
+}
+
+// checkDurations rejects the negative intervals and timeouts, a ticker or
+// timer panicking on them. A zero one is replaced by its default in New
+func checkDurations(options DhtOptions) error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"ReplicateInterval", options.ReplicateInterval},
+		{"RepublishInterval", options.RepublishInterval},
+		{"RefreshInterval", options.RefreshInterval},
+		{"KeepaliveInterval", options.KeepaliveInterval},
+		{"PeerCacheInterval", options.PeerCacheInterval},
+		{"PexInterval", options.PexInterval},
+		{"BanDuration", options.BanDuration},
+		{"CacheTTL", options.CacheTTL},
+		{"ReadCacheTTL", options.ReadCacheTTL},
+		{"ClockSkew", options.ClockSkew},
+		{"RpcTimeout", options.RpcTimeout},
+		{"MinRpcTimeout", options.MinRpcTimeout},
+		{"RpcBackoff", options.RpcBackoff},
+		{"BreakerCooldown", options.BreakerCooldown},
+		{"BatchWindow", options.BatchWindow},
+	}
+
+	for _, field := range durations {
+		if field.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %v", field.name, field.value)
+		}
+	}
+
+	if options.RpcTimeout > 0 && options.MinRpcTimeout > options.RpcTimeout {
+		return fmt.Errorf("MinRpcTimeout %v is above RpcTimeout %v", options.MinRpcTimeout, options.RpcTimeout)
+	}
+
+	return nil
+}
+
+func checkHashOptions(options DhtOptions) error {
+	size := HASH_SIZE * 2
+
+	if options.Hasher != nil {
+		size = options.Hasher().Size() * 8
+	}
+
+	if size < MIN_HASH_SIZE {
+		return fmt.Errorf("The hash function makes %d bits, below the %d of an ID", size, MIN_HASH_SIZE)
+	}
+
+	if options.IdBits == 0 {
+		return nil
+	}
+
+	if options.IdBits%8 != 0 || options.IdBits < MIN_HASH_SIZE {
+		return fmt.Errorf("IdBits must be a multiple of 8 from %d, got %d", MIN_HASH_SIZE, options.IdBits)
+	}
+
+	if options.IdBits > size {
+		return fmt.Errorf("IdBits %d is above the %d bits of the hash function", options.IdBits, size)
+	}
+
+	return nil
+}
+
+// checkAddr checks a host:port address, without resolving the host
+func checkAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("Invalid port %q in address %q", port, addr)
+	}
+
+	return nil
+}
+
+// isLoopback is false for the wildcard addresses, an empty host included
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func checkBootstrap(addr string) error {
+	if strings.HasPrefix(addr, DNS_SEED_PREFIX) {
+		if len(strings.TrimPrefix(addr, DNS_SEED_PREFIX)) == 0 {
+			return fmt.Errorf("Empty DNS seed %q", addr)
+		}
+
+		return nil
+	}
+
+	return checkAddr(addr)
+}
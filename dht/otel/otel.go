@@ -0,0 +1,75 @@
+package otel
+
+import (
+	"context"
+
+	"github.com/champii/go-dht/dht"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACEPARENT = "traceparent"
+)
+
+type tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TraceContext
+}
+
+// NewTracer returns a dht.Tracer creating its spans with an OpenTelemetry
+// tracer, and propagating their context as W3C traceparent strings
+func NewTracer(t trace.Tracer) dht.Tracer {
+	return tracer{tracer: t}
+}
+
+func (this tracer) Start(parent string, name string, kind dht.SpanKind) dht.Span {
+	ctx := context.Background()
+
+	if parent != "" {
+		ctx = this.propagator.Extract(ctx, propagation.MapCarrier{TRACEPARENT: parent})
+	}
+
+	ctx, span := this.tracer.Start(ctx, name, trace.WithSpanKind(spanKind(kind)))
+
+	return otelSpan{
+		ctx:        ctx,
+		span:       span,
+		propagator: this.propagator,
+	}
+}
+
+type otelSpan struct {
+	ctx        context.Context
+	span       trace.Span
+	propagator propagation.TraceContext
+}
+
+func (this otelSpan) TraceParent() string {
+	carrier := propagation.MapCarrier{}
+
+	this.propagator.Inject(this.ctx, carrier)
+
+	return carrier.Get(TRACEPARENT)
+}
+
+func (this otelSpan) End(err error) {
+	if err != nil {
+		this.span.RecordError(err)
+		this.span.SetStatus(codes.Error, err.Error())
+	}
+
+	this.span.End()
+}
+
+func spanKind(kind dht.SpanKind) trace.SpanKind {
+	switch kind {
+	case dht.SPAN_CLIENT:
+		return trace.SpanKindClient
+	case dht.SPAN_SERVER:
+		return trace.SpanKindServer
+	default:
+		return trace.SpanKindInternal
+	}
+}
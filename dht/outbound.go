@@ -0,0 +1,235 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	OUTBOUND_QUEUE = 128
+	OUTBOUND_IDLE  = time.Second * 30
+)
+
+var errQueueFull = errors.New("Send queue full")
+
+// outboundPacket is a packet encoded and fragmented, waiting for the sender
+// of its peer to write it
+type outboundPacket struct {
+	node         *Node
+	packet       Packet
+	datagrams    []*bytes.Buffer
+	expectAnswer bool
+	res          chan interface{}
+}
+
+type peerQueue struct {
+	packets chan outboundPacket
+	blocked int
+}
+
+// outboundQueues holds a bounded queue per peer, each written by its own
+// sender, so that a slow or unreachable peer never holds the others back
+type outboundQueues struct {
+	sync.Mutex
+	peers map[string]*peerQueue
+	done  chan struct{}
+}
+
+func (this *Dht) startOutbound() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.outbound = &outboundQueues{
+		peers: make(map[string]*peerQueue),
+		done:  make(chan struct{}),
+	}
+}
+
+func (this *Dht) stopOutbound() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.outbound != nil {
+		close(this.outbound.done)
+		this.outbound = nil
+	}
+}
+
+// enqueueOutbound queues the packet for its peer, starting its sender if
+// idle. Without a running node, the packet is written at once
+func (this *Dht) enqueueOutbound(packet outboundPacket) {
+	this.RLock()
+	outbound := this.outbound
+	this.RUnlock()
+
+	if outbound == nil {
+		packet.write()
+
+		return
+	}
+
+	key := packet.node.addr.String()
+
+	outbound.Lock()
+
+	queue, ok := outbound.peers[key]
+
+	if !ok {
+		queue = &peerQueue{packets: make(chan outboundPacket, this.options.OutboundQueue)}
+		outbound.peers[key] = queue
+
+		go this.sender(outbound, key, queue)
+	}
+
+	select {
+	case queue.packets <- packet:
+		outbound.Unlock()
+
+		return
+	default:
+	}
+
+	switch this.options.OutboundPolicy {
+	case QUEUE_BLOCK:
+		// the sender stays until the blocked callers are done
+		queue.blocked++
+		outbound.Unlock()
+
+		select {
+		case queue.packets <- packet:
+		case <-outbound.done:
+			packet.drop(net.ErrClosed)
+		}
+
+		outbound.Lock()
+		queue.blocked--
+		outbound.Unlock()
+	case QUEUE_DROP_OLDEST:
+		dropped := []outboundPacket{}
+
+		for {
+			select {
+			case queue.packets <- packet:
+				outbound.Unlock()
+
+				for _, oldest := range dropped {
+					this.counters.countOutboundDropped()
+					oldest.drop(errQueueFull)
+				}
+
+				return
+			case oldest := <-queue.packets:
+				dropped = append(dropped, oldest)
+			}
+		}
+	default:
+		outbound.Unlock()
+
+		this.counters.countOutboundDropped()
+		packet.drop(errQueueFull)
+	}
+}
+
+// sender writes the packets of a peer in order, and leaves once idle
+func (this *Dht) sender(outbound *outboundQueues, key string, queue *peerQueue) {
+	idle := time.NewTimer(OUTBOUND_IDLE)
+	defer idle.Stop()
+
+	for {
+		select {
+		case packet := <-queue.packets:
+			packet.write()
+
+			idle.Reset(OUTBOUND_IDLE)
+		case <-idle.C:
+			outbound.Lock()
+
+			if len(queue.packets) > 0 || queue.blocked > 0 {
+				outbound.Unlock()
+				idle.Reset(OUTBOUND_IDLE)
+
+				continue
+			}
+
+			delete(outbound.peers, key)
+			outbound.Unlock()
+
+			return
+		case <-outbound.done:
+			for {
+				select {
+				case packet := <-queue.packets:
+					packet.drop(net.ErrClosed)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (this outboundPacket) write() {
+	var err error
+
+	for _, datagram := range this.datagrams {
+		if err == nil {
+			err = this.node.writeDatagram(datagram.Bytes())
+		}
+
+		putBuffer(datagram)
+	}
+
+	if err != nil {
+		this.fail(err)
+
+		return
+	}
+
+	this.node.dht.counters.countSent(this.packet.Header.Command)
+}
+
+// drop fails a packet never written
+func (this outboundPacket) drop(err error) {
+	for _, datagram := range this.datagrams {
+		putBuffer(datagram)
+	}
+
+	this.fail(err)
+}
+
+// fail gives the error to the caller, unless the call already got its
+// answer or timeout
+func (this outboundPacket) fail(err error) {
+	if this.expectAnswer {
+		if cb, ok := this.node.dht.calls.take(this.packet.Header.MessageHash); !ok || !cb.timer.Stop() {
+			return
+		}
+	}
+
+	this.res <- this.node.newError(ErrTransport, err)
+}
+
+// outboundLen is the number of packets waiting in the queues
+func (this *Dht) outboundLen() int {
+	this.RLock()
+	outbound := this.outbound
+	this.RUnlock()
+
+	if outbound == nil {
+		return 0
+	}
+
+	outbound.Lock()
+	defer outbound.Unlock()
+
+	res := 0
+
+	for _, queue := range outbound.peers {
+		res += len(queue.packets)
+	}
+
+	return res
+}
@@ -0,0 +1,156 @@
+package dht
+
+import (
+	"errors"
+)
+
+var (
+	errUnexpectedPayload = errors.New("Unexpected payload")
+)
+
+// ProtocolError answers a request whose payload the node could not make
+// sense of, instead of leaving the sender to time out
+type ProtocolError struct {
+	Command int
+	Reason  string
+}
+
+func (this ProtocolError) Error() string {
+	return "Invalid " + commandName(this.Command) + " request: " + this.Reason
+}
+
+// checkPayload checks that a request carries the type its command expects,
+// with hashes of the right length, so that no handler ever works on a
+// payload that is not there
+func (this *Dht) checkPayload(packet Packet) error {
+	switch packet.Header.Command {
+	case COMMAND_FETCH, COMMAND_FETCH_NODES, COMMAND_ADD_PROVIDER, COMMAND_GET_PROVIDERS, COMMAND_FETCH_STREAM, COMMAND_FETCH_RECORD:
+		hash, ok := packet.Data.([]byte)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(hash)
+	case COMMAND_STORE:
+		inst, ok := packet.Data.(StoreInst)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(inst.Hash)
+	case COMMAND_DELETE:
+		inst, ok := packet.Data.(DeleteInst)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(inst.Hash)
+	case COMMAND_FETCH_CHUNK:
+		inst, ok := packet.Data.(ChunkInst)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		if inst.Offset < 0 || inst.Length <= 0 || inst.Length > STREAM_CHUNK {
+			return errInvalidChunk
+		}
+
+		return this.checkHash(inst.Hash)
+	case COMMAND_HOLEPUNCH, COMMAND_HOLEPUNCH_INTENT:
+		inst, ok := packet.Data.(HolePunchInst)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(inst.Target.Hash)
+	case COMMAND_RELAY, COMMAND_RELAYED:
+		inst, ok := packet.Data.(RelayInst)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(inst.Target.Hash)
+	case COMMAND_PEX:
+		if _, ok := packet.Data.([]PacketContact); !ok && packet.Data != nil {
+			return errUnexpectedPayload
+		}
+	case COMMAND_PUBLISH:
+		inst, ok := packet.Data.(PublishInst)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(inst.Id)
+	case COMMAND_HELLO:
+		if _, ok := packet.Data.(HelloInst); !ok {
+			return errUnexpectedPayload
+		}
+	case COMMAND_SESSION:
+		msg, ok := packet.Data.(SessionMsg)
+
+		if !ok {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(msg.Id)
+	case COMMAND_REGISTER:
+		inst, ok := packet.Data.(RegisterInst)
+
+		if !ok || inst.Ttl <= 0 {
+			return errUnexpectedPayload
+		}
+
+		return this.checkHash(inst.Hash)
+	case COMMAND_RPC:
+		if _, ok := packet.Data.([]byte); !ok {
+			return errUnexpectedPayload
+		}
+
+		if len(packet.Header.Rpc) == 0 {
+			return errRpcName
+		}
+	}
+
+	return nil
+}
+
+func checkHash(hash []byte, size int) error {
+	if len(hash) != size {
+		return errInvalidHash
+	}
+
+	return nil
+}
+
+// Invalid answers a request that failed checkPayload
+func (this *Node) Invalid(packet Packet, err error) {
+	this.dht.logger.Debug(this, "< ERROR", err)
+
+	data := this.newPacket(COMMAND_ERROR, packet.Header.MessageHash, ProtocolError{
+		Command: packet.Header.Command,
+		Reason:  err.Error(),
+	})
+
+	this.send(data)
+}
+
+func (this *Node) OnError(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> ERROR", packet.Data)
+
+	inst, ok := packet.Data.(ProtocolError)
+
+	if !ok {
+		done.c <- this.newError(ErrInvalidResponse, nil)
+
+		return
+	}
+
+	done.c <- this.newError(ErrProtocol, inst)
+}
@@ -0,0 +1,126 @@
+package dht
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	PEER_CACHE_SIZE     = 32
+	PEER_CACHE_INTERVAL = time.Minute * 10
+)
+
+func (this *Dht) startPeerCache(done chan struct{}) {
+	timer := time.NewTicker(this.options.PeerCacheInterval)
+
+	go func() {
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				if this.running {
+					if err := this.savePeerCache(); err != nil {
+						this.logger.Warning("Cannot save peer cache", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// reliableContacts returns up to n contacts seen recently, the fastest ones
+// first
+func (this *Routing) reliableContacts(n int) []PacketContact {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []PacketContact{}
+
+	for _, bucket := range this.buckets {
+		for _, contact := range bucket {
+			if time.Since(this.lastSeen[hex.EncodeToString(contact.Hash)]) < ROUTING_STALE {
+				res = append(res, contact)
+			}
+		}
+	}
+
+	rtt := func(contact PacketContact) time.Duration {
+		if rtt, ok := this.rtt[hex.EncodeToString(contact.Hash)]; ok {
+			return rtt
+		}
+
+		return RPC_TIMEOUT
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return rtt(res[i]) < rtt(res[j])
+	})
+
+	if len(res) > n {
+		res = res[:n]
+	}
+
+	return res
+}
+
+func (this *Dht) savePeerCache() error {
+	if len(this.options.PeerCachePath) == 0 {
+		return nil
+	}
+
+	contacts := this.routing.reliableContacts(this.options.PeerCacheSize)
+
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	file, err := os.Create(this.options.PeerCachePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return gob.NewEncoder(file).Encode(contacts)
+}
+
+// loadPeerCache returns the addresses of the cached peers, to be tried along
+// with the bootstrap nodes
+func (this *Dht) loadPeerCache() []string {
+	if len(this.options.PeerCachePath) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(this.options.PeerCachePath)
+
+	if err != nil {
+		return nil
+	}
+
+	defer file.Close()
+
+	contacts := []PacketContact{}
+
+	if err := gob.NewDecoder(file).Decode(&contacts); err != nil {
+		this.logger.Warning("Cannot load peer cache", err)
+
+		return nil
+	}
+
+	res := []string{}
+
+	for _, contact := range contacts {
+		if !this.isContactBanned(contact) {
+			res = append(res, contact.Addr)
+		}
+	}
+
+	return res
+}
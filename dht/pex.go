@@ -0,0 +1,129 @@
+package dht
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	PEX_INTERVAL    = time.Minute * 5
+	PEX_SAMPLE_SIZE = 8
+)
+
+func (this *Dht) startPex(done chan struct{}) {
+	timer := time.NewTicker(this.options.PexInterval)
+
+	go func() {
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				if this.running {
+					this.pex()
+				}
+			}
+		}
+	}()
+}
+
+// pex exchanges a sample of the routing table with a random contact
+func (this *Dht) pex() {
+	contacts := this.routing.GetAllNodes()
+
+	if len(contacts) == 0 {
+		return
+	}
+
+	contact := contacts[rand.Intn(len(contacts))]
+
+	addr, err := this.resolve(contact)
+
+	if err != nil {
+		return
+	}
+
+	sample, err := NewNodeContact(this, addr, contact).Pex(this.pexSample())
+
+	if err != nil {
+		return
+	}
+
+	this.learnContacts(sample)
+}
+
+func (this *Dht) pexSample() []PacketContact {
+	contacts := this.routing.GetAllNodes()
+
+	rand.Shuffle(len(contacts), func(i, j int) {
+		contacts[i], contacts[j] = contacts[j], contacts[i]
+	})
+
+	if len(contacts) > this.options.PexSampleSize {
+		contacts = contacts[:this.options.PexSampleSize]
+	}
+
+	return contacts
+}
+
+// learnContacts pings the unknown contacts of a sample, the pong adding them
+// to the routing table. Contacts are never trusted without an answer
+func (this *Dht) learnContacts(contacts []PacketContact) {
+	if len(contacts) > this.options.PexSampleSize {
+		contacts = contacts[:this.options.PexSampleSize]
+	}
+
+	for _, contact := range contacts {
+		if compare(contact.Hash, this.hash) == 0 || this.isContactBanned(contact) || !this.validContact(contact) {
+			continue
+		}
+
+		if _, err := this.routing.GetNode(contact.Hash); err == nil {
+			continue
+		}
+
+		addr, err := this.resolve(contact)
+
+		if err != nil {
+			continue
+		}
+
+		go NewNodeContact(this, addr, contact).Ping()
+	}
+}
+
+func (this *Node) Pex(sample []PacketContact) ([]PacketContact, error) {
+	this.dht.logger.Debug(this, "< PEX", len(sample))
+
+	res, err := this.request(this.newPacket(COMMAND_PEX, []byte{}, sample))
+
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, ok := res.Data.([]PacketContact)
+
+	if !ok {
+		return nil, this.newError(ErrInvalidResponse, nil)
+	}
+
+	return contacts, nil
+}
+
+func (this *Node) OnPex(packet Packet) {
+	sample, _ := packet.Data.([]PacketContact)
+
+	this.dht.logger.Debug(this, "> PEX", len(sample))
+
+	this.dht.learnContacts(sample)
+
+	this.send(this.newPacket(COMMAND_PEX_ANSWER, packet.Header.MessageHash, this.dht.pexSample()))
+}
+
+func (this *Node) OnPexAnswer(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> PEX ANSWER")
+
+	done.c <- packet
+}
@@ -0,0 +1,126 @@
+package dht
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_POOLED_BUFFER = 1024 * 64
+)
+
+// The buffers are pooled, but not the gob encoders and decoders: every
+// datagram carries its own type definitions, so a gob stream is never longer
+// than one packet
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	return buf
+}
+
+// putBuffer drops the buffers grown by a big value instead of keeping them
+// alive in the pool
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > MAX_POOLED_BUFFER {
+		return
+	}
+
+	bufferPool.Put(buf)
+}
+
+var datagramPool = sync.Pool{
+	New: func() interface{} {
+		return new([MAX_DATAGRAM_SIZE]byte)
+	},
+}
+
+func getDatagram() *[MAX_DATAGRAM_SIZE]byte {
+	return datagramPool.Get().(*[MAX_DATAGRAM_SIZE]byte)
+}
+
+func putDatagram(datagram *[MAX_DATAGRAM_SIZE]byte) {
+	datagramPool.Put(datagram)
+}
+
+// pooledTimer runs the timeout of a pending request. It goes back to the pool
+// once stopped or fired, and its generation is bumped each time so that a
+// stale rpcTimer can never stop the request it has been reused for
+type pooledTimer struct {
+	sync.Mutex
+	timer *time.Timer
+	gen   uint64
+	fire  func()
+}
+
+var timerPool = sync.Pool{}
+
+// rpcTimer is the handle on a pooledTimer for one request
+type rpcTimer struct {
+	timer *pooledTimer
+	gen   uint64
+}
+
+func newRpcTimer(timeout time.Duration, fire func()) rpcTimer {
+	pooled, ok := timerPool.Get().(*pooledTimer)
+
+	if !ok {
+		pooled = &pooledTimer{}
+		pooled.fire = fire
+		pooled.timer = time.AfterFunc(timeout, pooled.run)
+
+		return rpcTimer{timer: pooled}
+	}
+
+	pooled.Lock()
+	pooled.fire = fire
+	gen := pooled.gen
+	pooled.timer.Reset(timeout)
+	pooled.Unlock()
+
+	return rpcTimer{timer: pooled, gen: gen}
+}
+
+func (this *pooledTimer) run() {
+	this.Lock()
+	fire := this.fire
+	this.fire = nil
+	this.gen++
+	this.Unlock()
+
+	if fire != nil {
+		fire()
+	}
+
+	timerPool.Put(this)
+}
+
+// Stop returns false when the timer has already fired or been stopped
+func (this rpcTimer) Stop() bool {
+	if this.timer == nil {
+		return false
+	}
+
+	this.timer.Lock()
+
+	if this.timer.gen != this.gen || !this.timer.timer.Stop() {
+		this.timer.Unlock()
+
+		return false
+	}
+
+	this.timer.fire = nil
+	this.timer.gen++
+	this.timer.Unlock()
+
+	timerPool.Put(this.timer)
+
+	return true
+}
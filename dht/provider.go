@@ -0,0 +1,242 @@
+package dht
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+const (
+	PROVIDER_TTL = time.Hour * 24
+)
+
+type ProviderRecord struct {
+	Contact    PacketContact
+	Expiration int64
+}
+
+type ProvidersInst struct {
+	Providers []PacketContact
+	Nodes     []PacketContact
+}
+
+func (this ProviderRecord) Expired() bool {
+	return time.Now().UnixNano() > this.Expiration
+}
+
+func (this *Dht) Provide(hash []byte) (int, error) {
+	this.Lock()
+	this.providing[hex.EncodeToString(hash)] = hash
+	this.Unlock()
+
+	return this.provide(hash)
+}
+
+// AddProvider records contact as a provider of hash on this node, answered
+// to the peers looking for it until PROVIDER_TTL passed
+func (this *Dht) AddProvider(hash []byte, contact PacketContact) {
+	if contact.Family == 0 && len(contact.Addr) > 0 {
+		contact.Family = addrFamily(contact.Addr)
+	}
+
+	this.addProvider(hash, contact, PROVIDER_TTL)
+}
+
+func (this *Dht) provide(hash []byte) (int, error) {
+	nodes := this.fetchNodes(hash)
+
+	if len(nodes) == 0 {
+		return 0, ErrNoNodes
+	}
+
+	answers := make(chan error, len(nodes))
+
+	for _, node := range nodes {
+		go func(node *Node) {
+			answers <- node.AddProvider(hash)
+		}(node)
+	}
+
+	okNb := 0
+
+	for range nodes {
+		if err := <-answers; err == nil {
+			okNb++
+		}
+	}
+
+	return okNb, nil
+}
+
+func (this *Dht) FindProviders(hash []byte) ([]PacketContact, error) {
+	if providers := this.localProviders(hash); len(providers) > 0 {
+		return providers, nil
+	}
+
+	fn := func(node *Node) (QueryResult, error) {
+		inst, err := node.GetProviders(hash)
+
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		return QueryResult{
+			Value: inst.Providers,
+			Found: len(inst.Providers) > 0,
+			Nodes: inst.Nodes,
+		}, nil
+	}
+
+	providers := []PacketContact{}
+	seen := make(map[string]bool)
+
+	for _, result := range this.lookupPaths(hash, fn, lookupOptions{}) {
+		contacts, ok := result.value.([]PacketContact)
+
+		if !result.found || !ok {
+			continue
+		}
+
+		for _, contact := range contacts {
+			if key := hex.EncodeToString(contact.Hash); !seen[key] {
+				seen[key] = true
+				providers = append(providers, contact)
+			}
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return providers, nil
+}
+
+func (this *Dht) addProvider(hash []byte, contact PacketContact, ttl time.Duration) {
+	key := hex.EncodeToString(hash)
+
+	this.Lock()
+	defer this.Unlock()
+
+	records := this.providers[key]
+
+	for i, record := range records {
+		if compare(record.Contact.Hash, contact.Hash) == 0 {
+			records = append(records[:i], records[i+1:]...)
+			break
+		}
+	}
+
+	this.providers[key] = append(records, ProviderRecord{
+		Contact:    contact,
+		Expiration: time.Now().Add(ttl).UnixNano(),
+	})
+}
+
+func (this *Dht) localProviders(hash []byte) []PacketContact {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []PacketContact{}
+
+	for _, record := range this.providers[hex.EncodeToString(hash)] {
+		if !record.Expired() {
+			res = append(res, record.Contact)
+		}
+	}
+
+	return res
+}
+
+func (this *Dht) sweepProviders() {
+	this.Lock()
+	defer this.Unlock()
+
+	for key, records := range this.providers {
+		alive := []ProviderRecord{}
+
+		for _, record := range records {
+			if !record.Expired() {
+				alive = append(alive, record)
+			}
+		}
+
+		if len(alive) == 0 {
+			delete(this.providers, key)
+		} else {
+			this.providers[key] = alive
+		}
+	}
+}
+
+func (this *Dht) reprovide() {
+	this.RLock()
+	hashes := make([][]byte, 0, len(this.providing))
+
+	for _, hash := range this.providing {
+		hashes = append(hashes, hash)
+	}
+	this.RUnlock()
+
+	for _, hash := range hashes {
+		this.provide(hash)
+	}
+
+	this.logger.Debug("Reprovided", len(hashes))
+}
+
+func (this *Node) AddProvider(hash []byte) error {
+	this.dht.logger.Debug(this, "< ADD PROVIDER", hex.EncodeToString(hash)[:16])
+
+	_, err := this.request(this.newPacket(COMMAND_ADD_PROVIDER, []byte{}, hash))
+
+	return err
+}
+
+func (this *Node) OnAddProvider(packet Packet) {
+	hash, _ := packet.Data.([]byte)
+
+	this.dht.logger.Debug(this, "> ADD PROVIDER", hex.EncodeToString(hash)[:16])
+
+	this.dht.addProvider(hash, packet.Header.Sender, PROVIDER_TTL)
+
+	this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, nil))
+}
+
+func (this *Node) GetProviders(hash []byte) (ProvidersInst, error) {
+	this.dht.logger.Debug(this, "< GET PROVIDERS", hex.EncodeToString(hash)[:16])
+
+	res, err := this.request(this.newPacket(COMMAND_GET_PROVIDERS, []byte{}, hash))
+
+	if err != nil {
+		return ProvidersInst{}, err
+	}
+
+	inst, ok := res.Data.(ProvidersInst)
+
+	if !ok {
+		return ProvidersInst{}, this.newError(ErrInvalidResponse, nil)
+	}
+
+	return inst, nil
+}
+
+func (this *Node) OnGetProviders(packet Packet) {
+	hash, _ := packet.Data.([]byte)
+
+	this.dht.logger.Debug(this, "> GET PROVIDERS", hex.EncodeToString(hash)[:16])
+
+	inst := ProvidersInst{
+		Providers: this.dht.localProviders(hash),
+		Nodes:     this.dht.routing.FindNode(hash),
+	}
+
+	this.dht.logger.Debug(this, "< PROVIDERS", len(inst.Providers))
+
+	this.send(this.newPacket(COMMAND_PROVIDERS, packet.Header.MessageHash, inst))
+}
+
+func (this *Node) OnProviders(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> PROVIDERS")
+
+	done.c <- packet
+}
@@ -0,0 +1,271 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	TOPIC_BUFFER = 64
+	TOPIC_SEEN   = time.Minute * 10
+)
+
+type PublishInst struct {
+	Topic     string
+	Id        []byte
+	Data      interface{}
+	PublicKey []byte
+	Signature []byte
+}
+
+// TopicMessage is a published message. PublicKey is set when the message
+// was signed by its publisher, and the signature has been verified
+type TopicMessage struct {
+	Topic     string
+	Data      interface{}
+	From      PacketContact
+	PublicKey []byte
+}
+
+func (this *Dht) topicHash(topic string) []byte {
+	return this.NewHash([]byte("/pubsub/" + topic))
+}
+
+// signedBytes prefixes the topic and the id with their length, so that no
+// byte can move from one to the other under the same signature
+func (this PublishInst) signedBytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(this.Topic)))
+	buf.WriteString(this.Topic)
+	binary.Write(&buf, binary.BigEndian, uint32(len(this.Id)))
+	buf.Write(this.Id)
+
+	if err := gob.NewEncoder(&buf).Encode(&this.Data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Verify checks the signature of a signed message. An unsigned one must not
+// claim an author
+func (this PublishInst) Verify() error {
+	if this.Signature == nil {
+		if this.PublicKey != nil {
+			return errors.New("Public key without signature")
+		}
+
+		return nil
+	}
+
+	if len(this.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("Invalid public key")
+	}
+
+	blob, err := this.signedBytes()
+
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(this.PublicKey, blob, this.Signature) {
+		return errors.New("Invalid signature")
+	}
+
+	return nil
+}
+
+// SubscribeTopic registers this node as a provider of the topic hash, for
+// publishers to find it, and returns a channel receiving its messages.
+// Messages are dropped when the channel is full
+func (this *Dht) SubscribeTopic(topic string) (<-chan TopicMessage, error) {
+	if !this.capable(CAP_PUBSUB) {
+		return nil, errors.New("Pubsub is disabled")
+	}
+
+	if _, err := this.Provide(this.topicHash(topic)); err != nil {
+		return nil, err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	c := make(chan TopicMessage, TOPIC_BUFFER)
+
+	this.topics[topic] = append(this.topics[topic], c)
+
+	return c, nil
+}
+
+// UnsubscribeTopic stops providing the topic once its last channel is
+// gone. The provider records expire on their own
+func (this *Dht) UnsubscribeTopic(topic string, c <-chan TopicMessage) {
+	this.Lock()
+	defer this.Unlock()
+
+	subscribers := this.topics[topic]
+
+	for i, subscriber := range subscribers {
+		if subscriber == c {
+			subscribers = append(subscribers[:i], subscribers[i+1:]...)
+			close(subscriber)
+
+			break
+		}
+	}
+
+	if len(subscribers) > 0 {
+		this.topics[topic] = subscribers
+
+		return
+	}
+
+	delete(this.topics, topic)
+	delete(this.providing, hex.EncodeToString(this.topicHash(topic)))
+}
+
+// Publish sends data to every subscriber of the topic, and returns how many
+// acknowledged it
+func (this *Dht) Publish(topic string, data interface{}) (int, error) {
+	return this.publish(PublishInst{
+		Topic: topic,
+		Id:    this.newRandomHash(),
+		Data:  data,
+	})
+}
+
+// PublishSigned is Publish with the message signed by priv, for subscribers
+// to authenticate its author whatever node it comes from
+func (this *Dht) PublishSigned(priv ed25519.PrivateKey, topic string, data interface{}) (int, error) {
+	inst := PublishInst{
+		Topic:     topic,
+		Id:        this.newRandomHash(),
+		Data:      data,
+		PublicKey: priv.Public().(ed25519.PublicKey),
+	}
+
+	blob, err := inst.signedBytes()
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+
+	inst.Signature = ed25519.Sign(priv, blob)
+
+	return this.publish(inst)
+}
+
+func (this *Dht) publish(inst PublishInst) (int, error) {
+	subscribers, err := this.FindProviders(this.topicHash(inst.Topic))
+
+	if err != nil {
+		return 0, err
+	}
+
+	answers := make(chan error, len(subscribers))
+
+	for _, contact := range subscribers {
+		if compare(contact.Hash, this.hash) == 0 {
+			this.deliver(inst, this.contact())
+			answers <- nil
+
+			continue
+		}
+
+		addr, err := this.resolve(contact)
+
+		if err != nil {
+			answers <- err
+
+			continue
+		}
+
+		go func(node *Node) {
+			answers <- node.Publish(inst)
+		}(NewNodeContact(this, addr, contact))
+	}
+
+	okNb := 0
+
+	for range subscribers {
+		if err := <-answers; err == nil {
+			okNb++
+		}
+	}
+
+	return okNb, nil
+}
+
+// deliver passes a message once to the local subscribers of its topic
+func (this *Dht) deliver(inst PublishInst, from PacketContact) {
+	key := inst.Topic + "/" + hex.EncodeToString(inst.Id)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.topicSeen[key]; ok {
+		return
+	}
+
+	this.topicSeen[key] = time.Now()
+
+	msg := TopicMessage{
+		Topic:     inst.Topic,
+		Data:      inst.Data,
+		From:      from,
+		PublicKey: inst.PublicKey,
+	}
+
+	for _, subscriber := range this.topics[inst.Topic] {
+		select {
+		case subscriber <- msg:
+		default:
+		}
+	}
+}
+
+func (this *Dht) pruneTopics() {
+	this.Lock()
+	defer this.Unlock()
+
+	for key, seen := range this.topicSeen {
+		if time.Since(seen) > TOPIC_SEEN {
+			delete(this.topicSeen, key)
+		}
+	}
+}
+
+func (this *Node) Publish(inst PublishInst) error {
+	this.dht.logger.Debug(this, "< PUBLISH", inst.Topic)
+
+	_, err := this.request(this.newPacket(COMMAND_PUBLISH, []byte{}, inst))
+
+	return err
+}
+
+func (this *Node) OnPublish(packet Packet) {
+	inst, ok := packet.Data.(PublishInst)
+
+	if !ok {
+		return
+	}
+
+	this.dht.logger.Debug(this, "> PUBLISH", inst.Topic)
+
+	if err := inst.Verify(); err != nil {
+		this.dht.logger.Warning(this, "Invalid published message", err)
+
+		return
+	}
+
+	this.dht.deliver(inst, packet.Header.Sender)
+
+	this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, nil))
+}
@@ -0,0 +1,51 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"math/bits"
+)
+
+func leadingZeroBits(blob []byte) int {
+	count := 0
+
+	for _, b := range blob {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+
+		count += 8
+	}
+
+	return count
+}
+
+// CheckPuzzle tells if the hash of the node ID starts with at least
+// difficulty zero bits (S/Kademlia static puzzle)
+func CheckPuzzle(hash []byte, difficulty int) bool {
+	if difficulty <= 0 {
+		return true
+	}
+
+	sum := sha256.Sum256(hash)
+
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+func NewPuzzleIdentity(difficulty int) (ed25519.PublicKey, ed25519.PrivateKey) {
+	return newPuzzleIdentity(difficulty, NewHash)
+}
+
+func newPuzzleIdentity(difficulty int, newHash func([]byte) []byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	for {
+		pub, priv := NewIdentity()
+
+		if CheckPuzzle(newHash(pub), difficulty) {
+			return pub, priv
+		}
+	}
+}
+
+func (this *Dht) validContact(contact PacketContact) bool {
+	return len(contact.Hash) == this.hashLen() && CheckPuzzle(contact.Hash, this.options.IdDifficulty)
+}
@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+// StoreQuorum is StoreAt, failing with ErrQuorum when less than w nodes
+// acknowledged the value
+func (this *Dht) StoreQuorum(hash []byte, value interface{}, w int) ([]byte, int, error) {
+	res, stored, err := this.StoreAt(hash, value)
+
+	if err == nil && stored < w {
+		return res, stored, ErrQuorum
+	}
+
+	return res, stored, err
+}
+
+// FetchQuorum returns the value once r nodes answered the same copy, the
+// local one included. The lookup goes on past the first copy found, and
+// fails with ErrQuorum when it ends before that
+func (this *Dht) FetchQuorum(hash []byte, r int) (interface{}, error) {
+	var lock sync.Mutex
+
+	votes := make(map[string]int)
+
+	if inst, ok := this.getLocal(hex.EncodeToString(hash)); ok {
+		votes[valueKey(inst.Data)]++
+	}
+
+	fn := func(node *Node) (QueryResult, error) {
+		res, err := node.Fetch(hash)
+
+		if err != nil || !res.Found {
+			return res, err
+		}
+
+		if err := this.checkContent(hash, res.Value); err != nil {
+			return QueryResult{}, node.newError(ErrIntegrity, nil)
+		}
+
+		key := valueKey(res.Value)
+
+		lock.Lock()
+		votes[key]++
+		voted := votes[key]
+		lock.Unlock()
+
+		// not enough yet, the lookup goes on with the other nodes
+		if voted < r {
+			return QueryResult{}, nil
+		}
+
+		return res, nil
+	}
+
+	res, found, _ := this.lookup(hash, fn, lookupOptions{})
+
+	if !found {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if len(votes) > 0 {
+			return nil, ErrQuorum
+		}
+
+		return nil, ErrNotFound
+	}
+
+	if err := this.validate(hash, res); err != nil {
+		return nil, err
+	}
+
+	this.emit(EVENT_VALUE_FETCHED, PacketContact{}, hash)
+
+	return this.rebuildManifest(hash, res)
+}
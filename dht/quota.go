@@ -0,0 +1,202 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"strconv"
+)
+
+const (
+	PUBLISHER_MAX_ENTRIES = 4096
+	PUBLISHER_MAX_BYTES   = 1024 * 1024 * 8
+)
+
+// QuotaExceeded is answered to a STORE that would take its publisher over
+// MaxPublisherEntries or MaxPublisherBytes, with its current usage
+type QuotaExceeded struct {
+	Publisher []byte
+	Entries   int
+	Bytes     int
+}
+
+func (this QuotaExceeded) Error() string {
+	return hex.EncodeToString(this.Publisher) + ": Quota exceeded, storing " + strconv.Itoa(this.Entries) + " values for " + strconv.Itoa(this.Bytes) + " bytes"
+}
+
+// publisherBytes are the fields of inst its publisher signs
+func (this StoreInst) publisherBytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(this.Hash)))
+	buf.Write(this.Hash)
+	binary.Write(&buf, binary.BigEndian, uint32(len(this.Key)))
+	buf.WriteString(this.Key)
+	binary.Write(&buf, binary.BigEndian, this.Expiration)
+	binary.Write(&buf, binary.BigEndian, uint32(len(this.DeleteToken)))
+	buf.Write(this.DeleteToken)
+
+	if err := gob.NewEncoder(&buf).Encode(&this.Data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// signPublisher makes this node the publisher of inst, so that the nodes it
+// is replicated to can tell who to charge
+func (this *Dht) signPublisher(inst StoreInst) StoreInst {
+	blob, err := inst.publisherBytes()
+
+	if err != nil {
+		return inst
+	}
+
+	inst.Publisher = this.hash
+	inst.PublisherKey = this.publicKey
+	inst.PublisherSig = ed25519.Sign(this.privateKey, blob)
+
+	return inst
+}
+
+// authenticPublisher tells if inst is signed by the publisher it claims
+func (this *Dht) authenticPublisher(inst StoreInst) bool {
+	if len(inst.PublisherKey) != ed25519.PublicKeySize || compare(this.NewHash(inst.PublisherKey), inst.Publisher) != 0 {
+		return false
+	}
+
+	blob, err := inst.publisherBytes()
+
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(inst.PublisherKey, blob, inst.PublisherSig)
+}
+
+// knownPublisher tells if the publisher of inst is a peer of the routing
+// table, whose ID meets IdDifficulty. Signing with a throwaway key makes no
+// such publisher
+func (this *Dht) knownPublisher(inst StoreInst) bool {
+	contact, err := this.routing.GetNode(inst.Publisher)
+
+	return err == nil && this.validContact(contact)
+}
+
+type publisherUsage struct {
+	entries int
+	bytes   int
+}
+
+// checkQuota tells if storing inst in place of existing keeps its publisher
+// under quota. Must be called locked
+func (this *Dht) checkQuota(inst StoreInst, existing StoreInst, exists bool) error {
+	usage := this.usage[hex.EncodeToString(inst.Publisher)]
+
+	// the usage still counts the values expired since the last sweep
+	if this.overQuota(inst, existing, exists, usage) {
+		usage = this.liveUsage(inst.Publisher)
+	}
+
+	if this.overQuota(inst, existing, exists, usage) {
+		return QuotaExceeded{
+			Publisher: inst.Publisher,
+			Entries:   usage.entries,
+			Bytes:     usage.bytes,
+		}
+	}
+
+	return nil
+}
+
+func (this *Dht) overQuota(inst StoreInst, existing StoreInst, exists bool, usage publisherUsage) bool {
+	entries := usage.entries + 1
+	bytes := usage.bytes + instSize(inst)
+
+	if exists && compare(existing.Publisher, inst.Publisher) == 0 {
+		entries--
+		bytes -= instSize(existing)
+	}
+
+	maxEntries := this.options.MaxPublisherEntries
+	maxBytes := this.options.MaxPublisherBytes
+
+	return (maxEntries > 0 && entries > maxEntries) || (maxBytes > 0 && bytes > maxBytes)
+}
+
+// liveUsage counts the values of publisher that have not expired, and keeps
+// the result. Must be called locked
+func (this *Dht) liveUsage(publisher []byte) publisherUsage {
+	usage := publisherUsage{}
+
+	this.store.ForEach(func(key string, inst StoreInst) bool {
+		if !inst.Expired() && compare(inst.Publisher, publisher) == 0 {
+			usage.entries++
+			usage.bytes += instSize(inst)
+		}
+
+		return true
+	})
+
+	key := hex.EncodeToString(publisher)
+
+	if usage.entries == 0 {
+		delete(this.usage, key)
+	} else {
+		this.usage[key] = usage
+	}
+
+	return usage
+}
+
+// trackUsage counts inst for its publisher, or discounts it when removed.
+// Must be called locked
+func (this *Dht) trackUsage(inst StoreInst, removed bool) {
+	if len(inst.Publisher) == 0 {
+		return
+	}
+
+	key := hex.EncodeToString(inst.Publisher)
+	usage := this.usage[key]
+
+	if removed {
+		usage.entries--
+		usage.bytes -= instSize(inst)
+	} else {
+		usage.entries++
+		usage.bytes += instSize(inst)
+	}
+
+	if usage.entries <= 0 {
+		delete(this.usage, key)
+	} else {
+		this.usage[key] = usage
+	}
+}
+
+// recountUsage rebuilds the usage from the storage, as values expire and
+// get evicted without being discounted
+func (this *Dht) recountUsage() {
+	usage := make(map[string]publisherUsage)
+
+	this.storage().ForEach(func(key string, inst StoreInst) bool {
+		if len(inst.Publisher) == 0 || inst.Expired() {
+			return true
+		}
+
+		publisher := hex.EncodeToString(inst.Publisher)
+
+		usage[publisher] = publisherUsage{
+			entries: usage[publisher].entries + 1,
+			bytes:   usage[publisher].bytes + instSize(inst),
+		}
+
+		return true
+	})
+
+	this.Lock()
+	this.usage = usage
+	this.Unlock()
+}
@@ -0,0 +1,115 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT = 100
+	RATE_BURST = 200
+)
+
+type BusyError struct {
+	RetryAfter time.Duration
+}
+
+func (this BusyError) Error() string {
+	return "Busy, retry after " + this.RetryAfter.String()
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token for key, the hostKey of the source for the requests.
+// When none is left, returns false and the time to wait for the next one
+func (this *RateLimiter) Allow(key string) (bool, time.Duration) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	bucket, ok := this.buckets[key]
+
+	if !ok {
+		bucket = &tokenBucket{tokens: this.burst, last: now}
+		this.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * this.rate
+	bucket.last = now
+
+	if bucket.tokens > this.burst {
+		bucket.tokens = this.burst
+	}
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / this.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+
+	return true, 0
+}
+
+// SetRate changes the rate and burst of every key, their tokens left
+// included
+func (this *RateLimiter) SetRate(rate float64, burst int) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.rate = rate
+	this.burst = float64(burst)
+}
+
+// Prune forgets the buckets that have been refilled
+func (this *RateLimiter) Prune() {
+	this.Lock()
+	defer this.Unlock()
+
+	for key, bucket := range this.buckets {
+		if bucket.tokens+time.Since(bucket.last).Seconds()*this.rate >= this.burst {
+			delete(this.buckets, key)
+		}
+	}
+}
+
+func (this *Node) Busy(packet Packet, retryAfter time.Duration) {
+	this.dht.logger.Debug(this, "< BUSY", retryAfter)
+
+	data := this.newPacket(COMMAND_BUSY, packet.Header.MessageHash, BusyError{RetryAfter: retryAfter})
+
+	this.send(data)
+}
+
+func (this *Node) OnBusy(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> BUSY", packet.Data)
+
+	busy, ok := packet.Data.(BusyError)
+
+	if !ok {
+		busy = BusyError{}
+	}
+
+	done.c <- busy
+}
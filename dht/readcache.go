@@ -0,0 +1,63 @@
+package dht
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+const (
+	READ_CACHE_TTL = time.Minute
+)
+
+// newReadCache keeps the last ReadCacheSize values fetched from the
+// network, nil when disabled
+func newReadCache(size int) *LRUStorage {
+	if size <= 0 {
+		return nil
+	}
+
+	return NewLRUStorage(NewMemoryStorage(), size, 0)
+}
+
+func (this *Dht) cachedRead(hash []byte) (interface{}, bool) {
+	if this.readCache == nil {
+		return nil, false
+	}
+
+	key := hex.EncodeToString(hash)
+	inst, ok := this.readCache.Get(key)
+
+	if !ok {
+		return nil, false
+	}
+
+	if inst.Expired() {
+		this.readCache.Delete(key)
+
+		return nil, false
+	}
+
+	return inst.Data, true
+}
+
+func (this *Dht) cacheRead(hash []byte, value interface{}) {
+	if this.readCache == nil {
+		return
+	}
+
+	this.readCache.Set(hex.EncodeToString(hash), StoreInst{
+		Hash:       hash,
+		Data:       value,
+		Expiration: time.Now().Add(this.options.ReadCacheTTL).UnixNano(),
+	})
+}
+
+// uncacheRead forgets the value of a hash this node stores or deletes, so
+// that its next fetch sees the change
+func (this *Dht) uncacheRead(hash []byte) {
+	if this.readCache == nil {
+		return
+	}
+
+	this.readCache.Delete(hex.EncodeToString(hash))
+}
@@ -0,0 +1,211 @@
+package dht
+
+import (
+	"fmt"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+// Setting changes an option of a running node, with Reconfigure
+type Setting func(*DhtOptions) error
+
+// tunables are the options Reconfigure changes that the node reads while
+// running. They are replaced as a whole, so that their readers need no lock
+type tunables struct {
+	K                 int
+	Alpha             int
+	ReplicateInterval time.Duration
+	RepublishInterval time.Duration
+	RefreshInterval   time.Duration
+}
+
+func newTunables(options DhtOptions) *tunables {
+	return &tunables{
+		K:                 options.K,
+		Alpha:             options.Alpha,
+		ReplicateInterval: options.ReplicateInterval,
+		RepublishInterval: options.RepublishInterval,
+		RefreshInterval:   options.RefreshInterval,
+	}
+}
+
+// tuned returns the current tunables, to be read once by the code that needs
+// them to agree
+func (this *Dht) tuned() *tunables {
+	return this.tuning.Load()
+}
+
+// SetVerbose sets the level of the default logger, from 0 for CRITICAL to 5
+// for DEBUG. A Logger of DhtOptions keeps its own level
+func SetVerbose(level int) Setting {
+	return func(options *DhtOptions) error {
+		if level < 0 || level > 5 {
+			return fmt.Errorf("SetVerbose: Level must be from 0 to 5, got %d", level)
+		}
+
+		options.Verbose = level
+
+		return nil
+	}
+}
+
+// SetRateLimit sets the requests per second and burst allowed to each peer,
+// a negative rate disabling the limit
+func SetRateLimit(rate float64, burst int) Setting {
+	return func(options *DhtOptions) error {
+		if rate == 0 || burst <= 0 {
+			return fmt.Errorf("SetRateLimit: Invalid rate %v or burst %d", rate, burst)
+		}
+
+		options.RateLimit = rate
+		options.RateBurst = burst
+
+		return nil
+	}
+}
+
+// SetK sets the replication factor, which is also the size of the buckets
+// and of the lookup results
+func SetK(k int) Setting {
+	return func(options *DhtOptions) error {
+		if k <= 0 {
+			return fmt.Errorf("SetK: K must be positive, got %d", k)
+		}
+
+		options.K = k
+
+		return nil
+	}
+}
+
+func SetAlpha(alpha int) Setting {
+	return func(options *DhtOptions) error {
+		if alpha <= 0 {
+			return fmt.Errorf("SetAlpha: Alpha must be positive, got %d", alpha)
+		}
+
+		options.Alpha = alpha
+
+		return nil
+	}
+}
+
+// SetIntervals sets the intervals of replication, republication and bucket
+// refresh, a zero one being kept as is
+func SetIntervals(replicate time.Duration, republish time.Duration, refresh time.Duration) Setting {
+	return func(options *DhtOptions) error {
+		if replicate < 0 || republish < 0 || refresh < 0 {
+			return fmt.Errorf("SetIntervals: Negative interval %v, %v or %v", replicate, republish, refresh)
+		}
+
+		if replicate > 0 {
+			options.ReplicateInterval = replicate
+		}
+
+		if republish > 0 {
+			options.RepublishInterval = republish
+		}
+
+		if refresh > 0 {
+			options.RefreshInterval = refresh
+		}
+
+		return nil
+	}
+}
+
+// Reconfigure applies the settings in order, all of them or none when one
+// fails, and emits EVENT_RECONFIGURED once they take effect. Running
+// lookups and requests keep the values they started with
+func (this *Dht) Reconfigure(settings ...Setting) error {
+	this.Lock()
+
+	next := this.options
+
+	for _, setting := range settings {
+		if err := setting(&next); err != nil {
+			this.Unlock()
+
+			return err
+		}
+	}
+
+	if err := checkReconfigured(next); err != nil {
+		this.Unlock()
+
+		return err
+	}
+
+	verbose := this.options.Verbose != next.Verbose
+
+	this.options.Verbose = next.Verbose
+	this.options.RateLimit = next.RateLimit
+	this.options.RateBurst = next.RateBurst
+	this.options.K = next.K
+	this.options.Alpha = next.Alpha
+	this.options.ReplicateInterval = next.ReplicateInterval
+	this.options.RepublishInterval = next.RepublishInterval
+	this.options.RefreshInterval = next.RefreshInterval
+	this.tuning.Store(newTunables(next))
+
+	if this.tickers.replicate != nil {
+		this.tickers.replicate.Reset(jitter(next.ReplicateInterval))
+		this.tickers.republish.Reset(jitter(next.RepublishInterval))
+	}
+
+	this.Unlock()
+
+	this.limiter.SetRate(next.RateLimit, next.RateBurst)
+
+	// the level of go-logging is not safe to set while logging
+	if verbose && this.options.Logger == nil {
+		logging.SetLevel(logLevel(next.Verbose), "")
+	}
+
+	this.logger.Info("Reconfigured")
+
+	this.emit(EVENT_RECONFIGURED, PacketContact{}, nil)
+
+	return nil
+}
+
+// checkReconfigured is Validate, with no zero value left to stand for a
+// default once the node is made
+func checkReconfigured(options DhtOptions) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"K", options.K},
+		{"Alpha", options.Alpha},
+		{"RateBurst", options.RateBurst},
+	}
+
+	for _, field := range counts {
+		if field.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", field.name, field.value)
+		}
+	}
+
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"ReplicateInterval", options.ReplicateInterval},
+		{"RepublishInterval", options.RepublishInterval},
+		{"RefreshInterval", options.RefreshInterval},
+	}
+
+	for _, field := range intervals {
+		if field.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", field.name, field.value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package dht
+
+import (
+	"errors"
+)
+
+const (
+	MAX_ERASURE_SHARDS = 256
+)
+
+var (
+	errTooFewShards  = errors.New("Too few shards")
+	errShardSize     = errors.New("Shards of different sizes")
+	errShardsNumbers = errors.New("Invalid number of shards")
+	errErasureSize   = errors.New("Invalid erasure coded size")
+)
+
+// GF(2^8) arithmetic over the polynomial x^8 + x^4 + x^3 + x^2 + 1
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+
+		x <<= 1
+
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// reedSolomon is a systematic code: the first shards are the data split in
+// equal parts, the parity ones are given by a Cauchy matrix, so any data of
+// them rebuild the data
+type reedSolomon struct {
+	data   int
+	parity int
+	matrix [][]byte
+}
+
+func newReedSolomon(data int, parity int) (*reedSolomon, error) {
+	if data <= 0 || parity < 0 || data+parity > MAX_ERASURE_SHARDS {
+		return nil, errShardsNumbers
+	}
+
+	res := &reedSolomon{
+		data:   data,
+		parity: parity,
+		matrix: make([][]byte, data+parity),
+	}
+
+	for i := range res.matrix {
+		res.matrix[i] = make([]byte, data)
+
+		if i < data {
+			res.matrix[i][i] = 1
+
+			continue
+		}
+
+		// 1 / (x_i + y_j), with x_i and y_j all distinct
+		for j := 0; j < data; j++ {
+			res.matrix[i][j] = gfInv(byte(i) ^ byte(j))
+		}
+	}
+
+	return res, nil
+}
+
+// encode splits a blob in data shards, padded with zeros, followed by the
+// parity shards
+func (this *reedSolomon) encode(blob []byte) [][]byte {
+	size := (len(blob) + this.data - 1) / this.data
+
+	if size == 0 {
+		size = 1
+	}
+
+	padded := make([]byte, size*this.data)
+	copy(padded, blob)
+
+	shards := make([][]byte, this.data+this.parity)
+
+	for i := 0; i < this.data; i++ {
+		shards[i] = padded[i*size : (i+1)*size]
+	}
+
+	for i := this.data; i < len(shards); i++ {
+		shards[i] = make([]byte, size)
+		mulAdd(shards[i], this.matrix[i], shards[:this.data])
+	}
+
+	return shards
+}
+
+// decode rebuilds the blob of size bytes from the shards, the missing ones
+// being nil
+func (this *reedSolomon) decode(shards [][]byte, size int) ([]byte, error) {
+	if len(shards) != this.data+this.parity {
+		return nil, errShardsNumbers
+	}
+
+	rows := [][]byte{}
+	present := [][]byte{}
+
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+
+		if len(present) > 0 && len(shard) != len(present[0]) {
+			return nil, errShardSize
+		}
+
+		rows = append(rows, this.matrix[i])
+		present = append(present, shard)
+
+		if len(present) == this.data {
+			break
+		}
+	}
+
+	if len(present) < this.data {
+		return nil, errTooFewShards
+	}
+
+	inverse, err := invert(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shardSize := len(present[0])
+	blob := make([]byte, shardSize*this.data)
+
+	for i := 0; i < this.data; i++ {
+		mulAdd(blob[i*shardSize:(i+1)*shardSize], inverse[i], present)
+	}
+
+	if size < 0 {
+		return nil, errErasureSize
+	}
+
+	if size > len(blob) {
+		return nil, errShardSize
+	}
+
+	return blob[:size], nil
+}
+
+// mulAdd adds to dst the sum of the shards times their coefficient
+func mulAdd(dst []byte, coefs []byte, shards [][]byte) {
+	for j, coef := range coefs {
+		if coef == 0 {
+			continue
+		}
+
+		for k, b := range shards[j] {
+			dst[k] ^= gfMul(coef, b)
+		}
+	}
+}
+
+// invert is a Gauss-Jordan elimination of a square matrix
+func invert(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	work := make([][]byte, n)
+
+	for i, row := range matrix {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], row)
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+
+		if pivot == n {
+			return nil, errShardsNumbers
+		}
+
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+
+		for k := range work[col] {
+			work[col][k] = gfMul(work[col][k], scale)
+		}
+
+		for row := 0; row < n; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+
+			factor := work[row][col]
+
+			for k := range work[row] {
+				work[row][k] ^= gfMul(factor, work[col][k])
+			}
+		}
+	}
+
+	res := make([][]byte, n)
+
+	for i := range work {
+		res[i] = work[i][n:]
+	}
+
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package dht
+
+import (
+	"time"
+)
+
+const (
+	REFRESH_INTERVAL = time.Hour
+	REFRESH_CHECK    = time.Minute
+)
+
+func (this *Dht) startRefresher(done chan struct{}) {
+	timer := time.NewTicker(REFRESH_CHECK)
+
+	go func() {
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				if this.running {
+					this.refreshBuckets()
+				}
+			}
+		}
+	}()
+}
+
+func (this *Dht) refreshBuckets() {
+	stale := this.routing.staleBuckets(this.tuned().RefreshInterval)
+
+	for _, bucketNb := range stale {
+		if !this.running {
+			return
+		}
+
+		this.fetchNodes(this.routing.randomHashInBucket(bucketNb))
+	}
+
+	if len(stale) > 0 {
+		this.logger.Debug("Refreshed buckets", len(stale))
+	}
+}
@@ -0,0 +1,87 @@
+package dht
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+const (
+	RENDEZVOUS_TTL     = time.Hour * 2
+	RENDEZVOUS_MAX_TTL = time.Hour * 72
+)
+
+// RegisterInst asks a node to keep the sender as a peer of a rendezvous
+// namespace for Ttl nanoseconds
+type RegisterInst struct {
+	Hash []byte
+	Ttl  int64
+}
+
+func (this *Dht) rendezvousHash(namespace string) []byte {
+	return this.NewHash([]byte("/rendezvous/" + namespace))
+}
+
+// Register announces this node as a peer of namespace to the nodes closest
+// to its hash, until ttl expires, RENDEZVOUS_TTL when 0. The registration is
+// not renewed: registering again before it expires keeps it alive
+func (this *Dht) Register(namespace string, ttl time.Duration) (int, error) {
+	if ttl <= 0 {
+		ttl = RENDEZVOUS_TTL
+	}
+
+	hash := this.rendezvousHash(namespace)
+	nodes := this.fetchNodes(hash)
+
+	if len(nodes) == 0 {
+		return 0, ErrNoNodes
+	}
+
+	answers := make(chan error, len(nodes))
+
+	for _, node := range nodes {
+		go func(node *Node) {
+			answers <- node.Register(hash, ttl)
+		}(node)
+	}
+
+	okNb := 0
+
+	for range nodes {
+		if err := <-answers; err == nil {
+			okNb++
+		}
+	}
+
+	return okNb, nil
+}
+
+// Discover returns the peers currently registered in namespace
+func (this *Dht) Discover(namespace string) ([]PacketContact, error) {
+	return this.FindProviders(this.rendezvousHash(namespace))
+}
+
+func (this *Node) Register(hash []byte, ttl time.Duration) error {
+	this.dht.logger.Debug(this, "< REGISTER", hex.EncodeToString(hash)[:16], ttl)
+
+	_, err := this.request(this.newPacket(COMMAND_REGISTER, []byte{}, RegisterInst{
+		Hash: hash,
+		Ttl:  int64(ttl),
+	}))
+
+	return err
+}
+
+func (this *Node) OnRegister(packet Packet) {
+	inst, _ := packet.Data.(RegisterInst)
+	ttl := time.Duration(inst.Ttl)
+
+	this.dht.logger.Debug(this, "> REGISTER", hex.EncodeToString(inst.Hash)[:16], ttl)
+
+	if ttl > RENDEZVOUS_MAX_TTL {
+		ttl = RENDEZVOUS_MAX_TTL
+	}
+
+	this.dht.addProvider(inst.Hash, packet.Header.Sender, ttl)
+
+	this.send(this.newPacket(COMMAND_NOOP, packet.Header.MessageHash, nil))
+}
@@ -0,0 +1,156 @@
+package dht
+
+import (
+	"encoding/hex"
+)
+
+type repairAnswer struct {
+	node  *Node
+	inst  StoreInst
+	found bool
+}
+
+// readRepair asks the K closest nodes for their record of the value, picks
+// the winner among the copies and writes its record back, with its
+// expiration, delete token and publisher, to the nodes holding another one.
+// The lookup of the value stopped at the first copy, so the closest nodes are
+// looked up again. A node without the value is left to the replication
+func (this *Dht) readRepair(hash []byte, key string, value interface{}) interface{} {
+	nodes := this.fetchNodesWith(hash, CAP_STORE)
+	answers := make(chan repairAnswer, len(nodes))
+
+	for _, node := range nodes {
+		go func(node *Node) {
+			inst, found, err := node.FetchRecord(hash)
+
+			if err != nil || !found || compare(inst.Hash, hash) != 0 || this.validate(hash, inst.Data) != nil {
+				answers <- repairAnswer{node: node}
+				return
+			}
+
+			if len(key) == 0 && this.checkContent(hash, inst.Data) != nil {
+				answers <- repairAnswer{node: node}
+				return
+			}
+
+			// a forged record must not win with its higher seq
+			if record, ok := inst.Data.(MutableRecord); ok && record.verify(hash, this.NewHash) != nil {
+				answers <- repairAnswer{node: node}
+				return
+			}
+
+			answers <- repairAnswer{node, inst, true}
+		}(node)
+	}
+
+	values := []interface{}{value}
+	holders := []repairAnswer{}
+
+	for range nodes {
+		answer := <-answers
+
+		if answer.found {
+			values = append(values, answer.inst.Data)
+			holders = append(holders, answer)
+		}
+	}
+
+	winner := values[this.selectValue(hash, key, values)]
+	winnerKey := valueKey(winner)
+
+	stale := []*Node{}
+
+	var record StoreInst
+
+	found := false
+
+	for _, holder := range holders {
+		if valueKey(holder.inst.Data) != winnerKey {
+			stale = append(stale, holder.node)
+		} else if !found {
+			record = holder.inst
+			found = true
+		}
+	}
+
+	// the copy of the lookup alone has no record to write back
+	if len(stale) == 0 || !found {
+		return winner
+	}
+
+	this.logger.Debug(hex.EncodeToString(hash), "Repairing", len(stale), "stale copies")
+
+	for _, node := range stale {
+		go node.Store(record)
+	}
+
+	return winner
+}
+
+// FetchRecord returns the whole record the node stores at hash, for read
+// repair to write it back as is
+func (this *Node) FetchRecord(hash []byte) (StoreInst, bool, error) {
+	this.dht.logger.Debug(this, "< FETCH RECORD", hex.EncodeToString(hash)[:16])
+
+	res, err := this.request(this.newPacket(COMMAND_FETCH_RECORD, []byte{}, hash))
+
+	if err != nil {
+		return StoreInst{}, false, err
+	}
+
+	if res.Header.Command != COMMAND_FOUND {
+		return StoreInst{}, false, nil
+	}
+
+	inst, ok := res.Data.(StoreInst)
+
+	if !ok {
+		return StoreInst{}, false, this.newError(ErrInvalidResponse, nil)
+	}
+
+	return inst, true, nil
+}
+
+func (this *Node) OnFetchRecord(packet Packet) {
+	hash, _ := packet.Data.([]byte)
+
+	this.dht.logger.Debug(this, "> FETCH RECORD", hex.EncodeToString(hash))
+
+	if inst, ok := this.dht.getLocal(hex.EncodeToString(hash)); ok {
+		this.Found(packet, inst)
+		return
+	}
+
+	this.OnFetchNodes(packet)
+}
+
+// selectValue picks the winner among the copies of a value: the highest seq
+// of the mutable records, the choice of the Validator otherwise
+func (this *Dht) selectValue(hash []byte, key string, values []interface{}) int {
+	best := -1
+
+	for i, value := range values {
+		record, ok := value.(MutableRecord)
+
+		if !ok {
+			best = -1
+			break
+		}
+
+		if best == -1 || record.Seq > values[best].(MutableRecord).Seq {
+			best = i
+		}
+	}
+
+	if best != -1 {
+		return best
+	}
+
+	res := this.selector(key).Select(hash, values)
+
+	if res < 0 || res >= len(values) {
+		return 0
+	}
+
+	return res
+}
@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CLOCK_SKEW = time.Minute
+)
+
+type ReplayGuard struct {
+	sync.Mutex
+	window time.Duration
+	seen   map[string]int64
+}
+
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		window: window,
+		seen:   make(map[string]int64),
+	}
+}
+
+// Check rejects packets sent outside the clock skew window, and the ones
+// already received from the same peer with the same sequence number
+func (this *ReplayGuard) Check(header PacketHeader) error {
+	if this.window < 0 {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+
+	if header.DateSent < now-int64(this.window) || header.DateSent > now+int64(this.window) {
+		return errors.New("Packet date out of window")
+	}
+
+	key := hex.EncodeToString(header.Sender.Hash) + ":" + strconv.FormatUint(header.Seq, 10)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.seen[key]; ok {
+		return errors.New("Replayed packet")
+	}
+
+	this.seen[key] = header.DateSent + int64(this.window)
+
+	return nil
+}
+
+func (this *ReplayGuard) Prune() {
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now().UnixNano()
+
+	for key, expire := range this.seen {
+		if expire < now {
+			delete(this.seen, key)
+		}
+	}
+}
+
+// nextSeq numbers the packets sent. The sequence starts from the clock, so
+// that a node restarted with the same identity does not reuse the numbers
+// its peers have already seen
+func (this *Dht) nextSeq() uint64 {
+	return atomic.AddUint64(&this.seq, 1)
+}
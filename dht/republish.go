@@ -0,0 +1,117 @@
+package dht
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	REPLICATE_INTERVAL = time.Hour
+	REPUBLISH_INTERVAL = time.Hour * 24
+)
+
+type republishTickers struct {
+	replicate *time.Ticker
+	republish *time.Ticker
+}
+
+// jitter spreads interval by up to a tenth either way, so that the nodes
+// started together do not all republish at once
+func jitter(interval time.Duration) time.Duration {
+	spread := int64(interval / 10)
+
+	if spread <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+func (this *Dht) startRepublisher(done chan struct{}) {
+	tuned := this.tuned()
+	replicateTimer := time.NewTicker(jitter(tuned.ReplicateInterval))
+	republishTimer := time.NewTicker(jitter(tuned.RepublishInterval))
+
+	this.Lock()
+	this.tickers = republishTickers{replicate: replicateTimer, republish: republishTimer}
+	this.Unlock()
+
+	go func() {
+		defer replicateTimer.Stop()
+		defer republishTimer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-replicateTimer.C:
+				if this.running {
+					this.replicate()
+				}
+			case <-republishTimer.C:
+				if this.running {
+					this.republish()
+					this.reprovide()
+				}
+			}
+		}
+	}()
+}
+
+// storedValues are the values to replicate: the erasure shards stay on the
+// node they were stored on, until their publisher republishes them
+func (this *Dht) storedValues() []StoreInst {
+	res := []StoreInst{}
+
+	this.storage().ForEach(func(k string, inst StoreInst) bool {
+		if !inst.Expired() && !inst.Shard {
+			res = append(res, inst)
+		}
+
+		return true
+	})
+
+	return res
+}
+
+func (this *Dht) publishedValues() []StoreInst {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := make([]StoreInst, 0, len(this.published))
+
+	for _, inst := range this.published {
+		if !inst.Expired() {
+			res = append(res, inst)
+		}
+	}
+
+	return res
+}
+
+// replicate sends every locally stored value to the current K closest nodes
+func (this *Dht) replicate() {
+	store := this.storedValues()
+
+	for _, inst := range store {
+		this.storeAt(inst)
+	}
+
+	this.logger.Debug("Replicated", len(store))
+}
+
+// republish stores again the values this node is the original publisher of,
+// and the shards of the erasure coded ones
+func (this *Dht) republish() {
+	published := this.publishedValues()
+
+	for _, inst := range published {
+		if manifest, ok := inst.Data.(ErasureManifest); ok {
+			this.republishShards(inst, manifest)
+		}
+
+		this.storeAt(inst)
+	}
+
+	this.logger.Debug("Republished", len(published))
+}
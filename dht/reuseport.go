@@ -0,0 +1,19 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package dht
+
+import (
+	"syscall"
+)
+
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	var err error
+
+	if cerr := conn.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort(), 1)
+	}); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
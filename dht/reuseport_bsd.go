@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package dht
+
+import (
+	"syscall"
+)
+
+func soReusePort() int {
+	return syscall.SO_REUSEPORT
+}
@@ -0,0 +1,15 @@
+package dht
+
+import (
+	"runtime"
+	"strings"
+)
+
+// SO_REUSEPORT is missing from syscall on linux, where mips has its own value
+func soReusePort() int {
+	if strings.HasPrefix(runtime.GOARCH, "mips") {
+		return 0x200
+	}
+
+	return 0xf
+}
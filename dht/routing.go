@@ -1,24 +1,50 @@
 package dht
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
 	"sync"
+	"time"
+
+	"github.com/champii/go-dht/dht/kad"
 )
 
 type Routing struct {
 	sync.RWMutex
-	buckets [][]PacketContact
-	dht     *Dht
+	bits         int
+	buckets      [][]PacketContact
+	replacements [][]PacketContact
+	refreshed    []time.Time
+	pinging      map[int]bool
+	lastSeen     map[string]time.Time
+	rtt          map[string]time.Duration
+	estimates    map[string]rttEstimate
+	dht          *Dht
 }
 
-func NewRouting() *Routing {
-	buckets := make([][]PacketContact, HASH_SIZE)
+// NewRouting makes a table of one bucket per bit of the node IDs
+func NewRouting(bits int) *Routing {
+	buckets := make([][]PacketContact, bits)
+	refreshed := make([]time.Time, bits)
+
+	now := time.Now()
+
+	for i := range refreshed {
+		refreshed[i] = now
+	}
 
 	return &Routing{
-		buckets: buckets,
+		bits:         bits,
+		buckets:      buckets,
+		replacements: make([][]PacketContact, bits),
+		refreshed:    refreshed,
+		pinging:      make(map[int]bool),
+		lastSeen:     make(map[string]time.Time),
+		rtt:          make(map[string]time.Duration),
+		estimates:    make(map[string]rttEstimate),
 	}
 }
 
@@ -48,48 +74,74 @@ func (this *Routing) Size() int {
 	return count
 }
 
+// countSameBit is the bucket of hash: the number of leading bits it shares
+// with the node, the most significant bit of each byte first
 func (this *Routing) countSameBit(hash []byte) int {
 	if len(hash) == 0 {
 		return 0
 	}
 
-	count := 0
-	for i, v := range this.dht.hash {
-		for j := 0; j < 8; j++ {
-			tmpOwn := v & (0x1 << uint(j))
-			tmp := hash[i] & (0x1 << uint(j))
-			if tmpOwn == tmp {
-				count++
-			} else {
-				return count
-			}
-		}
+	return min(kad.CommonPrefixLen(this.dht.hash, hash), this.bits)
+}
+
+func (this *Routing) randomHashInBucket(bucketNb int) []byte {
+	res := this.dht.newRandomHash()
+
+	for i := 0; i < bucketNb && i < this.bits; i++ {
+		mask := byte(0x80 >> uint(i%8))
+		res[i/8] = (res[i/8] &^ mask) | (this.dht.hash[i/8] & mask)
 	}
 
-	return count
+	if bucketNb < this.bits {
+		mask := byte(0x80 >> uint(bucketNb%8))
+		res[bucketNb/8] = (res[bucketNb/8] &^ mask) | (^this.dht.hash[bucketNb/8] & mask)
+	}
+
+	return res
 }
 
-func (this *Routing) nCopy(dest, src []byte, count int) []byte {
-	i := 0
-	for i < count {
-		if count-i%8 == 0 {
-			dest[i/8] = src[i/8]
-			i += 8
-			continue
-		}
+func (this *Routing) Touch(hash []byte) {
+	bucketNb := this.countSameBit(hash)
+
+	if bucketNb >= this.bits {
+		return
+	}
+
+	this.Lock()
+	this.refreshed[bucketNb] = time.Now()
+	this.Unlock()
+}
 
-		destTmp := dest[i/8]
+func (this *Routing) staleBuckets(interval time.Duration) []int {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []int{}
 
-		for j := 0; j < 8 && i+j < count; j++ {
-			destTmp |= src[i/8] & (0x1 << uint(j))
+	for i, refreshed := range this.refreshed {
+		if time.Since(refreshed) > interval {
+			res = append(res, i)
 		}
+	}
 
-		dest[i/8] = destTmp
+	return res
+}
+
+func (this *Routing) staleContacts(interval time.Duration) []PacketContact {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []PacketContact{}
 
-		i += 8
+	for _, bucket := range this.buckets {
+		for _, contact := range bucket {
+			if time.Since(this.lastSeen[hex.EncodeToString(contact.Hash)]) > interval {
+				res = append(res, contact)
+			}
+		}
 	}
 
-	return dest
+	return res
 }
 
 func (this *Routing) distanceBetwin(hash1, hash2 []byte) int {
@@ -102,8 +154,16 @@ func (this *Routing) distanceBetwin(hash1, hash2 []byte) int {
 	return res
 }
 
+// AddNode keeps the buckets sorted from least to most recently seen.
+// When a bucket is full, its least recently seen contact is pinged and
+// only evicted in favor of the new one if it does not answer
 func (this *Routing) AddNode(contact PacketContact) {
+	if this.dht.isContactBanned(contact) || !this.dht.validContact(contact) {
+		return
+	}
+
 	if _, err := this.GetNode(contact.Hash); err == nil {
+		this.moveToTail(contact)
 		return
 	}
 
@@ -113,16 +173,117 @@ func (this *Routing) AddNode(contact PacketContact) {
 
 	bucketNb := this.countSameBit(contact.Hash)
 
+	if bucketNb == this.bits {
+		return
+	}
+
 	this.Lock()
-	if bucketNb == HASH_SIZE || len(this.buckets[bucketNb]) > BUCKET_SIZE {
+	if len(this.buckets[bucketNb]) >= this.dht.tuned().K {
+		this.addReplacement(bucketNb, contact)
+
+		if !this.pinging[bucketNb] {
+			this.pinging[bucketNb] = true
+
+			go this.pingBeforeEvict(bucketNb, this.buckets[bucketNb][0], contact)
+		}
+
 		this.Unlock()
 		return
 	}
 
 	this.buckets[bucketNb] = append(this.buckets[bucketNb], contact)
+	this.refreshed[bucketNb] = time.Now()
+	this.lastSeen[hex.EncodeToString(contact.Hash)] = time.Now()
 	this.Unlock()
 
 	this.dht.logger.Debug(contact, "+ Add Routing. Size: ", this.Size())
+	this.dht.emit(EVENT_PEER_ADDED, contact, contact.Hash)
+
+	go this.dht.transferTo(contact)
+}
+
+func (this *Routing) moveToTail(contact PacketContact) {
+	bucketNb := this.countSameBit(contact.Hash)
+
+	this.Lock()
+	defer this.Unlock()
+
+	bucket := this.buckets[bucketNb]
+
+	for i, n := range bucket {
+		if compare(n.Hash, contact.Hash) == 0 {
+			this.buckets[bucketNb] = append(append(bucket[:i:i], bucket[i+1:]...), n)
+			this.lastSeen[hex.EncodeToString(contact.Hash)] = time.Now()
+			return
+		}
+	}
+}
+
+func (this *Routing) pingBeforeEvict(bucketNb int, oldest PacketContact, contact PacketContact) {
+	alive := false
+
+	if addr, err := this.dht.resolve(oldest); err == nil {
+		node := NewNodeContact(this.dht, addr, oldest)
+
+		// evicted below, without waiting for its circuit to open
+		alive = node.Ping() == nil
+	}
+
+	this.Lock()
+	delete(this.pinging, bucketNb)
+	this.Unlock()
+
+	if alive {
+		return
+	}
+
+	this.dht.logger.Debug(oldest, "- Evicted for", contact)
+
+	this.RemoveNode(oldest)
+}
+
+// addReplacement keeps the most recently seen contacts of a full bucket,
+// to be promoted when one of its contacts is removed. Must be called locked
+func (this *Routing) addReplacement(bucketNb int, contact PacketContact) {
+	replacements := this.removeFrom(this.replacements[bucketNb], contact)
+
+	replacements = append(replacements, contact)
+
+	if len(replacements) > this.dht.tuned().K {
+		replacements = replacements[1:]
+	}
+
+	this.replacements[bucketNb] = replacements
+}
+
+func (this *Routing) removeFrom(bucket []PacketContact, contact PacketContact) []PacketContact {
+	for i, n := range bucket {
+		if compare(n.Hash, contact.Hash) == 0 {
+			return append(bucket[:i:i], bucket[i+1:]...)
+		}
+	}
+
+	return bucket
+}
+
+// promote moves the most recently seen replacement into its bucket. Must be
+// called locked
+func (this *Routing) promote(bucketNb int) bool {
+	replacements := this.replacements[bucketNb]
+
+	if len(replacements) == 0 {
+		return false
+	}
+
+	contact := replacements[len(replacements)-1]
+
+	this.replacements[bucketNb] = replacements[:len(replacements)-1]
+	this.buckets[bucketNb] = append(this.buckets[bucketNb], contact)
+	this.lastSeen[hex.EncodeToString(contact.Hash)] = time.Now()
+
+	this.dht.logger.Debug(contact, "+ Promoted replacement")
+
+	return true
 }
 
 func (this *Routing) RemoveNode(contact PacketContact) {
@@ -144,43 +305,51 @@ func (this *Routing) RemoveNode(contact PacketContact) {
 				this.buckets[bucketNb] = append(this.buckets[bucketNb][:i], this.buckets[bucketNb][i+1:]...)
 			}
 
+			delete(this.lastSeen, hex.EncodeToString(n.Hash))
+			delete(this.rtt, hex.EncodeToString(n.Hash))
+			delete(this.estimates, hex.EncodeToString(n.Hash))
+
+			if this.promote(bucketNb) {
+				size++
+			}
+
 			this.dht.logger.Debug(n, "- Del Routing. Size: ", size)
+			this.dht.emit(EVENT_PEER_REMOVED, n, n.Hash)
 
 			if size == 0 && len(this.dht.options.BootstrapAddr) != 0 {
 				this.dht.logger.Critical("Empty routing table. Stoping.")
 
-				this.dht.Stop()
+				go this.dht.Stop(context.Background())
 			}
 
 			return
 		}
 	}
 
-	// this.dht.logger.Warning(contact, "x Cannot find node")
+	this.replacements[bucketNb] = this.removeFrom(this.replacements[bucketNb], contact)
 }
 
 func (this *Routing) FindNode(hash []byte) []PacketContact {
 	res := []PacketContact{}
+	k := this.dht.tuned().K
 
-	size := this.Size()
+	if this.Size() < k {
+		return this.GetAllNodes()
+	}
 
 	this.RLock()
 	defer this.RUnlock()
 
-	if size < BUCKET_SIZE {
-		return this.GetAllNodes()
-	}
-
 	bucketNb := this.countSameBit(hash)
 
 	// get neighbours when asking for self
-	if bucketNb == HASH_SIZE {
+	if bucketNb == this.bits {
 		bucketNb--
 	}
 
-	for len(res) < BUCKET_SIZE && bucketNb < HASH_SIZE && bucketNb >= 0 {
+	for len(res) < k && bucketNb < this.bits && bucketNb >= 0 {
 		for _, node := range this.buckets[bucketNb] {
-			if len(res) == BUCKET_SIZE {
+			if len(res) == k {
 				return res
 			}
 
@@ -193,9 +362,9 @@ func (this *Routing) FindNode(hash []byte) []PacketContact {
 	bucketNb = this.countSameBit(hash) + 1
 
 	// if result bucket not full, add some more nodes
-	for len(res) < BUCKET_SIZE && bucketNb >= 0 {
+	for len(res) < k && bucketNb < this.bits {
 		for _, node := range this.buckets[bucketNb] {
-			if len(res) == BUCKET_SIZE {
+			if len(res) == k {
 				return res
 			}
 
@@ -211,7 +380,7 @@ func (this *Routing) FindNode(hash []byte) []PacketContact {
 func (this *Routing) GetNode(hash []byte) (PacketContact, error) {
 	bucketNb := this.countSameBit(hash)
 
-	if bucketNb == HASH_SIZE {
+	if bucketNb == this.bits {
 		return PacketContact{}, errors.New("Cannot add own")
 	}
 
@@ -224,7 +393,7 @@ func (this *Routing) GetNode(hash []byte) (PacketContact, error) {
 		}
 	}
 
-	return PacketContact{}, errors.New("Not found")
+	return PacketContact{}, ErrNotFound
 }
 
 func (this *Routing) IsBestStorage(hash []byte) (bool, []PacketContact) {
@@ -255,8 +424,8 @@ func (this *Routing) GetAllNodes() []PacketContact {
 	this.RLock()
 	defer this.RUnlock()
 
-	for i := 0; i < BUCKET_SIZE; i++ {
-		for _, node := range this.buckets[i] {
+	for _, bucket := range this.buckets {
+		for _, node := range bucket {
 			res = append(res, node)
 		}
 	}
@@ -264,17 +433,24 @@ func (this *Routing) GetAllNodes() []PacketContact {
 	return res
 }
 
+// Closest returns the n contacts of the table closest to hash, closest first
+func (this *Routing) Closest(hash []byte, n int) []PacketContact {
+	return kad.Closest(this.GetAllNodes(), hash, n, func(contact PacketContact) []byte {
+		return contact.Hash
+	})
+}
+
 func (this *Routing) GetByAddr(addr string) (PacketContact, error) {
 	this.RLock()
 	defer this.RUnlock()
 
-	for i := 0; i < BUCKET_SIZE; i++ {
-		for _, node := range this.buckets[i] {
+	for _, bucket := range this.buckets {
+		for _, node := range bucket {
 			if addr == node.Addr {
 				return node, nil
 			}
 		}
 	}
 
-	return PacketContact{}, errors.New("Not found")
+	return PacketContact{}, ErrNotFound
 }
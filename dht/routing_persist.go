@@ -0,0 +1,106 @@
+package dht
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+const (
+	ROUTING_STALE = time.Minute * 15
+)
+
+type RoutingRecord struct {
+	Contact  PacketContact
+	LastSeen int64
+}
+
+func (this *Routing) records() []RoutingRecord {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []RoutingRecord{}
+
+	for _, bucket := range this.buckets {
+		for _, contact := range bucket {
+			res = append(res, RoutingRecord{
+				Contact:  contact,
+				LastSeen: this.lastSeen[hex.EncodeToString(contact.Hash)].UnixNano(),
+			})
+		}
+	}
+
+	return res
+}
+
+func (this *Dht) saveRouting() error {
+	if len(this.options.RoutingPath) == 0 || this.routing.Size() == 0 {
+		return nil
+	}
+
+	file, err := os.Create(this.options.RoutingPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return gob.NewEncoder(file).Encode(this.routing.records())
+}
+
+// loadRouting restores the saved contacts, pinging the stale ones before
+// adding them back. Returns the number of restored contacts
+func (this *Dht) loadRouting() int {
+	if len(this.options.RoutingPath) == 0 {
+		return 0
+	}
+
+	file, err := os.Open(this.options.RoutingPath)
+
+	if err != nil {
+		return 0
+	}
+
+	defer file.Close()
+
+	records := []RoutingRecord{}
+
+	if err := gob.NewDecoder(file).Decode(&records); err != nil {
+		this.logger.Warning("Cannot load routing table", err)
+
+		return 0
+	}
+
+	answers := make(chan bool, len(records))
+
+	for _, record := range records {
+		if time.Since(time.Unix(0, record.LastSeen)) < ROUTING_STALE {
+			this.routing.AddNode(record.Contact)
+			answers <- true
+
+			continue
+		}
+
+		go func(contact PacketContact) {
+			addr, err := this.resolve(contact)
+
+			if err != nil {
+				answers <- false
+				return
+			}
+
+			// the pong adds the node to the routing table
+			answers <- NewNodeContact(this, addr, contact).Ping() == nil
+		}(record.Contact)
+	}
+
+	for range records {
+		<-answers
+	}
+
+	this.logger.Info("Restored routing table", this.routing.Size(), "of", len(records))
+
+	return this.routing.Size()
+}
@@ -0,0 +1,101 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type RoutingContact struct {
+	Hash     string        `json:"hash"`
+	Addr     string        `json:"addr"`
+	LastSeen time.Time     `json:"last_seen"`
+	RTT      time.Duration `json:"rtt"`
+}
+
+type RoutingBucket struct {
+	Index    int              `json:"index"`
+	Contacts []RoutingContact `json:"contacts"`
+}
+
+type RoutingTable struct {
+	Hash    string          `json:"hash"`
+	Size    int             `json:"size"`
+	Buckets []RoutingBucket `json:"buckets"`
+}
+
+func (this *Routing) UpdateRTT(hash []byte, rtt time.Duration) {
+	key := hex.EncodeToString(hash)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.lastSeen[key]; !ok {
+		return
+	}
+
+	this.sampleRTT(key, rtt)
+}
+
+func (this *Routing) Snapshot() RoutingTable {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := RoutingTable{
+		Hash:    hex.EncodeToString(this.dht.hash),
+		Buckets: []RoutingBucket{},
+	}
+
+	for i, bucket := range this.buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+
+		routingBucket := RoutingBucket{
+			Index:    i,
+			Contacts: []RoutingContact{},
+		}
+
+		for _, contact := range bucket {
+			key := hex.EncodeToString(contact.Hash)
+
+			routingBucket.Contacts = append(routingBucket.Contacts, RoutingContact{
+				Hash:     key,
+				Addr:     contact.Addr,
+				LastSeen: this.lastSeen[key],
+				RTT:      this.rtt[key],
+			})
+		}
+
+		res.Size += len(bucket)
+		res.Buckets = append(res.Buckets, routingBucket)
+	}
+
+	return res
+}
+
+func (this RoutingTable) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s: %d contacts\n", this.Hash, this.Size)
+
+	for _, bucket := range this.Buckets {
+		fmt.Fprintf(&b, "bucket %d:\n", bucket.Index)
+
+		for _, contact := range bucket.Contacts {
+			fmt.Fprintf(&b, "  %s %s seen %s ago, rtt %s\n", contact.Hash, contact.Addr, time.Since(contact.LastSeen).Round(time.Second), contact.RTT)
+		}
+	}
+
+	return b.String()
+}
+
+func (this RoutingTable) JSON() ([]byte, error) {
+	return json.Marshal(this)
+}
+
+func (this *Dht) RoutingTable() RoutingTable {
+	return this.routing.Snapshot()
+}
@@ -0,0 +1,167 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errUnknownRpc = errors.New("Unknown RPC")
+	errRpcName    = errors.New("Missing RPC name")
+)
+
+// RpcHandler answers the RPC req sent by from. The error returned is sent
+// back to the caller as a RpcError
+type RpcHandler func(ctx context.Context, from PacketContact, req []byte) ([]byte, error)
+
+// RpcAnswer is the answer to an RPC, either its result or the message of its
+// error
+type RpcAnswer struct {
+	Data  []byte
+	Error string
+}
+
+// RpcError is the error of a remote RPC handler, unknown names included
+type RpcError struct {
+	Name    string
+	Message string
+}
+
+func (this RpcError) Error() string {
+	return this.Name + ": " + this.Message
+}
+
+type rpcRegistry struct {
+	sync.RWMutex
+	handlers map[string]RpcHandler
+}
+
+func newRpcRegistry() *rpcRegistry {
+	return &rpcRegistry{
+		handlers: make(map[string]RpcHandler),
+	}
+}
+
+// RegisterRPC makes the handler answer the RPCs called name, replacing the
+// previous one. A nil handler unregisters it
+func (this *Dht) RegisterRPC(name string, handler RpcHandler) {
+	this.rpcs.Lock()
+	defer this.rpcs.Unlock()
+
+	if handler == nil {
+		delete(this.rpcs.handlers, name)
+
+		return
+	}
+
+	this.rpcs.handlers[name] = handler
+}
+
+func (this *Dht) rpcHandler(name string) (RpcHandler, bool) {
+	this.rpcs.RLock()
+	defer this.rpcs.RUnlock()
+
+	handler, ok := this.rpcs.handlers[name]
+
+	return handler, ok
+}
+
+// Call sends the RPC name to peer and returns its answer. The error of the
+// remote handler is returned as a *PeerError of kind ErrRpc, wrapping its
+// RpcError. The deadline of ctx bounds the whole call, retries included, and
+// ending ctx ends the request in flight
+func (this *Dht) Call(ctx context.Context, peer PacketContact, name string, req []byte) ([]byte, error) {
+	if len(name) == 0 {
+		return nil, errRpcName
+	}
+
+	addr, err := this.resolve(peer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node := NewNodeContact(this, addr, peer).withContext(ctx)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		node = node.WithTimeout(time.Until(deadline), 1)
+	}
+
+	return node.Call(name, req)
+}
+
+func (this *Node) Call(name string, req []byte) ([]byte, error) {
+	this.dht.logger.Debug(this, "< RPC", name)
+
+	if req == nil {
+		req = []byte{}
+	}
+
+	packet := this.newPacket(COMMAND_RPC, []byte{}, req)
+	packet.Header.Rpc = name
+
+	res, err := this.request(packet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	answer, ok := res.Data.(RpcAnswer)
+
+	if !ok {
+		return nil, this.newError(ErrInvalidResponse, nil)
+	}
+
+	if len(answer.Error) > 0 {
+		return nil, this.newError(ErrRpc, RpcError{Name: name, Message: answer.Error})
+	}
+
+	return answer.Data, nil
+}
+
+// OnCall runs the handler in its own goroutine, as it may call other nodes
+// in turn
+func (this *Node) OnCall(packet Packet) {
+	name := packet.Header.Rpc
+	req, _ := packet.Data.([]byte)
+
+	this.dht.logger.Debug(this, "> RPC", name, hex.EncodeToString(packet.Header.MessageHash)[:16])
+
+	handler, ok := this.dht.rpcHandler(name)
+
+	if !ok {
+		this.answerCall(packet, nil, errUnknownRpc)
+
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), this.dht.options.RpcTimeout)
+		defer cancel()
+
+		res, err := handler(ctx, this.contact, req)
+
+		this.answerCall(packet, res, err)
+	}()
+}
+
+func (this *Node) answerCall(packet Packet, res []byte, err error) {
+	answer := RpcAnswer{Data: res}
+
+	if err != nil {
+		answer = RpcAnswer{Error: err.Error()}
+	}
+
+	this.dht.logger.Debug(this, "< RPC ANSWER", packet.Header.Rpc, answer.Error)
+
+	this.send(this.newPacket(COMMAND_RPC_ANSWER, packet.Header.MessageHash, answer))
+}
+
+func (this *Node) OnCallAnswer(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> RPC ANSWER")
+
+	done.c <- packet
+}
@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+const (
+	MIN_RPC_TIMEOUT = time.Millisecond * 100
+	MAX_RTO_BACKOFF = 6
+)
+
+// rttEstimate completes the smoothed RTT of a peer with its variation, and
+// the number of timeouts in a row since its last answer
+type rttEstimate struct {
+	variance time.Duration
+	backoff  uint
+}
+
+// sampleRTT updates the estimate of a peer like TCP does (RFC 6298), the
+// smoothed RTT being kept in rtt
+func (this *Routing) sampleRTT(key string, rtt time.Duration) {
+	srtt, ok := this.rtt[key]
+
+	if !ok {
+		this.rtt[key] = rtt
+		this.estimates[key] = rttEstimate{variance: rtt / 2}
+
+		return
+	}
+
+	delta := srtt - rtt
+
+	if delta < 0 {
+		delta = -delta
+	}
+
+	this.rtt[key] = (srtt*7 + rtt) / 8
+	this.estimates[key] = rttEstimate{variance: (this.estimates[key].variance*3 + delta) / 4}
+}
+
+// RTO returns the retransmission timeout of a peer, SRTT + 4 * RTTVAR
+// doubled at each timeout in a row. It is false for the peers never
+// measured, or not answering anymore
+func (this *Routing) RTO(hash []byte) (time.Duration, bool) {
+	key := hex.EncodeToString(hash)
+
+	this.RLock()
+	defer this.RUnlock()
+
+	srtt, ok := this.rtt[key]
+	estimate := this.estimates[key]
+
+	if !ok || estimate.backoff >= MAX_RTO_BACKOFF {
+		return 0, false
+	}
+
+	return (srtt + 4*estimate.variance) << estimate.backoff, true
+}
+
+// backoffRTO doubles the timeout of a peer after a timeout, until it answers
+func (this *Routing) backoffRTO(hash []byte) {
+	key := hex.EncodeToString(hash)
+
+	this.Lock()
+	defer this.Unlock()
+
+	estimate, ok := this.estimates[key]
+
+	if !ok || estimate.backoff >= MAX_RTO_BACKOFF {
+		return
+	}
+
+	estimate.backoff++
+	this.estimates[key] = estimate
+}
+
+// rpcTimeout is the timeout set with WithTimeout, or the RTO of the peer
+// bounded by MinRpcTimeout and RpcTimeout. RpcTimeout is used until the
+// peer is measured, or with NoAdaptiveTimeout
+func (this *Node) rpcTimeout() time.Duration {
+	if this.timeout > 0 {
+		return this.timeout
+	}
+
+	options := this.dht.options
+
+	if options.NoAdaptiveTimeout {
+		return options.RpcTimeout
+	}
+
+	rto, ok := this.dht.routing.RTO(this.contact.Hash)
+
+	if !ok || rto > options.RpcTimeout {
+		return options.RpcTimeout
+	}
+
+	if rto < options.MinRpcTimeout {
+		return options.MinRpcTimeout
+	}
+
+	return rto
+}
@@ -0,0 +1,364 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SESSION_BUFFER  = 64
+	SESSION_BACKOFF = time.Millisecond * 50
+)
+
+const (
+	SESSION_OPEN = iota
+	SESSION_DATA
+	SESSION_CLOSE
+)
+
+var (
+	errSessionClosed  = errors.New("Session closed")
+	errUnknownSession = errors.New("Unknown session")
+)
+
+// SessionMsg is a message of a session, numbered by its sender from the open
+// one, numbered 0
+type SessionMsg struct {
+	Id   []byte
+	Name string
+	Seq  uint64
+	Type int
+	Data []byte
+}
+
+// SessionAck acknowledges a message. A message the receiver has no room for
+// yet is not Ok, and sent again
+type SessionAck struct {
+	Seq   uint64
+	Ok    bool
+	Error string
+}
+
+// SessionHandler serves a session a peer opened
+type SessionHandler func(*Session)
+
+// Session is a logical channel between two nodes, opened by one of them with
+// OpenSession. Both ends send messages, each one acknowledged before the
+// next one is sent, and receive them in order
+type Session struct {
+	sending  chan struct{}
+	dht      *Dht
+	node     *Node
+	id       []byte
+	name     string
+	sent     atomic.Uint64
+	received uint64
+	incoming chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+type sessionRegistry struct {
+	sync.RWMutex
+	handlers map[string]SessionHandler
+	sessions map[string]*Session
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		handlers: make(map[string]SessionHandler),
+		sessions: make(map[string]*Session),
+	}
+}
+
+func newSession(dht *Dht, node *Node, id []byte, name string) *Session {
+	return &Session{
+		sending:  make(chan struct{}, 1),
+		dht:      dht,
+		node:     node,
+		id:       id,
+		name:     name,
+		incoming: make(chan []byte, SESSION_BUFFER),
+		closed:   make(chan struct{}),
+	}
+}
+
+// HandleSession makes the handler serve the sessions opened with name,
+// replacing the previous one. A nil handler unregisters it
+func (this *Dht) HandleSession(name string, handler SessionHandler) {
+	this.channels.Lock()
+	defer this.channels.Unlock()
+
+	if handler == nil {
+		delete(this.channels.handlers, name)
+
+		return
+	}
+
+	this.channels.handlers[name] = handler
+}
+
+// OpenSession opens a session with peer, served by its handler for name
+func (this *Dht) OpenSession(ctx context.Context, peer PacketContact, name string) (*Session, error) {
+	addr, err := this.resolve(peer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := this.newRandomHash()
+
+	session := newSession(this, NewNodeContact(this, addr, peer), id, name)
+
+	this.channels.Lock()
+	this.channels.sessions[session.key()] = session
+	this.channels.Unlock()
+
+	if err := session.send(ctx, SessionMsg{Name: name, Type: SESSION_OPEN}); err != nil {
+		session.closeLocal()
+
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (this *Session) key() string {
+	return hex.EncodeToString(this.node.contact.Hash) + "/" + hex.EncodeToString(this.id)
+}
+
+func (this *Session) Name() string {
+	return this.name
+}
+
+func (this *Session) Peer() PacketContact {
+	return this.node.contact
+}
+
+// Send sends data and waits for the peer to acknowledge it, until ctx is
+// done or the session closed
+func (this *Session) Send(ctx context.Context, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+
+	return this.send(ctx, SessionMsg{Type: SESSION_DATA, Data: data})
+}
+
+// send waits for the previous message to be acknowledged, then sends msg
+// again until the peer has room for it
+func (this *Session) send(ctx context.Context, msg SessionMsg) error {
+	select {
+	case this.sending <- struct{}{}:
+	case <-this.closed:
+		return errSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	defer func() { <-this.sending }()
+
+	msg.Id = this.id
+
+	if msg.Type != SESSION_OPEN {
+		msg.Seq = this.sent.Load() + 1
+	}
+
+	for {
+		ack, err := this.node.withContext(ctx).SessionMsg(msg)
+
+		// a rate limited message is sent again when the peer says so
+		var busy BusyError
+
+		if errors.As(err, &busy) {
+			if err := this.wait(ctx, busy.RetryAfter); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(ack.Error) > 0 {
+			this.closeLocal()
+
+			return this.node.newError(ErrProtocol, errors.New(ack.Error))
+		}
+
+		if ack.Ok {
+			this.sent.Store(msg.Seq)
+
+			return nil
+		}
+
+		if err := this.wait(ctx, SESSION_BACKOFF); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps for delay, unless ctx is done or the session closed first
+func (this *Session) wait(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-this.closed:
+		return errSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv returns the next message of the peer, io.EOF once the session is
+// closed and the messages received before are read
+func (this *Session) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case data, ok := <-this.incoming:
+		if !ok {
+			return nil, io.EOF
+		}
+
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close ends the session on this side, a Send still waiting for its ack
+// giving up, then tells the peer once
+func (this *Session) Close() error {
+	select {
+	case <-this.closed:
+		return nil
+	default:
+	}
+
+	this.closeLocal()
+
+	_, err := this.node.SessionMsg(SessionMsg{
+		Id:   this.id,
+		Seq:  this.sent.Load() + 1,
+		Type: SESSION_CLOSE,
+	})
+
+	return err
+}
+
+func (this *Session) closeLocal() {
+	this.once.Do(func() {
+		this.dht.channels.Lock()
+		defer this.dht.channels.Unlock()
+
+		delete(this.dht.channels.sessions, this.key())
+
+		close(this.closed)
+		close(this.incoming)
+	})
+}
+
+// deliver queues a message of the peer, unless it was already, or there is
+// no room for it yet
+func (this *Session) deliver(msg SessionMsg) SessionAck {
+	this.dht.channels.Lock()
+	defer this.dht.channels.Unlock()
+
+	select {
+	case <-this.closed:
+		return SessionAck{Seq: msg.Seq, Error: errSessionClosed.Error()}
+	default:
+	}
+
+	if msg.Seq <= this.received {
+		return SessionAck{Seq: msg.Seq, Ok: true}
+	}
+
+	if msg.Seq != this.received+1 {
+		return SessionAck{Seq: msg.Seq}
+	}
+
+	select {
+	case this.incoming <- msg.Data:
+		this.received = msg.Seq
+
+		return SessionAck{Seq: msg.Seq, Ok: true}
+	default:
+		return SessionAck{Seq: msg.Seq}
+	}
+}
+
+func (this *Node) SessionMsg(msg SessionMsg) (SessionAck, error) {
+	this.dht.logger.Debug(this, "< SESSION", hex.EncodeToString(msg.Id)[:16], msg.Type, msg.Seq)
+
+	res, err := this.request(this.newPacket(COMMAND_SESSION, []byte{}, msg))
+
+	if err != nil {
+		return SessionAck{}, err
+	}
+
+	ack, ok := res.Data.(SessionAck)
+
+	if !ok {
+		return SessionAck{}, this.newError(ErrInvalidResponse, nil)
+	}
+
+	return ack, nil
+}
+
+func (this *Node) OnSessionMsg(packet Packet) {
+	msg, _ := packet.Data.(SessionMsg)
+
+	this.dht.logger.Debug(this, "> SESSION", hex.EncodeToString(msg.Id)[:16], msg.Type, msg.Seq)
+
+	key := hex.EncodeToString(this.contact.Hash) + "/" + hex.EncodeToString(msg.Id)
+
+	this.dht.channels.RLock()
+	session, ok := this.dht.channels.sessions[key]
+	handler, known := this.dht.channels.handlers[msg.Name]
+	this.dht.channels.RUnlock()
+
+	ack := SessionAck{Seq: msg.Seq, Ok: true}
+
+	switch {
+	case msg.Type == SESSION_OPEN && ok:
+		// the open message sent again
+	case msg.Type == SESSION_OPEN && !known:
+		ack = SessionAck{Seq: msg.Seq, Error: errUnknownSession.Error()}
+	case msg.Type == SESSION_OPEN:
+		session = newSession(this.dht, this, msg.Id, msg.Name)
+
+		this.dht.channels.Lock()
+		this.dht.channels.sessions[key] = session
+		this.dht.channels.Unlock()
+
+		go handler(session)
+	case !ok && msg.Type == SESSION_CLOSE:
+		// already closed
+	case !ok:
+		ack = SessionAck{Seq: msg.Seq, Error: errSessionClosed.Error()}
+	case msg.Type == SESSION_CLOSE:
+		session.closeLocal()
+	default:
+		ack = session.deliver(msg)
+	}
+
+	this.dht.logger.Debug(this, "< SESSION ACK", ack.Seq, ack.Ok)
+
+	this.send(this.newPacket(COMMAND_SESSION_ACK, packet.Header.MessageHash, ack))
+}
+
+func (this *Node) OnSessionAck(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> SESSION ACK")
+
+	done.c <- packet
+}
@@ -0,0 +1,50 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+)
+
+func NewIdentity() (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return pub, priv
+}
+
+// sign appends the signature of the encoded packet to it
+func (this *Dht) sign(blob []byte) []byte {
+	return append(blob, ed25519.Sign(this.privateKey, blob)...)
+}
+
+func splitSignature(blob []byte) ([]byte, []byte, error) {
+	if len(blob) <= ed25519.SignatureSize {
+		return nil, nil, errors.New("Missing signature")
+	}
+
+	split := len(blob) - ed25519.SignatureSize
+
+	return blob[:split], blob[split:], nil
+}
+
+// verifyPacket checks that the packet has been signed by its sender and that
+// the sender hash is derived from its public key
+func (this *Dht) verifyPacket(packet Packet, payload []byte, signature []byte) error {
+	if len(packet.Header.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("Invalid public key")
+	}
+
+	if compare(this.NewHash(packet.Header.PublicKey), packet.Header.Sender.Hash) != 0 {
+		return errors.New("Sender hash does not match public key")
+	}
+
+	if !ed25519.Verify(packet.Header.PublicKey, payload, signature) {
+		return errors.New("Invalid signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+)
+
+const (
+	LOOKUP_VALUES = 100
+	STORE_CLIENTS = 16
+)
+
+// BenchmarkNetwork measures the lookup latency, the store throughput and the
+// packets per second of simulated networks of each size. The network of 1000
+// nodes is skipped with -short
+func BenchmarkNetwork(b *testing.B) {
+	for _, nodes := range []int{10, 100, 1000} {
+		b.Run(strconv.Itoa(nodes), func(b *testing.B) {
+			if nodes > 100 && testing.Short() {
+				b.Skip("Skipping the network of", nodes, "nodes in short mode")
+			}
+
+			benchNetwork(b, nodes)
+		})
+	}
+}
+
+// benchNetwork starts the network once for all its sub-benchmarks
+func benchNetwork(b *testing.B, nodes int) {
+	sim := New(Config{
+		Nodes:   nodes,
+		Options: dht.DhtOptions{RateLimit: -1, NoRepublishOnExit: true},
+	})
+
+	if err := sim.Start(); err != nil {
+		b.Fatal("Error starting:", err)
+	}
+
+	defer sim.Stop()
+
+	if _, err := sim.Converge(min(5, nodes-1), time.Minute*5); err != nil {
+		b.Fatal(err)
+	}
+
+	random := rand.New(rand.NewSource(int64(nodes)))
+	node := func() *dht.Dht {
+		return sim.Node(random.Intn(nodes))
+	}
+
+	hashes := [][]byte{}
+
+	for i := 0; i < LOOKUP_VALUES; i++ {
+		hash := dht.NewHash([]byte("lookup-" + strconv.Itoa(i)))
+
+		if _, _, err := node().StoreAt(hash, i); err != nil {
+			b.Fatal("Error storing", i, ":", err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	b.Run("Lookup", func(b *testing.B) {
+		report(b, sim, func() {
+			for i := 0; i < b.N; i++ {
+				if _, err := node().Fetch(hashes[i%len(hashes)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	var stored int64
+
+	b.Run("Store", func(b *testing.B) {
+		b.SetParallelism(STORE_CLIENTS)
+
+		report(b, sim, func() {
+			// b.Fatal cannot be called from the RunParallel goroutines
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&stored, 1)
+					value := "store-" + strconv.FormatInt(i, 10)
+
+					if _, _, err := sim.Node(int(i)%nodes).StoreAt(dht.NewHash([]byte(value)), value); err != nil {
+						b.Error(err)
+
+						return
+					}
+				}
+			})
+		})
+
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "stores/s")
+	})
+}
+
+// report runs the benchmark and reports the packets per second sent by the
+// whole network while it ran
+func report(b *testing.B, sim *Simulator, bench func()) {
+	b.ReportAllocs()
+
+	before := sent(sim)
+
+	b.ResetTimer()
+	bench()
+	b.StopTimer()
+
+	b.ReportMetric(float64(sent(sim)-before)/b.Elapsed().Seconds(), "pkts/s")
+}
+
+func sent(sim *Simulator) uint64 {
+	var res uint64
+
+	for _, node := range sim.Nodes() {
+		for _, count := range node.Stats().Sent {
+			res += count
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,214 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+)
+
+const (
+	RESTART_TIMEOUT = time.Second * 30
+)
+
+// ChurnConfig replaces Rate of the nodes every Interval, for Rounds rounds.
+// Samples values are stored before the churn starts, and fetched back after
+// every round
+type ChurnConfig struct {
+	Rate     float64
+	Interval time.Duration
+	Rounds   int
+	Samples  int
+}
+
+type ChurnRound struct {
+	Killed      int
+	Restarted   int
+	Fetched     int
+	SuccessRate float64
+}
+
+type ChurnResult struct {
+	Stored int
+	Rounds []ChurnRound
+}
+
+// SuccessRate is the lowest success rate of the rounds
+func (this ChurnResult) SuccessRate() float64 {
+	if len(this.Rounds) == 0 {
+		return 0
+	}
+
+	res := 1.0
+
+	for _, round := range this.Rounds {
+		if round.SuccessRate < res {
+			res = round.SuccessRate
+		}
+	}
+
+	return res
+}
+
+// Kill stops the node without waiting for it to hand its values over
+func (this *Simulator) Kill(i int) {
+	this.Lock()
+	node := this.nodes[i]
+	this.nodes[i] = nil
+	this.Unlock()
+
+	if node == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	node.Stop(ctx)
+}
+
+// Restart starts a new node on the address of a killed one, bootstrapping
+// on a random running node. It gives up after RESTART_TIMEOUT, the node
+// being stopped whenever its start returns
+func (this *Simulator) Restart(i int) error {
+	if this.Node(i) != nil {
+		return errors.New("Node " + strconv.Itoa(i) + " is running")
+	}
+
+	alive := this.Alive()
+
+	if len(alive) == 0 {
+		return errors.New("No running node to bootstrap on")
+	}
+
+	this.Lock()
+	bootstrap := this.Addr(alive[this.rand.Intn(len(alive))])
+	this.Unlock()
+
+	type started struct {
+		node *dht.Dht
+		err  error
+	}
+
+	res := make(chan started, 1)
+
+	go func() {
+		node, err := this.bootNode(i, bootstrap)
+		res <- started{node, err}
+	}()
+
+	select {
+	case start := <-res:
+		if start.err != nil {
+			return start.err
+		}
+
+		this.Lock()
+		this.nodes[i] = start.node
+		this.Unlock()
+
+		return nil
+	case <-time.After(RESTART_TIMEOUT):
+		go func() {
+			if start := <-res; start.err == nil {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				start.node.Stop(ctx)
+			}
+		}()
+
+		return errors.New("Node " + strconv.Itoa(i) + " not started after " + RESTART_TIMEOUT.String())
+	}
+}
+
+// Churn kills and restarts nodes while checking that the values stored
+// before are still found
+func (this *Simulator) Churn(config ChurnConfig) ChurnResult {
+	res := ChurnResult{}
+	hashes := [][]byte{}
+	values := []string{}
+
+	prefix := "churn-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	for i := 0; i < config.Samples; i++ {
+		value := prefix + "-" + strconv.Itoa(i)
+		node := this.randomNode()
+		hash := node.NewHash([]byte(value))
+
+		if _, _, err := node.StoreAt(hash, value); err != nil {
+			continue
+		}
+
+		hashes = append(hashes, hash)
+		values = append(values, value)
+	}
+
+	res.Stored = len(hashes)
+
+	for r := 0; r < config.Rounds; r++ {
+		round := ChurnRound{}
+
+		alive := this.Alive()
+
+		this.Lock()
+		this.rand.Shuffle(len(alive), func(i, j int) {
+			alive[i], alive[j] = alive[j], alive[i]
+		})
+		this.Unlock()
+
+		killed := alive[:int(float64(len(alive))*config.Rate)]
+
+		// always keep a node to bootstrap on
+		if len(killed) == len(alive) {
+			killed = killed[1:]
+		}
+
+		for _, i := range killed {
+			this.Kill(i)
+			round.Killed++
+		}
+
+		// together, so that a round waits RESTART_TIMEOUT at most
+		restarted := make(chan error, len(killed))
+
+		for _, i := range killed {
+			go func(i int) {
+				restarted <- this.Restart(i)
+			}(i)
+		}
+
+		for range killed {
+			if err := <-restarted; err == nil {
+				round.Restarted++
+			}
+		}
+
+		time.Sleep(config.Interval)
+
+		for i, hash := range hashes {
+			if value, err := this.randomNode().Fetch(hash); err == nil && value == values[i] {
+				round.Fetched++
+			}
+		}
+
+		if len(hashes) > 0 {
+			round.SuccessRate = float64(round.Fetched) / float64(len(hashes))
+		}
+
+		res.Rounds = append(res.Rounds, round)
+	}
+
+	return res
+}
+
+func (this *Simulator) AssertChurn(min float64, config ChurnConfig) error {
+	if rate := this.Churn(config).SuccessRate(); rate < min {
+		return fmt.Errorf("Success rate under churn %.2f under %.2f", rate, min)
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+)
+
+const (
+	BASE_PORT     = 10000
+	START_WORKERS = 16
+	START_RETRIES = 3
+	POLL_INTERVAL = time.Millisecond * 50
+	RPC_TIMEOUT   = time.Millisecond * 250
+	RPC_BACKOFF   = time.Millisecond * 50
+)
+
+// Config describes the simulated network. Options are the base options of
+// every node, their ListenAddr, BootstrapAddr and Transport being set by the
+// simulator. Unless set, the RPC timeout follows the simulated latency
+type Config struct {
+	Nodes   int
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+	Seed    int64
+	Options dht.DhtOptions
+}
+
+// Simulator runs a network of in-process nodes over a MemoryTransport, with
+// latency, packet loss and partitions injected between them
+type Simulator struct {
+	sync.RWMutex
+	config     Config
+	transport  *dht.MemoryTransport
+	nodes      []*dht.Dht
+	partitions map[string]int
+	rand       *rand.Rand
+}
+
+func New(config Config) *Simulator {
+	res := &Simulator{
+		config:     config,
+		transport:  dht.NewMemoryTransport(),
+		partitions: make(map[string]int),
+		rand:       rand.New(rand.NewSource(config.Seed)),
+	}
+
+	res.transport.SetLink(res.link)
+
+	return res
+}
+
+func (this *Simulator) Addr(i int) string {
+	return "127.0.0.1:" + strconv.Itoa(BASE_PORT+i)
+}
+
+func (this *Simulator) options(i int, bootstrap string) dht.DhtOptions {
+	options := this.config.Options
+	options.ListenAddr = this.Addr(i)
+	options.Transport = this.transport
+
+	if options.RpcTimeout == 0 {
+		this.RLock()
+		options.RpcTimeout = RPC_TIMEOUT + 4*(this.config.Latency+this.config.Jitter)
+		this.RUnlock()
+	}
+
+	if options.RpcBackoff == 0 {
+		options.RpcBackoff = RPC_BACKOFF
+	}
+
+	if len(bootstrap) > 0 {
+		options.BootstrapAddr = []string{bootstrap}
+	}
+
+	return options
+}
+
+// Start starts the first node, then the other ones bootstrapping on it, a
+// few at a time for each CPU
+func (this *Simulator) Start() error {
+	this.nodes = make([]*dht.Dht, this.config.Nodes)
+
+	if this.config.Nodes == 0 {
+		return nil
+	}
+
+	if err := this.startNode(0, ""); err != nil {
+		return err
+	}
+
+	errs := make(chan error, this.config.Nodes)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+
+	workers := 4 * runtime.GOMAXPROCS(0)
+
+	if workers > START_WORKERS {
+		workers = START_WORKERS
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				errs <- this.startNode(i, this.Addr(0))
+			}
+		}()
+	}
+
+	for i := 1; i < this.config.Nodes; i++ {
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *Simulator) startNode(i int, bootstrap string) error {
+	node, err := this.bootNode(i, bootstrap)
+
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	this.nodes[i] = node
+	this.Unlock()
+
+	return nil
+}
+
+// bootNode starts the node i, trying START_RETRIES times to bootstrap, as
+// the bootstrap node can be too busy to answer in time
+func (this *Simulator) bootNode(i int, bootstrap string) (*dht.Dht, error) {
+	var node *dht.Dht
+	var err error
+
+	for try := 0; try < START_RETRIES; try++ {
+		node = dht.New(this.options(i, bootstrap))
+
+		if err = node.Start(); err == nil {
+			return node, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Node %d: %w", i, err)
+}
+
+func (this *Simulator) Stop() {
+	var wg sync.WaitGroup
+
+	for _, node := range this.Nodes() {
+		if node == nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(node *dht.Dht) {
+			defer wg.Done()
+
+			node.Stop(context.Background())
+		}(node)
+	}
+
+	wg.Wait()
+}
+
+func (this *Simulator) Nodes() []*dht.Dht {
+	this.RLock()
+	defer this.RUnlock()
+
+	return append([]*dht.Dht{}, this.nodes...)
+}
+
+func (this *Simulator) Node(i int) *dht.Dht {
+	this.RLock()
+	defer this.RUnlock()
+
+	return this.nodes[i]
+}
+
+func (this *Simulator) SetLatency(latency time.Duration, jitter time.Duration) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.config.Latency = latency
+	this.config.Jitter = jitter
+}
+
+func (this *Simulator) SetLoss(loss float64) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.config.Loss = loss
+}
+
+// Partition splits the network into groups of node indexes that cannot
+// reach each other. The nodes left out make a group of their own
+func (this *Simulator) Partition(groups ...[]int) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.partitions = make(map[string]int)
+
+	for i, group := range groups {
+		for _, node := range group {
+			this.partitions[this.Addr(node)] = i + 1
+		}
+	}
+}
+
+func (this *Simulator) Heal() {
+	this.Partition()
+}
+
+func (this *Simulator) link(from net.Addr, to net.Addr) (time.Duration, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.partitions[from.String()] != this.partitions[to.String()] {
+		return 0, true
+	}
+
+	if this.config.Loss > 0 && this.rand.Float64() < this.config.Loss {
+		return 0, true
+	}
+
+	delay := this.config.Latency
+
+	if this.config.Jitter > 0 {
+		delay += time.Duration(this.rand.Int63n(int64(this.config.Jitter)))
+	}
+
+	return delay, false
+}
+
+// Alive returns the indexes of the running nodes
+func (this *Simulator) Alive() []int {
+	this.RLock()
+	defer this.RUnlock()
+
+	res := []int{}
+
+	for i, node := range this.nodes {
+		if node != nil {
+			res = append(res, i)
+		}
+	}
+
+	return res
+}
+
+func (this *Simulator) randomNode() *dht.Dht {
+	alive := this.Alive()
+
+	this.Lock()
+	i := alive[this.rand.Intn(len(alive))]
+	this.Unlock()
+
+	return this.Node(i)
+}
+
+// LookupSuccessRate stores samples values from random nodes, and returns
+// the rate of them fetched back from other random nodes
+func (this *Simulator) LookupSuccessRate(samples int) float64 {
+	if samples == 0 || len(this.Alive()) == 0 {
+		return 0
+	}
+
+	prefix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	okNb := 0
+
+	for i := 0; i < samples; i++ {
+		value := prefix + "-" + strconv.Itoa(i)
+		node := this.randomNode()
+		hash := node.NewHash([]byte(value))
+
+		if _, _, err := node.StoreAt(hash, value); err != nil {
+			continue
+		}
+
+		if res, err := this.randomNode().Fetch(hash); err == nil && res == value {
+			okNb++
+		}
+	}
+
+	return float64(okNb) / float64(samples)
+}
+
+// Converge waits for every running node to know at least minPeers others,
+// and returns how long it took
+func (this *Simulator) Converge(minPeers int, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+
+	for time.Since(start) < timeout {
+		converged := true
+
+		for _, node := range this.Nodes() {
+			if node != nil && node.GetConnectedNumber() < minPeers {
+				converged = false
+				break
+			}
+		}
+
+		if converged {
+			return time.Since(start), nil
+		}
+
+		time.Sleep(POLL_INTERVAL)
+	}
+
+	return time.Since(start), errors.New("Not converged after " + timeout.String())
+}
+
+func (this *Simulator) AssertSuccessRate(min float64, samples int) error {
+	if rate := this.LookupSuccessRate(samples); rate < min {
+		return fmt.Errorf("Lookup success rate %.2f under %.2f", rate, min)
+	}
+
+	return nil
+}
+
+func (this *Simulator) AssertConvergence(minPeers int, timeout time.Duration) error {
+	_, err := this.Converge(minPeers, timeout)
+
+	return err
+}
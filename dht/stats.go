@@ -0,0 +1,132 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the counters of the node. Packets are counted per
+// command name
+type Stats struct {
+	Sent          map[string]uint64 `json:"sent"`
+	Received      map[string]uint64 `json:"received"`
+	ActiveQueries int               `json:"active_queries"`
+	Timeouts      uint64            `json:"timeouts"`
+	Dropped       uint64            `json:"dropped"`
+	Outbound      int               `json:"outbound"`
+	OutboundDrops uint64            `json:"outbound_drops"`
+	OpenCircuits  int               `json:"open_circuits"`
+	AverageRTT    time.Duration     `json:"average_rtt"`
+	StoreEntries  int               `json:"store_entries"`
+	StoreBytes    int               `json:"store_bytes"`
+	Uptime        time.Duration     `json:"uptime"`
+}
+
+type statsCounters struct {
+	sync.Mutex
+	sent          map[int]uint64
+	received      map[int]uint64
+	timeouts      uint64
+	dropped       uint64
+	outboundDrops uint64
+}
+
+func newStatsCounters() *statsCounters {
+	return &statsCounters{
+		sent:     make(map[int]uint64),
+		received: make(map[int]uint64),
+	}
+}
+
+func (this *statsCounters) countSent(command int) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.sent[command]++
+}
+
+func (this *statsCounters) countReceived(command int) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.received[command]++
+}
+
+func (this *statsCounters) countTimeout() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.timeouts++
+}
+
+func (this *statsCounters) countDropped() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.dropped++
+}
+
+func (this *statsCounters) countOutboundDropped() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.outboundDrops++
+}
+
+func (this *Dht) Stats() Stats {
+	res := Stats{
+		Sent:       make(map[string]uint64),
+		Received:   make(map[string]uint64),
+		AverageRTT: this.routing.averageRTT(),
+	}
+
+	this.counters.Lock()
+
+	for command, count := range this.counters.sent {
+		res.Sent[commandName(command)] = count
+	}
+
+	for command, count := range this.counters.received {
+		res.Received[commandName(command)] = count
+	}
+
+	res.Timeouts = this.counters.timeouts
+	res.Dropped = this.counters.dropped
+	res.OutboundDrops = this.counters.outboundDrops
+
+	this.counters.Unlock()
+
+	res.ActiveQueries = this.calls.Len()
+	res.Outbound = this.outboundLen()
+	res.OpenCircuits = this.breakers.open()
+
+	this.RLock()
+	res.Uptime = time.Since(this.startedAt)
+	this.RUnlock()
+
+	this.storage().ForEach(func(key string, inst StoreInst) bool {
+		res.StoreEntries++
+		res.StoreBytes += instSize(inst)
+
+		return true
+	})
+
+	return res
+}
+
+func (this *Routing) averageRTT() time.Duration {
+	this.RLock()
+	defer this.RUnlock()
+
+	if len(this.rtt) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+
+	for _, rtt := range this.rtt {
+		total += rtt
+	}
+
+	return total / time.Duration(len(this.rtt))
+}
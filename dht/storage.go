@@ -0,0 +1,173 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+)
+
+type Storage interface {
+	Get(key string) (StoreInst, bool)
+	Set(key string, inst StoreInst) error
+	Delete(key string) error
+	ForEach(fn func(key string, inst StoreInst) bool) error
+	Len() int
+	Close() error
+}
+
+// RangeStorage is implemented by the storages iterating over their keys in
+// order. The keys in [start, end) are passed to fn, an empty end has no bound
+type RangeStorage interface {
+	ForEachRange(start, end string, fn func(key string, inst StoreInst) bool) error
+}
+
+// ForEachRange iterates in order over the keys in [start, end), filtering
+// ForEach when the storage is not a RangeStorage
+func ForEachRange(storage Storage, start, end string, fn func(key string, inst StoreInst) bool) error {
+	if ranged, ok := storage.(RangeStorage); ok {
+		return ranged.ForEachRange(start, end, fn)
+	}
+
+	items := make(map[string]StoreInst)
+
+	err := storage.ForEach(func(key string, inst StoreInst) bool {
+		if inRange(key, start, end) {
+			items[key] = inst
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(items) {
+		if !fn(key, items[key]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func ForEachPrefix(storage Storage, prefix string, fn func(key string, inst StoreInst) bool) error {
+	return ForEachRange(storage, prefix, prefixEnd(prefix), fn)
+}
+
+func inRange(key, start, end string) bool {
+	return key >= start && (len(end) == 0 || key < end)
+}
+
+// prefixEnd is the first key after all the ones starting with prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+func sortedKeys(items map[string]StoreInst) []string {
+	keys := make([]string, 0, len(items))
+
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+type MemoryStorage struct {
+	sync.RWMutex
+	items map[string]StoreInst
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		items: make(map[string]StoreInst),
+	}
+}
+
+func (this *MemoryStorage) Get(key string) (StoreInst, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	inst, ok := this.items[key]
+
+	return inst, ok
+}
+
+func (this *MemoryStorage) Set(key string, inst StoreInst) error {
+	this.Lock()
+	defer this.Unlock()
+
+	this.items[key] = inst
+
+	return nil
+}
+
+func (this *MemoryStorage) Delete(key string) error {
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.items, key)
+
+	return nil
+}
+
+func (this *MemoryStorage) ForEach(fn func(key string, inst StoreInst) bool) error {
+	this.RLock()
+	items := make(map[string]StoreInst, len(this.items))
+
+	for k, v := range this.items {
+		items[k] = v
+	}
+	this.RUnlock()
+
+	for k, v := range items {
+		if !fn(k, v) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (this *MemoryStorage) ForEachRange(start, end string, fn func(key string, inst StoreInst) bool) error {
+	this.RLock()
+	items := make(map[string]StoreInst)
+
+	for k, v := range this.items {
+		if inRange(k, start, end) {
+			items[k] = v
+		}
+	}
+	this.RUnlock()
+
+	for _, key := range sortedKeys(items) {
+		if !fn(key, items[key]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (this *MemoryStorage) Len() int {
+	this.RLock()
+	defer this.RUnlock()
+
+	return len(this.items)
+}
+
+func (this *MemoryStorage) Close() error {
+	return nil
+}
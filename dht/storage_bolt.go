@@ -0,0 +1,171 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("store")
+
+type BoltStorage struct {
+	db    *bolt.DB
+	batch bool
+}
+
+func NewBoltStorage(path string, batch bool) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	return &BoltStorage{
+		db:    db,
+		batch: batch,
+	}, nil
+}
+
+func (this *BoltStorage) update(fn func(*bolt.Tx) error) error {
+	if this.batch {
+		return this.db.Batch(fn)
+	}
+
+	return this.db.Update(fn)
+}
+
+func (this *BoltStorage) Get(key string) (StoreInst, bool) {
+	var inst StoreInst
+	found := false
+
+	this.db.View(func(tx *bolt.Tx) error {
+		blob := tx.Bucket(boltBucket).Get([]byte(key))
+
+		if blob == nil {
+			return nil
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(blob)).Decode(&inst); err != nil {
+			return err
+		}
+
+		found = true
+
+		return nil
+	})
+
+	return inst, found
+}
+
+func (this *BoltStorage) Set(key string, inst StoreInst) error {
+	var blob bytes.Buffer
+
+	if err := gob.NewEncoder(&blob).Encode(inst); err != nil {
+		return fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+
+	return this.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), blob.Bytes())
+	})
+}
+
+func (this *BoltStorage) Delete(key string) error {
+	return this.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (this *BoltStorage) ForEach(fn func(key string, inst StoreInst) bool) error {
+	items := make(map[string]StoreInst)
+
+	err := this.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			var inst StoreInst
+
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&inst); err != nil {
+				return err
+			}
+
+			items[string(k)] = inst
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for k, v := range items {
+		if !fn(k, v) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// ForEachRange seeks to start, bolt keeping the keys sorted
+func (this *BoltStorage) ForEachRange(start, end string, fn func(key string, inst StoreInst) bool) error {
+	keys := []string{}
+	insts := []StoreInst{}
+
+	err := this.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+
+		for k, v := cursor.Seek([]byte(start)); k != nil && inRange(string(k), start, end); k, v = cursor.Next() {
+			var inst StoreInst
+
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&inst); err != nil {
+				return err
+			}
+
+			keys = append(keys, string(k))
+			insts = append(insts, inst)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if !fn(key, insts[i]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (this *BoltStorage) Len() int {
+	count := 0
+
+	this.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltBucket).Stats().KeyN
+
+		return nil
+	})
+
+	return count
+}
+
+func (this *BoltStorage) Close() error {
+	return this.db.Close()
+}
@@ -0,0 +1,149 @@
+package dht
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"errors"
+	"sync"
+)
+
+const (
+	MAX_STORE_BYTES = 1024 * 1024 * 64
+)
+
+type lruEntry struct {
+	key  string
+	size int
+}
+
+type LRUStorage struct {
+	sync.Mutex
+	backend    Storage
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+func NewLRUStorage(backend Storage, maxEntries, maxBytes int) *LRUStorage {
+	res := &LRUStorage{
+		backend:    backend,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+
+	backend.ForEach(func(key string, inst StoreInst) bool {
+		res.track(key, instSize(inst))
+
+		return true
+	})
+
+	res.evict()
+
+	return res
+}
+
+func instSize(inst StoreInst) int {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(inst); err != nil {
+		return 0
+	}
+
+	return buf.Len()
+}
+
+func (this *LRUStorage) track(key string, size int) {
+	if elem, ok := this.items[key]; ok {
+		this.bytes -= elem.Value.(*lruEntry).size
+		this.order.Remove(elem)
+	}
+
+	this.items[key] = this.order.PushFront(&lruEntry{key: key, size: size})
+	this.bytes += size
+}
+
+func (this *LRUStorage) untrack(key string) {
+	elem, ok := this.items[key]
+
+	if !ok {
+		return
+	}
+
+	this.bytes -= elem.Value.(*lruEntry).size
+	this.order.Remove(elem)
+	delete(this.items, key)
+}
+
+func (this *LRUStorage) evict() {
+	for this.order.Len() > 0 && ((this.maxEntries > 0 && this.order.Len() > this.maxEntries) || (this.maxBytes > 0 && this.bytes > this.maxBytes)) {
+		entry := this.order.Back().Value.(*lruEntry)
+
+		this.backend.Delete(entry.key)
+		this.untrack(entry.key)
+	}
+}
+
+func (this *LRUStorage) Get(key string) (StoreInst, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	inst, ok := this.backend.Get(key)
+
+	if ok {
+		if elem, tracked := this.items[key]; tracked {
+			this.order.MoveToFront(elem)
+		}
+	}
+
+	return inst, ok
+}
+
+func (this *LRUStorage) Set(key string, inst StoreInst) error {
+	size := instSize(inst)
+
+	if this.maxBytes > 0 && size > this.maxBytes {
+		return errors.New("Value too big for store")
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if err := this.backend.Set(key, inst); err != nil {
+		return err
+	}
+
+	this.track(key, size)
+	this.evict()
+
+	return nil
+}
+
+func (this *LRUStorage) Delete(key string) error {
+	this.Lock()
+	defer this.Unlock()
+
+	this.untrack(key)
+
+	return this.backend.Delete(key)
+}
+
+func (this *LRUStorage) ForEach(fn func(key string, inst StoreInst) bool) error {
+	return this.backend.ForEach(fn)
+}
+
+func (this *LRUStorage) ForEachRange(start, end string, fn func(key string, inst StoreInst) bool) error {
+	return ForEachRange(this.backend, start, end, fn)
+}
+
+func (this *LRUStorage) Len() int {
+	return this.backend.Len()
+}
+
+func (this *LRUStorage) Close() error {
+	return this.backend.Close()
+}
@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"time"
+)
+
+const (
+	SWEEP_INTERVAL = time.Minute
+)
+
+func (this StoreInst) Expired() bool {
+	return this.Expiration != 0 && time.Now().UnixNano() > this.Expiration
+}
+
+func (this *Dht) storage() Storage {
+	this.RLock()
+	defer this.RUnlock()
+
+	return this.store
+}
+
+func (this *Dht) limitStorage(storage Storage) Storage {
+	if this.options.MaxStoreEntries <= 0 && this.options.MaxStoreBytes <= 0 {
+		return storage
+	}
+
+	return NewLRUStorage(storage, this.options.MaxStoreEntries, this.options.MaxStoreBytes)
+}
+
+func (this *Dht) getLocal(key string) (StoreInst, bool) {
+	inst, ok := this.storage().Get(key)
+
+	if !ok || inst.Expired() {
+		return StoreInst{}, false
+	}
+
+	return inst, true
+}
+
+// LocalKeys returns the sorted keys of the values this node stores
+func (this *Dht) LocalKeys() []string {
+	return this.LocalKeysPrefix("")
+}
+
+// LocalKeysPrefix returns the sorted keys starting with the hex prefix
+func (this *Dht) LocalKeysPrefix(prefix string) []string {
+	res := []string{}
+
+	ForEachPrefix(this.storage(), prefix, func(key string, inst StoreInst) bool {
+		if !inst.Expired() {
+			res = append(res, key)
+		}
+
+		return true
+	})
+
+	return res
+}
+
+func (this *Dht) startSweeper(done chan struct{}) {
+	timer := time.NewTicker(SWEEP_INTERVAL)
+
+	go func() {
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				this.sweep()
+			}
+		}
+	}()
+}
+
+func (this *Dht) sweep() {
+	this.sweepProviders()
+	this.pruneBroadcasts()
+	this.pruneTopics()
+	this.limiter.Prune()
+	this.bans.Prune()
+	this.replay.Prune()
+
+	store := this.storage()
+	count := 0
+
+	store.ForEach(func(k string, inst StoreInst) bool {
+		if inst.Expired() {
+			store.Delete(k)
+			count++
+		}
+
+		return true
+	})
+
+	this.recountUsage()
+
+	this.Lock()
+	defer this.Unlock()
+
+	for k, inst := range this.published {
+		if inst.Expired() {
+			delete(this.published, k)
+		}
+	}
+
+	if count > 0 {
+		this.logger.Debug("Expired", count)
+	}
+}
@@ -0,0 +1,399 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+)
+
+const (
+	STREAM_CHUNK  = 1024 * 8
+	STREAM_WINDOW = 4
+)
+
+var (
+	errInvalidChunk = errors.New("Invalid chunk")
+	errStreamClosed = errors.New("Stream closed")
+)
+
+// StreamInfo answers FETCH_STREAM: the size of the value and its checksum
+// when the node has it, the closest nodes it knows otherwise
+type StreamInfo struct {
+	Found  bool
+	Size   int
+	Prefix []byte
+	Sum    []byte
+	Nodes  []PacketContact
+}
+
+// ChunkInst asks for Length bytes of the stream of a value from Offset
+type ChunkInst struct {
+	Hash   []byte
+	Offset int
+	Length int
+}
+
+type Chunk struct {
+	Found bool
+	Data  []byte
+}
+
+// streamBytes returns what is streamed of a value: the bytes of a blob or a
+// string, the gob encoding of anything else. The prefix is what gob puts
+// before the bytes of a blob or a string, for the stream to be checked
+// against the hash Store() gives it
+func streamBytes(value interface{}) ([]byte, []byte, error) {
+	var data []byte
+	raw := true
+
+	switch value := value.(type) {
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	default:
+		raw = false
+	}
+
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, nil, err
+	}
+
+	if !raw {
+		return buf.Bytes(), nil, nil
+	}
+
+	return data, buf.Bytes()[:buf.Len()-len(data)], nil
+}
+
+// newStreamHash and streamSum hash a stream as NewHash would the whole value
+func (this *Dht) newStreamHash() hash.Hash {
+	return this.options.Hasher()
+}
+
+func (this *Dht) streamSum(h hash.Hash) []byte {
+	return h.Sum(nil)[:this.hashLen()]
+}
+
+// FetchStream returns a reader of the value, fetched from the node having it
+// in chunks of STREAM_CHUNK bytes, at most STREAM_WINDOW of them ahead of
+// the reader. A blob or a string is read as is, any other value as its gob
+// encoding. A peer without CAP_STREAM answers the whole value at once
+func (this *Dht) FetchStream(hash []byte) (io.ReadCloser, error) {
+	if inst, ok := this.getLocal(hex.EncodeToString(hash)); ok {
+		return valueReader(inst.Data)
+	}
+
+	if value, ok := this.cachedRead(hash); ok {
+		return valueReader(value)
+	}
+
+	var lock sync.Mutex
+	sources := make(map[string]*Node)
+
+	fn := func(node *Node) (QueryResult, error) {
+		if !node.contact.Has(CAP_STREAM) {
+			res, err := node.Fetch(hash)
+
+			if err == nil && res.Found {
+				if err := this.checkContent(hash, res.Value); err != nil {
+					return QueryResult{}, node.newError(ErrIntegrity, nil)
+				}
+			}
+
+			return res, err
+		}
+
+		info, err := node.FetchStream(hash)
+
+		if err != nil || !info.Found {
+			return QueryResult{Nodes: info.Nodes}, err
+		}
+
+		lock.Lock()
+		sources[hex.EncodeToString(info.Sum)] = node
+		lock.Unlock()
+
+		return QueryResult{Value: info, Found: true}, nil
+	}
+
+	res, found, _ := this.lookup(hash, fn, lookupOptions{})
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	info, ok := res.(StreamInfo)
+
+	if !ok {
+		return valueReader(res)
+	}
+
+	lock.Lock()
+	node := sources[hex.EncodeToString(info.Sum)]
+	lock.Unlock()
+
+	return newStreamReader(node, hash, info), nil
+}
+
+func valueReader(value interface{}) (io.ReadCloser, error) {
+	data, _, err := streamBytes(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// streamReader asks for the next chunks as the previous ones are read, and
+// checks the whole stream against its checksum, and its hash with
+// ContentAddressed, before returning io.EOF
+type streamReader struct {
+	sync.Mutex
+	node    *Node
+	hash    []byte
+	info    StreamInfo
+	size    int
+	next    int
+	read    int
+	queue   []chan chunkResult
+	current []byte
+	sum     hash.Hash
+	raw     hash.Hash
+	err     error
+}
+
+func newStreamReader(node *Node, hash []byte, info StreamInfo) *streamReader {
+	res := &streamReader{
+		node: node,
+		hash: hash,
+		info: info,
+		size: STREAM_CHUNK,
+		sum:  node.dht.newStreamHash(),
+		raw:  node.dht.newStreamHash(),
+	}
+
+	// a chunk must fit in a packet this node accepts
+	if max := node.dht.options.MaxValueSize; max < res.size {
+		res.size = max
+	}
+
+	res.sum.Write(info.Prefix)
+
+	return res
+}
+
+func (this *streamReader) Read(p []byte) (int, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	for len(this.current) == 0 {
+		if this.err != nil {
+			return 0, this.err
+		}
+
+		if this.read >= this.info.Size {
+			this.err = this.verify()
+
+			continue
+		}
+
+		this.fill()
+
+		res := <-this.queue[0]
+		this.queue = this.queue[1:]
+
+		if res.err != nil {
+			this.err = res.err
+
+			continue
+		}
+
+		this.read += len(res.data)
+		this.sum.Write(res.data)
+		this.raw.Write(res.data)
+		this.current = res.data
+
+		this.fill()
+	}
+
+	n := copy(p, this.current)
+	this.current = this.current[n:]
+
+	return n, nil
+}
+
+// fill asks for the chunks up to STREAM_WINDOW ahead of the reader
+func (this *streamReader) fill() {
+	for len(this.queue) < STREAM_WINDOW && this.next < this.info.Size {
+		length := this.size
+
+		if this.next+length > this.info.Size {
+			length = this.info.Size - this.next
+		}
+
+		res := make(chan chunkResult, 1)
+
+		go func(offset int, length int) {
+			data, err := this.node.FetchChunk(ChunkInst{Hash: this.hash, Offset: offset, Length: length})
+
+			if err == nil && len(data) != length {
+				err = this.node.newError(ErrInvalidResponse, errInvalidChunk)
+			}
+
+			res <- chunkResult{data, err}
+		}(this.next, length)
+
+		this.queue = append(this.queue, res)
+		this.next += length
+	}
+}
+
+// verify fails a stream that changed while read, or does not match its hash
+// with ContentAddressed. The hash of a blob stored with TypedStore is the
+// one of its raw bytes
+func (this *streamReader) verify() error {
+	sum := this.node.dht.streamSum(this.sum)
+
+	if !bytes.Equal(sum, this.info.Sum) {
+		return this.node.newError(ErrInvalidResponse, errInvalidChunk)
+	}
+
+	if !this.node.dht.options.ContentAddressed || bytes.Equal(sum, this.hash) || bytes.Equal(this.node.dht.streamSum(this.raw), this.hash) {
+		return io.EOF
+	}
+
+	return ErrIntegrity
+}
+
+// Close stops asking for chunks, the ones on their way being dropped
+func (this *streamReader) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	this.err = errStreamClosed
+	this.current = nil
+
+	return nil
+}
+
+func (this *Node) FetchStream(hash []byte) (StreamInfo, error) {
+	this.dht.logger.Debug(this, "< FETCH STREAM", hex.EncodeToString(hash)[:16])
+
+	res, err := this.request(this.newPacket(COMMAND_FETCH_STREAM, []byte{}, hash))
+
+	if err != nil {
+		return StreamInfo{}, err
+	}
+
+	info, ok := res.Data.(StreamInfo)
+
+	if !ok || (info.Found && (info.Size < 0 || len(info.Sum) != this.dht.hashLen())) {
+		return StreamInfo{}, this.newError(ErrInvalidResponse, nil)
+	}
+
+	return info, nil
+}
+
+func (this *Node) OnFetchStream(packet Packet) {
+	hash, _ := packet.Data.([]byte)
+
+	this.dht.logger.Debug(this, "> FETCH STREAM", hex.EncodeToString(hash))
+
+	info := StreamInfo{}
+
+	if inst, ok := this.dht.getLocal(hex.EncodeToString(hash)); ok {
+		if data, prefix, err := streamBytes(inst.Data); err == nil {
+			sum := this.dht.newStreamHash()
+			sum.Write(prefix)
+			sum.Write(data)
+
+			info = StreamInfo{
+				Found:  true,
+				Size:   len(data),
+				Prefix: prefix,
+				Sum:    this.dht.streamSum(sum),
+			}
+		}
+	}
+
+	if !info.Found {
+		info.Nodes = this.dht.routing.FindNode(hash)
+	}
+
+	this.dht.logger.Debug(this, "< STREAM INFO", info.Size)
+
+	this.send(this.newPacket(COMMAND_STREAM_INFO, packet.Header.MessageHash, info))
+}
+
+func (this *Node) OnStreamInfo(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> STREAM INFO")
+
+	done.c <- packet
+}
+
+func (this *Node) FetchChunk(inst ChunkInst) ([]byte, error) {
+	this.dht.logger.Debug(this, "< FETCH CHUNK", hex.EncodeToString(inst.Hash)[:16], inst.Offset)
+
+	res, err := this.request(this.newPacket(COMMAND_FETCH_CHUNK, []byte{}, inst))
+
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, ok := res.Data.(Chunk)
+
+	if !ok {
+		return nil, this.newError(ErrInvalidResponse, nil)
+	}
+
+	if !chunk.Found {
+		return nil, this.newError(ErrNotFound, nil)
+	}
+
+	return chunk.Data, nil
+}
+
+func (this *Node) OnFetchChunk(packet Packet) {
+	inst, _ := packet.Data.(ChunkInst)
+
+	this.dht.logger.Debug(this, "> FETCH CHUNK", hex.EncodeToString(inst.Hash), inst.Offset)
+
+	chunk := Chunk{}
+
+	if stored, ok := this.dht.getLocal(hex.EncodeToString(inst.Hash)); ok {
+		data, _, err := streamBytes(stored.Data)
+
+		if err == nil && inst.Offset <= len(data) {
+			end := inst.Offset + inst.Length
+
+			if end > len(data) {
+				end = len(data)
+			}
+
+			chunk = Chunk{Found: true, Data: data[inst.Offset:end]}
+		}
+	}
+
+	this.dht.logger.Debug(this, "< CHUNK", len(chunk.Data))
+
+	this.send(this.newPacket(COMMAND_CHUNK, packet.Header.MessageHash, chunk))
+}
+
+func (this *Node) OnChunk(packet Packet, done CallbackChan) {
+	this.dht.logger.Debug(this, "> CHUNK")
+
+	done.c <- packet
+}
@@ -0,0 +1,206 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	STUN_TIMEOUT = time.Second * 3
+	STUN_RETRIES = 3
+
+	stunMagicCookie         = 0x2112A442
+	stunBindingRequest      = 0x0001
+	stunBindingSuccess      = 0x0101
+	stunAttrMappedAddr      = 0x0001
+	stunAttrXorMappedAddr   = 0x0020
+	stunHeaderSize          = 20
+	stunTransactionIdOffset = 8
+)
+
+// discoverExternalAddr asks the STUN server the address our socket is seen
+// from, and advertises it in our contact instead of the ListenAddr
+func (this *Dht) discoverExternalAddr() error {
+	server, err := net.ResolveUDPAddr("udp", this.options.StunServer)
+
+	if err != nil {
+		return err
+	}
+
+	for retry := 0; retry < STUN_RETRIES; retry++ {
+		txId := make([]byte, 12)
+		rand.Read(txId)
+
+		res := make(chan string, 1)
+
+		this.Lock()
+		this.stunPending[string(txId)] = res
+		this.Unlock()
+
+		var req bytes.Buffer
+
+		binary.Write(&req, binary.BigEndian, []uint16{stunBindingRequest, 0})
+		binary.Write(&req, binary.BigEndian, uint32(stunMagicCookie))
+		req.Write(txId)
+
+		err = this.writeTo(req.Bytes(), server)
+
+		if err == nil {
+			select {
+			case addr := <-res:
+				this.setExternalAddr(addr)
+
+				return nil
+			case <-time.After(STUN_TIMEOUT):
+				err = this.newStunTimeout()
+			}
+		}
+
+		this.Lock()
+		delete(this.stunPending, string(txId))
+		this.Unlock()
+	}
+
+	return err
+}
+
+func (this *Dht) newStunTimeout() error {
+	return &PeerError{
+		Kind:    ErrTimeout,
+		Contact: PacketContact{Addr: this.options.StunServer},
+	}
+}
+
+func (this *Dht) setExternalAddr(addr string) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.externalAddr != addr {
+		this.logger.Info("External address", addr)
+	}
+
+	this.externalAddr = addr
+}
+
+func isStunMessage(packet []byte) bool {
+	return len(packet) >= stunHeaderSize &&
+		packet[0]&0xC0 == 0 &&
+		binary.BigEndian.Uint32(packet[4:]) == stunMagicCookie
+}
+
+func (this *Dht) onStun(packet []byte) {
+	txId := string(packet[stunTransactionIdOffset:stunHeaderSize])
+
+	this.Lock()
+	res, ok := this.stunPending[txId]
+	delete(this.stunPending, txId)
+	this.Unlock()
+
+	if !ok || binary.BigEndian.Uint16(packet) != stunBindingSuccess {
+		return
+	}
+
+	addr, err := parseStunAddr(packet)
+
+	if err != nil {
+		this.logger.Warning("STUN:", err)
+		return
+	}
+
+	res <- addr
+}
+
+func parseStunAddr(packet []byte) (string, error) {
+	if len(packet) < stunHeaderSize {
+		return "", errors.New("Invalid STUN message")
+	}
+
+	length := int(binary.BigEndian.Uint16(packet[2:]))
+
+	if stunHeaderSize+length > len(packet) {
+		return "", errors.New("Invalid STUN message")
+	}
+
+	attrs := packet[stunHeaderSize : stunHeaderSize+length]
+	mapped := ""
+
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs)
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:]))
+
+		if 4+attrLength > len(attrs) {
+			break
+		}
+
+		value := attrs[4 : 4+attrLength]
+
+		switch attrType {
+		case stunAttrXorMappedAddr:
+			// the xor key is the magic cookie followed by the transaction id
+			key := packet[4:stunHeaderSize]
+
+			if addr, ok := decodeStunAddr(value, key); ok {
+				return addr, nil
+			}
+		case stunAttrMappedAddr:
+			if addr, ok := decodeStunAddr(value, nil); ok {
+				mapped = addr
+			}
+		}
+
+		// attributes are padded to 4 bytes, but the last one may not be
+		next := 4 + (attrLength+3)&^3
+
+		if next > len(attrs) {
+			next = len(attrs)
+		}
+
+		attrs = attrs[next:]
+	}
+
+	if len(mapped) == 0 {
+		return "", errors.New("No mapped address in STUN response")
+	}
+
+	return mapped, nil
+}
+
+func decodeStunAddr(value []byte, key []byte) (string, bool) {
+	if len(value) < 4 {
+		return "", false
+	}
+
+	var size int
+
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return "", false
+	}
+
+	if len(value) < 4+size {
+		return "", false
+	}
+
+	port := binary.BigEndian.Uint16(value[2:])
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key)
+
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), true
+}
@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// LookupHop is a node queried during a lookup, with what it answered
+type LookupHop struct {
+	Path     int
+	Contact  PacketContact
+	Duration time.Duration
+	Nodes    []PacketContact
+	Found    bool
+	Err      error
+}
+
+// LookupTrace records every hop of a lookup, for all its disjoint paths
+type LookupTrace struct {
+	sync.Mutex
+	Hash     []byte
+	Start    time.Time
+	Duration time.Duration
+	Hops     []LookupHop
+}
+
+func newLookupTrace(hash []byte) *LookupTrace {
+	return &LookupTrace{
+		Hash:  hash,
+		Start: time.Now(),
+	}
+}
+
+func (this *LookupTrace) addHop(hop LookupHop) {
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.Hops = append(this.Hops, hop)
+}
+
+func (this *LookupTrace) finish() {
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.Duration = time.Since(this.Start)
+}
+
+// FetchTrace is Fetch, also returning the trace of the lookup. The trace is
+// nil when the value was found locally
+func (this *Dht) FetchTrace(hash []byte) (interface{}, *LookupTrace, error) {
+	if inst, ok := this.getLocal(hex.EncodeToString(hash)); ok {
+		this.emit(EVENT_VALUE_FETCHED, PacketContact{}, hash)
+
+		res, err := this.rebuildManifest(hash, inst.Data)
+
+		return res, nil, err
+	}
+
+	trace := newLookupTrace(hash)
+
+	res, err := this.fetch(hash, trace, "")
+
+	trace.finish()
+
+	// the trace is the one of the manifest
+	if err == nil {
+		res, err = this.rebuildManifest(hash, res)
+	}
+
+	return res, trace, err
+}
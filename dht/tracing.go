@@ -0,0 +1,94 @@
+package dht
+
+type SpanKind int
+
+const (
+	SPAN_INTERNAL SpanKind = iota
+	SPAN_CLIENT
+	SPAN_SERVER
+)
+
+// Tracer starts the spans of lookups and RPCs. The parent and the returned
+// TraceParent are W3C traceparent strings, carried in the PacketHeader so a
+// trace follows a lookup across nodes. See the dht/otel package for an
+// OpenTelemetry one
+type Tracer interface {
+	Start(parent string, name string, kind SpanKind) Span
+}
+
+type Span interface {
+	TraceParent() string
+	End(err error)
+}
+
+// noopSpan passes the parent context through when no Tracer is set
+type noopSpan struct {
+	parent string
+}
+
+func (this noopSpan) TraceParent() string {
+	return this.parent
+}
+
+func (this noopSpan) End(err error) {}
+
+var commandNames = []string{
+	"noop",
+	"ping",
+	"pong",
+	"store",
+	"stored",
+	"fetch",
+	"fetch_nodes",
+	"found",
+	"found_nodes",
+	"broadcast",
+	"custom",
+	"custom_answer",
+	"delete",
+	"deleted",
+	"add_provider",
+	"get_providers",
+	"providers",
+	"busy",
+	"pex",
+	"pex_answer",
+	"holepunch",
+	"holepunch_intent",
+	"relay",
+	"relayed",
+	"publish",
+	"hello",
+	"hello_answer",
+	"error",
+	"fetch_stream",
+	"stream_info",
+	"fetch_chunk",
+	"chunk",
+	"rpc",
+	"rpc_answer",
+	"session",
+	"session_ack",
+	"register",
+	"fetch_record",
+}
+
+func commandName(command int) string {
+	if command < 0 || command >= len(commandNames) {
+		return "unknown"
+	}
+
+	return commandNames[command]
+}
+
+func spanName(command int) string {
+	return "dht." + commandName(command)
+}
+
+func (this *Dht) startSpan(parent string, name string, kind SpanKind) Span {
+	if this.options.Tracer == nil {
+		return noopSpan{parent: parent}
+	}
+
+	return this.options.Tracer.Start(parent, name, kind)
+}
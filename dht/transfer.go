@@ -0,0 +1,52 @@
+package dht
+
+import "github.com/champii/go-dht/dht/kad"
+
+// transferTo stores on a node that just joined the local values it is among
+// the K closest nodes to, as the Kademlia paper has it, so they move toward
+// their owners without waiting for the next replication. Every holder sends
+// them: leaving it to the closest one, as the paper suggests, loses the
+// values that one does not have
+func (this *Dht) transferTo(contact PacketContact) {
+	if this.options.NoTransferOnJoin || !this.running || !contact.Has(CAP_STORE) {
+		return
+	}
+
+	addr, err := this.resolve(contact)
+
+	if err != nil {
+		return
+	}
+
+	node := NewNodeContact(this, addr, contact)
+	transferred := 0
+
+	for _, inst := range this.storedValues() {
+		if !this.shouldTransfer(inst.Hash, contact) {
+			continue
+		}
+
+		if stored, err := node.Store(inst); err == nil && stored {
+			transferred++
+		}
+	}
+
+	if transferred > 0 {
+		this.logger.Debug(node, "Transferred", transferred, "values")
+	}
+}
+
+// shouldTransfer tells if the contact is among the K closest to the hash
+// this node knows, itself included
+func (this *Dht) shouldTransfer(hash []byte, contact PacketContact) bool {
+	rank := 0
+
+	// this node counts, the contact itself is never closer than it
+	for _, known := range append(this.routing.FindNode(hash), PacketContact{Hash: this.hash}) {
+		if kad.Closer(known.Hash, contact.Hash, hash) {
+			rank++
+		}
+	}
+
+	return rank < this.tuned().K
+}
@@ -0,0 +1,252 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MEMORY_QUEUE = 1024
+)
+
+// Transport opens the sockets of a Dht. It defaults to UDP
+type Transport interface {
+	ListenPacket(network, addr string) (net.PacketConn, error)
+}
+
+type udpTransport struct {
+	reusePort bool
+}
+
+func (this udpTransport) ListenPacket(network, addr string) (net.PacketConn, error) {
+	if !this.reusePort {
+		return net.ListenPacket(network, addr)
+	}
+
+	config := net.ListenConfig{Control: reusePortControl}
+
+	return config.ListenPacket(context.Background(), network, addr)
+}
+
+func (this *Dht) transport() Transport {
+	if this.options.Transport == nil {
+		return udpTransport{reusePort: this.options.ReusePort}
+	}
+
+	return this.options.Transport
+}
+
+// LinkFunc decides the fate of a datagram between two addresses: its delay,
+// and whether it is dropped
+type LinkFunc func(from net.Addr, to net.Addr) (time.Duration, bool)
+
+// MemoryTransport routes the datagrams between the Dht instances of the
+// process that share it, without binding any port. Wildcard addresses are
+// bound to the loopback ones, and port 0 to a free port
+type MemoryTransport struct {
+	sync.RWMutex
+	conns    map[string]*memoryConn
+	nextPort int
+	link     LinkFunc
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		conns:    make(map[string]*memoryConn),
+		nextPort: 40000,
+	}
+}
+
+func (this *MemoryTransport) ListenPacket(network, addr string) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if udpAddr.IP == nil || udpAddr.IP.IsUnspecified() {
+		if network == "udp6" {
+			udpAddr.IP = net.IPv6loopback
+		} else {
+			udpAddr.IP = net.IPv4(127, 0, 0, 1)
+		}
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if udpAddr.Port == 0 {
+		for this.conns[net.JoinHostPort(udpAddr.IP.String(), strconv.Itoa(this.nextPort))] != nil {
+			this.nextPort++
+		}
+
+		udpAddr.Port = this.nextPort
+		this.nextPort++
+	}
+
+	if _, ok := this.conns[udpAddr.String()]; ok {
+		return nil, errors.New("Address already in use: " + udpAddr.String())
+	}
+
+	conn := &memoryConn{
+		transport: this,
+		addr:      udpAddr,
+		queue:     make(chan memoryDatagram, MEMORY_QUEUE),
+		closed:    make(chan struct{}),
+	}
+
+	this.conns[udpAddr.String()] = conn
+
+	return conn, nil
+}
+
+// SetLink injects latency and losses between the conns. A nil link delivers
+// every datagram at once
+func (this *MemoryTransport) SetLink(link LinkFunc) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.link = link
+}
+
+// deliver queues the datagram for the conn bound to addr, dropping it like
+// UDP would when there is none or when its queue is full
+func (this *MemoryTransport) deliver(from net.Addr, to net.Addr, datagram []byte) {
+	this.RLock()
+	link := this.link
+	this.RUnlock()
+
+	datagram = append([]byte{}, datagram...)
+
+	if link == nil {
+		this.enqueue(from, to, datagram)
+
+		return
+	}
+
+	delay, drop := link(from, to)
+
+	if drop {
+		return
+	}
+
+	if delay <= 0 {
+		this.enqueue(from, to, datagram)
+
+		return
+	}
+
+	time.AfterFunc(delay, func() {
+		this.enqueue(from, to, datagram)
+	})
+}
+
+func (this *MemoryTransport) enqueue(from net.Addr, to net.Addr, datagram []byte) {
+	this.RLock()
+	conn, ok := this.conns[to.String()]
+	this.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case conn.queue <- memoryDatagram{from: from, data: datagram}:
+	case <-conn.closed:
+	default:
+	}
+}
+
+func (this *MemoryTransport) remove(conn *memoryConn) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.conns[conn.addr.String()] == conn {
+		delete(this.conns, conn.addr.String())
+	}
+}
+
+type memoryDatagram struct {
+	from net.Addr
+	data []byte
+}
+
+type memoryConn struct {
+	sync.Mutex
+	transport *MemoryTransport
+	addr      *net.UDPAddr
+	queue     chan memoryDatagram
+	closed    chan struct{}
+	once      sync.Once
+	deadline  time.Time
+}
+
+func (this *memoryConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	this.Lock()
+	deadline := this.deadline
+	this.Unlock()
+
+	var timeout <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case datagram := <-this.queue:
+		return copy(buf, datagram.data), datagram.from, nil
+	case <-this.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, errors.New("i/o timeout")
+	}
+}
+
+func (this *memoryConn) WriteTo(buf []byte, addr net.Addr) (int, error) {
+	select {
+	case <-this.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	this.transport.deliver(this.addr, addr, buf)
+
+	return len(buf), nil
+}
+
+func (this *memoryConn) Close() error {
+	this.once.Do(func() {
+		close(this.closed)
+		this.transport.remove(this)
+	})
+
+	return nil
+}
+
+func (this *memoryConn) LocalAddr() net.Addr {
+	return this.addr
+}
+
+func (this *memoryConn) SetDeadline(t time.Time) error {
+	return this.SetReadDeadline(t)
+}
+
+func (this *memoryConn) SetReadDeadline(t time.Time) error {
+	this.Lock()
+	defer this.Unlock()
+
+	this.deadline = t
+
+	return nil
+}
+
+func (this *memoryConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TypedStore stores and fetches values of type T. They are gob encoded to
+// bytes, so the other nodes don't need to register T
+type TypedStore[T any] struct {
+	dht *Dht
+}
+
+func NewTypedStore[T any](dht *Dht) *TypedStore[T] {
+	return &TypedStore[T]{
+		dht: dht,
+	}
+}
+
+func (this *TypedStore[T]) Store(value T) ([]byte, int, error) {
+	blob, err := encodeTyped(value)
+
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	return this.dht.StoreAt(this.dht.NewHash(blob), blob)
+}
+
+func (this *TypedStore[T]) StoreAt(hash []byte, value T) ([]byte, int, error) {
+	return this.StoreAtTTL(hash, value, 0)
+}
+
+func (this *TypedStore[T]) StoreAtTTL(hash []byte, value T, ttl time.Duration) ([]byte, int, error) {
+	blob, err := encodeTyped(value)
+
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	return this.dht.StoreAtTTL(hash, blob, ttl)
+}
+
+func (this *TypedStore[T]) Fetch(hash []byte) (T, error) {
+	var value T
+
+	res, err := this.dht.Fetch(hash)
+
+	if err != nil {
+		return value, err
+	}
+
+	blob, ok := res.([]byte)
+
+	if !ok {
+		return value, errors.New("Not a typed value")
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(blob))
+
+	if err := dec.Decode(&value); err != nil {
+		return value, fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+
+	return value, nil
+}
+
+func encodeTyped(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	if err := enc.Encode(value); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,34 @@
+package dht
+
+import (
+	"errors"
+)
+
+var errAlreadyExists = errors.New("Already exists")
+
+// Validator decides which values can be stored. Validate rejects invalid
+// values, and Select picks the best record among conflicting ones
+type Validator interface {
+	Validate(key []byte, value interface{}) error
+	Select(key []byte, values []interface{}) int
+}
+
+type AcceptAllValidator struct{}
+
+func (this AcceptAllValidator) Validate(key []byte, value interface{}) error {
+	return nil
+}
+
+func (this AcceptAllValidator) Select(key []byte, values []interface{}) int {
+	return 0
+}
+
+func (this *Dht) validate(key []byte, value interface{}) error {
+	return this.options.Validator.Validate(key, value)
+}
+
+// selectNew tells if the validator prefers the incoming value over the
+// existing one
+func (this *Dht) selectNew(inst StoreInst, existing StoreInst) bool {
+	return this.selector(inst.Key).Select(inst.Hash, []interface{}{existing.Data, inst.Data}) == 1
+}
@@ -0,0 +1,170 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var errNotVersioned = errors.New("Not a versioned record")
+
+// VectorClock counts the writes of each node to a versioned record, by the
+// hex of its hash
+type VectorClock map[string]uint64
+
+// Increment returns a copy of the clock with one more write of id
+func (this VectorClock) Increment(id string) VectorClock {
+	res := this.Merge(nil)
+	res[id]++
+
+	return res
+}
+
+// Merge returns the smallest clock descending from both
+func (this VectorClock) Merge(other VectorClock) VectorClock {
+	res := make(VectorClock, len(this))
+
+	for id, count := range this {
+		res[id] = count
+	}
+
+	for id, count := range other {
+		if count > res[id] {
+			res[id] = count
+		}
+	}
+
+	return res
+}
+
+// Descends tells if the clock has seen every write other has
+func (this VectorClock) Descends(other VectorClock) bool {
+	for id, count := range other {
+		if this[id] < count {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Sibling is one of the concurrent versions of a versioned record. Time is
+// the wall clock of its write, for LastWriterWins
+type Sibling struct {
+	Clock VectorClock
+	Time  int64
+	Value interface{}
+}
+
+// VersionedRecord holds the versions of a value no other version descends
+// from. The storing nodes merge the incoming ones into it, so concurrent
+// writes are kept as siblings instead of overwriting each other
+type VersionedRecord struct {
+	Siblings []Sibling
+}
+
+// Resolver picks or merges the value of conflicting siblings
+type Resolver func(hash []byte, siblings []Sibling) interface{}
+
+// LastWriterWins resolves siblings to the value written last
+func LastWriterWins(hash []byte, siblings []Sibling) interface{} {
+	best := 0
+
+	for i, sibling := range siblings {
+		if sibling.Time > siblings[best].Time || (sibling.Time == siblings[best].Time && valueKey(sibling.Value) > valueKey(siblings[best].Value)) {
+			best = i
+		}
+	}
+
+	return siblings[best].Value
+}
+
+// Merge keeps the siblings of both records that no other one descends from
+func (this VersionedRecord) Merge(other CRDT) (CRDT, bool) {
+	record, ok := other.(VersionedRecord)
+
+	if !ok {
+		return nil, false
+	}
+
+	all := append(append([]Sibling{}, this.Siblings...), record.Siblings...)
+	res := VersionedRecord{}
+
+	for i, sibling := range all {
+		obsolete := false
+
+		for j, newer := range all {
+			if i == j || !newer.Clock.Descends(sibling.Clock) {
+				continue
+			}
+
+			// of two equal clocks, only the first is kept
+			if !sibling.Clock.Descends(newer.Clock) || j < i {
+				obsolete = true
+				break
+			}
+		}
+
+		if !obsolete {
+			res.Siblings = append(res.Siblings, sibling)
+		}
+	}
+
+	return res, true
+}
+
+// StoreVersioned stores value at the hash of key, as StoreKey does, as a new
+// version descending from clock, the one FetchVersioned returned. With a nil
+// clock, the value is a sibling of any version already stored
+func (this *Dht) StoreVersioned(key string, value interface{}, clock VectorClock) ([]byte, int, error) {
+	record := VersionedRecord{
+		Siblings: []Sibling{{
+			Clock: clock.Increment(hex.EncodeToString(this.hash)),
+			Time:  time.Now().UnixNano(),
+			Value: value,
+		}},
+	}
+
+	return this.StoreKey(key, record)
+}
+
+// FetchSiblings returns the concurrent versions of the value at the hash of
+// key, and the clock to store the next version with
+func (this *Dht) FetchSiblings(key string) ([]Sibling, VectorClock, error) {
+	value, err := this.FetchKey(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record, ok := value.(VersionedRecord)
+
+	if !ok || len(record.Siblings) == 0 {
+		return nil, nil, errNotVersioned
+	}
+
+	clock := VectorClock{}
+
+	for _, sibling := range record.Siblings {
+		clock = clock.Merge(sibling.Clock)
+	}
+
+	return record.Siblings, clock, nil
+}
+
+// FetchVersioned returns the value at the hash of key, its siblings resolved
+// by the Resolver, and the clock to store the next version with. Storing the
+// resolved value with it replaces the siblings
+func (this *Dht) FetchVersioned(key string) (interface{}, VectorClock, error) {
+	siblings, clock, err := this.FetchSiblings(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(siblings) == 1 {
+		return siblings[0].Value, clock, nil
+	}
+
+	return this.options.Resolver(this.KeyHash(key), siblings), clock, nil
+}
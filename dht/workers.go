@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"net"
+)
+
+const (
+	INBOUND_WORKERS = 64
+	INBOUND_QUEUE   = 1024
+)
+
+// QueuePolicy is what the read loop does when every worker is busy and the
+// inbound queue is full
+type QueuePolicy int
+
+const (
+	// QUEUE_DROP drops the datagram, like a full socket buffer would
+	QUEUE_DROP QueuePolicy = iota
+	// QUEUE_BLOCK stops reading until a worker is free, leaving the packets
+	// to pile up in the socket buffer
+	QUEUE_BLOCK
+	// QUEUE_DROP_OLDEST drops the datagram waiting the longest to make room
+	QUEUE_DROP_OLDEST
+)
+
+type inboundPacket struct {
+	addr     net.Addr
+	datagram *[MAX_DATAGRAM_SIZE]byte
+	size     int
+}
+
+type inboundQueue struct {
+	packets chan inboundPacket
+	done    chan struct{}
+}
+
+// startWorkers handles the inbound packets on a bounded pool, so that a slow
+// handler never stalls the read loop
+func (this *Dht) startWorkers() {
+	inbound := &inboundQueue{
+		packets: make(chan inboundPacket, this.options.WorkerQueue),
+		done:    make(chan struct{}),
+	}
+
+	this.Lock()
+	this.inbound = inbound
+	this.Unlock()
+
+	for i := 0; i < this.options.Workers; i++ {
+		go func() {
+			for {
+				select {
+				case packet := <-inbound.packets:
+					this.handleInPacket(packet.addr, packet.datagram[:packet.size], nil)
+					putDatagram(packet.datagram)
+				case <-inbound.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (this *Dht) stopWorkers() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.inbound != nil {
+		close(this.inbound.done)
+		this.inbound = nil
+	}
+}
+
+func (this *Dht) enqueue(inbound *inboundQueue, addr net.Addr, datagram *[MAX_DATAGRAM_SIZE]byte, size int) {
+	packet := inboundPacket{addr: addr, datagram: datagram, size: size}
+
+	if this.options.QueuePolicy == QUEUE_BLOCK {
+		select {
+		case inbound.packets <- packet:
+		case <-inbound.done:
+			putDatagram(datagram)
+		}
+
+		return
+	}
+
+	for {
+		select {
+		case inbound.packets <- packet:
+			return
+		default:
+		}
+
+		if this.options.QueuePolicy != QUEUE_DROP_OLDEST {
+			break
+		}
+
+		select {
+		case oldest := <-inbound.packets:
+			putDatagram(oldest.datagram)
+			this.counters.countDropped()
+			this.logger.Debug("Inbound queue full, dropped packet from", oldest.addr.String())
+		default:
+		}
+	}
+
+	putDatagram(datagram)
+	this.counters.countDropped()
+	this.logger.Debug("Inbound queue full, dropped packet from", addr.String())
+}
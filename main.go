@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
@@ -74,7 +75,7 @@ func storeAt(node *dht.Dht, hashStr string) {
 		return
 	}
 
-	hash := dht.NewHash([]byte(hashStr))
+	hash := node.NewHash([]byte(hashStr))
 
 	hash, nb, err := node.StoreAt(hash, res)
 
@@ -114,7 +115,7 @@ func fetchFromHash(node *dht.Dht, hashStr string) {
 }
 
 func fetchAt(node *dht.Dht, hashStr string) {
-	hash := dht.NewHash([]byte(hashStr))
+	hash := node.NewHash([]byte(hashStr))
 
 	res, err := node.Fetch(hash)
 
@@ -142,7 +143,12 @@ func listenExitSignals(client *dht.Dht) {
 }
 
 func exitProperly(client *dht.Dht) {
-	client.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if err := client.Stop(ctx); err != nil {
+		client.Logger().Warning("Stop:", err)
+	}
 }
 
 func cluster(options dht.DhtOptions) {
@@ -153,7 +159,7 @@ func cluster(options dht.DhtOptions) {
 
 		network = append(network, client)
 
-		options.BootstrapAddr = options.ListenAddr
+		options.BootstrapAddr = []string{options.ListenAddr}
 
 		i++
 	}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+	"github.com/champii/go-dht/dht/simulator"
+)
+
+const (
+	LOOKUPS      = 8
+	MAX_DURATION = time.Second * 30
+	SLOW_LINK    = time.Millisecond * 300
+)
+
+// 30 nodes, a tenth of them replaced every 2 seconds, while concurrent
+// lookups keep running. A failing node must only fail the requests sent to
+// it, so every lookup has to return, found or not
+func main() {
+	checkPendingCalls()
+
+	sim := simulator.New(simulator.Config{
+		Nodes:   30,
+		Latency: time.Millisecond * 5,
+		Options: dht.DhtOptions{NoRepublishOnExit: true},
+	})
+
+	if err := sim.Start(); err != nil {
+		fmt.Println("Error starting:", err)
+
+		os.Exit(1)
+	}
+
+	defer sim.Stop()
+
+	if _, err := sim.Converge(5, time.Minute); err != nil {
+		fmt.Println("Error:", err)
+
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	var lookups, longest int64
+
+	for i := 0; i < LOOKUPS; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			random := rand.New(rand.NewSource(int64(i)))
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				alive := sim.Alive()
+
+				if len(alive) == 0 {
+					time.Sleep(time.Millisecond * 10)
+
+					continue
+				}
+
+				node := sim.Node(alive[random.Intn(len(alive))])
+
+				if node == nil {
+					continue
+				}
+
+				start := time.Now()
+
+				node.Fetch(dht.NewHash([]byte("lookup-" + strconv.Itoa(random.Int()))))
+
+				took := int64(time.Since(start))
+
+				for {
+					max := atomic.LoadInt64(&longest)
+
+					if took <= max || atomic.CompareAndSwapInt64(&longest, max, took) {
+						break
+					}
+				}
+
+				atomic.AddInt64(&lookups, 1)
+			}
+		}(i)
+	}
+
+	res := sim.Churn(simulator.ChurnConfig{
+		Rate:     0.1,
+		Interval: time.Second * 2,
+		Rounds:   5,
+		Samples:  20,
+	})
+
+	close(done)
+
+	returned := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(MAX_DURATION):
+		fmt.Println("Error: lookups still blocked after", MAX_DURATION)
+
+		os.Exit(1)
+	}
+
+	fmt.Println(atomic.LoadInt64(&lookups), "concurrent lookups, the longest in", time.Duration(atomic.LoadInt64(&longest)))
+
+	for i, round := range res.Rounds {
+		fmt.Printf("Round %d: %d killed, %d restarted, %d/%d fetched\n", i, round.Killed, round.Restarted, round.Fetched, res.Stored)
+	}
+
+	if rate := res.SuccessRate(); rate < 0.8 {
+		fmt.Printf("Error: success rate under churn %.2f\n", rate)
+
+		os.Exit(1)
+	}
+
+	fmt.Println("Churn OK")
+}
+
+// checkPendingCalls opens the circuit of a peer that drops every packet while
+// calls to it and to a slow peer are pending. The other call to the dropping
+// peer fails at once, and the one to the slow peer still gets its answer
+func checkPendingCalls() {
+	transport := dht.NewMemoryTransport()
+
+	dropping := start(transport, "127.0.0.1:4000", nil)
+	slow := start(transport, "127.0.0.1:4001", nil)
+	client := start(transport, "127.0.0.1:4002", []string{"127.0.0.1:4000", "127.0.0.1:4001"})
+
+	defer dropping.Stop(context.Background())
+	defer slow.Stop(context.Background())
+	defer client.Stop(context.Background())
+
+	transport.SetLink(func(from net.Addr, to net.Addr) (time.Duration, bool) {
+		switch to.String() {
+		case "127.0.0.1:4000":
+			return 0, true
+		case "127.0.0.1:4001":
+			return SLOW_LINK, false
+		}
+
+		return 0, false
+	})
+
+	droppingAddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:4000")
+	slowAddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:4001")
+
+	calls := []struct {
+		name     string
+		node     *dht.Node
+		expected error
+	}{
+		{"Slow peer", dht.NewNode(client, slowAddr, []byte{}).WithTimeout(MAX_DURATION, 0), nil},
+		{"Pending call to the dropping peer", dht.NewNode(client, droppingAddr, []byte{}).WithTimeout(MAX_DURATION, 0), dht.ErrCircuitOpen},
+		{"Dropping peer", dht.NewNode(client, droppingAddr, []byte{}).WithTimeout(SLOW_LINK/2, 0), dht.ErrTimeout},
+	}
+
+	res := make([]chan error, len(calls))
+
+	for i, call := range calls {
+		res[i] = make(chan error, 1)
+
+		go func(node *dht.Node, res chan error) {
+			res <- node.Ping()
+		}(call.node, res[i])
+	}
+
+	for i, call := range calls {
+		select {
+		case err := <-res[i]:
+			if (call.expected == nil && err != nil) || !errors.Is(err, call.expected) {
+				fmt.Println("Error:", call.name, "returned", err)
+
+				os.Exit(1)
+			}
+
+			fmt.Println(call.name, "OK:", err)
+		case <-time.After(MAX_DURATION):
+			fmt.Println("Error:", call.name, "still pending after", MAX_DURATION)
+
+			os.Exit(1)
+		}
+	}
+}
+
+func start(transport dht.Transport, addr string, bootstrap []string) *dht.Dht {
+	node := dht.New(dht.DhtOptions{
+		ListenAddr:        addr,
+		BootstrapAddr:     bootstrap,
+		Transport:         transport,
+		BreakerThreshold:  1,
+		NoRepublishOnExit: true,
+	})
+
+	if err := node.Start(); err != nil {
+		fmt.Println("Error starting node", addr, ":", err)
+
+		os.Exit(1)
+	}
+
+	return node
+}
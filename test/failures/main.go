@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+)
+
+const (
+	DEADLINE = time.Second * 2
+)
+
+// failingTransport is a MemoryTransport whose writes fail on demand
+type failingTransport struct {
+	*dht.MemoryTransport
+	failing atomic.Bool
+}
+
+type failingConn struct {
+	net.PacketConn
+	transport *failingTransport
+}
+
+func (this *failingTransport) ListenPacket(network, addr string) (net.PacketConn, error) {
+	conn, err := this.MemoryTransport.ListenPacket(network, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingConn{PacketConn: conn, transport: this}, nil
+}
+
+func (this *failingConn) WriteTo(buf []byte, addr net.Addr) (int, error) {
+	if this.transport.failing.Load() {
+		return 0, errors.New("Write failed")
+	}
+
+	return this.PacketConn.WriteTo(buf, addr)
+}
+
+// unregistered is unknown to gob, so a packet holding it cannot be encoded
+type unregistered struct {
+	Value int
+}
+
+// The failure paths of the RPCs return their error to the caller at once,
+// instead of blocking it or waiting for the timeout
+func main() {
+	transport := &failingTransport{MemoryTransport: dht.NewMemoryTransport()}
+
+	server := start(transport, "127.0.0.1:4000", nil)
+	client := start(transport, "127.0.0.1:4001", []string{"127.0.0.1:4000"})
+
+	defer server.Stop(context.Background())
+	defer client.Stop(context.Background())
+
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:4000")
+	node := dht.NewNode(client, addr, []byte{})
+
+	check("Encode error", dht.ErrEncode, func() error {
+		_, err := node.Custom(unregistered{Value: 42})
+
+		return err
+	})
+
+	transport.failing.Store(true)
+
+	check("Write error", dht.ErrTransport, func() error {
+		return node.Ping()
+	})
+
+	transport.failing.Store(false)
+
+	check("Recovered", nil, func() error {
+		return node.Ping()
+	})
+
+	if pending := client.Status().Pending; pending != 0 {
+		fmt.Println("Error:", pending, "calls left pending")
+
+		os.Exit(1)
+	}
+
+	fmt.Println("Failures OK")
+}
+
+// check fails unless the call returns the expected error before DEADLINE,
+// well under the RPC timeout
+func check(name string, expected error, call func() error) {
+	res := make(chan error, 1)
+
+	go func() {
+		res <- call()
+	}()
+
+	select {
+	case err := <-res:
+		if (expected == nil && err != nil) || !errors.Is(err, expected) {
+			fmt.Println("Error:", name, "returned", err)
+
+			os.Exit(1)
+		}
+
+		fmt.Println(name, "OK:", err)
+	case <-time.After(DEADLINE):
+		fmt.Println("Error:", name, "still blocked after", DEADLINE)
+
+		os.Exit(1)
+	}
+}
+
+func start(transport dht.Transport, addr string, bootstrap []string) *dht.Dht {
+	node := dht.New(dht.DhtOptions{
+		ListenAddr:        addr,
+		BootstrapAddr:     bootstrap,
+		Transport:         transport,
+		NoRepublishOnExit: true,
+	})
+
+	if err := node.Start(); err != nil {
+		fmt.Println("Error starting node", addr, ":", err)
+
+		os.Exit(1)
+	}
+
+	return node
+}
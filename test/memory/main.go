@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+)
+
+// A network of nodes in a single process, talking through a MemoryTransport
+func main() {
+	transport := dht.NewMemoryTransport()
+
+	network := []*dht.Dht{}
+
+	for i := 0; i < 20; i++ {
+		options := dht.DhtOptions{
+			ListenAddr:        "127.0.0.1:" + strconv.Itoa(3000+i),
+			Transport:         transport,
+			NoRepublishOnExit: true,
+		}
+
+		if i > 0 {
+			options.BootstrapAddr = []string{"127.0.0.1:3000"}
+		}
+
+		node := dht.New(options)
+
+		if err := node.Start(); err != nil {
+			fmt.Println("Error starting node", i, ":", err)
+
+			os.Exit(1)
+		}
+
+		network = append(network, node)
+	}
+
+	start := time.Now()
+
+	for i := 0; i < 100; i++ {
+		if _, _, err := network[i%len(network)].StoreAt(dht.NewHash([]byte(strconv.Itoa(i))), i); err != nil {
+			fmt.Println("Error storing", i, ":", err)
+
+			os.Exit(1)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		res, err := network[(i+7)%len(network)].Fetch(dht.NewHash([]byte(strconv.Itoa(i))))
+
+		if err != nil || res != i {
+			fmt.Println("Error fetching", i, ":", res, err)
+
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println("Stored and fetched 100 values on", len(network), "nodes in", time.Since(start))
+
+	for _, node := range network {
+		node.Stop(context.Background())
+	}
+}
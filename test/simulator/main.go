@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/champii/go-dht/dht"
+	"github.com/champii/go-dht/dht/simulator"
+)
+
+// 30 nodes with 5ms of latency and up to 5ms of jitter, then 5% of packet
+// loss, then split in two halves and healed
+func main() {
+	sim := simulator.New(simulator.Config{
+		Nodes:   30,
+		Latency: time.Millisecond * 5,
+		Jitter:  time.Millisecond * 5,
+		Options: dht.DhtOptions{NoRepublishOnExit: true},
+	})
+
+	start := time.Now()
+
+	if err := sim.Start(); err != nil {
+		fmt.Println("Error starting:", err)
+
+		os.Exit(1)
+	}
+
+	defer sim.Stop()
+
+	fmt.Println("Started in", time.Since(start))
+
+	took, err := sim.Converge(5, time.Minute)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println("Converged in", took)
+
+	if err := sim.AssertSuccessRate(0.9, 20); err != nil {
+		fmt.Println("Error:", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println("Lookups OK")
+
+	sim.SetLoss(0.05)
+
+	if err := sim.AssertSuccessRate(0.8, 20); err != nil {
+		fmt.Println("Error with 5% loss:", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println("Lookups with 5% loss OK")
+
+	sim.SetLoss(0)
+
+	halves := [][]int{{}, {}}
+
+	for i := 0; i < 30; i++ {
+		halves[i%2] = append(halves[i%2], i)
+	}
+
+	sim.Partition(halves...)
+	time.Sleep(time.Second * 2)
+	sim.Heal()
+
+	if err := sim.AssertSuccessRate(0.9, 20); err != nil {
+		fmt.Println("Error after healing:", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println("Lookups after partition OK")
+}
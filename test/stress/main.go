@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -79,7 +80,7 @@ func cluster(count int, options dht.DhtOptions) []*dht.Dht {
 
 		network = append(network, client)
 
-		options.BootstrapAddr = options.ListenAddr
+		options.BootstrapAddr = []string{options.ListenAddr}
 
 		i++
 	}
@@ -118,7 +119,7 @@ func stopNetwork(net []*dht.Dht) {
 	defer timeTrack(time.Now())
 
 	for _, node := range net {
-		node.Stop()
+		node.Stop(context.Background())
 	}
 }
 